@@ -0,0 +1,115 @@
+package main
+
+import (
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/address"
+	mcaPkg "email.mercata.com/internal/email/mca"
+	userPkg "email.mercata.com/internal/email/user"
+	noncePkg "email.mercata.com/internal/nonce"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// accountRequest makes an authenticated request to the private API at every
+// host of the account and returns the response bodies. The endpoint gets
+// the path prefix, domain and local part, then the escaped path arguments.
+func accountRequest(accountEmail, method, endpoint, hostOverride, body string, pathArgs ...string) []string {
+	localUser, err := userPkg.LocalUser(accountEmail)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", accountEmail, err)
+		os.Exit(1)
+	}
+
+	var bodies []string
+	for _, host := range accountHosts(accountEmail, hostOverride) {
+		res := accountDo(localUser, method, host, accountPath(accountEmail, endpoint, pathArgs...), body)
+		resBody, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read response: %s\n", err)
+			os.Exit(1)
+		}
+		if res.StatusCode != http.StatusOK {
+			fmt.Fprintf(os.Stderr, "Response code: %d\n", res.StatusCode)
+			os.Exit(1)
+		}
+		bodies = append(bodies, string(resBody))
+	}
+	return bodies
+}
+
+func accountPath(accountEmail, endpoint string, pathArgs ...string) string {
+	_, domain, localPart := address.ParseEmailAddress(accountEmail)
+	endpointArgs := []interface{}{consts.PRIVATE_API_PATH_PREFIX, domain, localPart}
+	for _, arg := range pathArgs {
+		endpointArgs = append(endpointArgs, url.PathEscape(arg))
+	}
+	return fmt.Sprintf(endpoint, endpointArgs...)
+}
+
+func accountHosts(accountEmail, hostOverride string) []string {
+	if hostOverride != "" {
+		return []string{hostOverride}
+	}
+	_, domain, localPart := address.ParseEmailAddress(accountEmail)
+	hosts, err := mcaPkg.LookupEmailHosts(domain, localPart)
+	if err != nil || len(hosts) == 0 {
+		fmt.Println("No hosts to contact")
+		os.Exit(1)
+	}
+	return hosts
+}
+
+// accountDo makes one authenticated request to the private API at the host,
+// leaving the response to the caller.
+func accountDo(localUser *userPkg.User, method, host, path, body string) *http.Response {
+	return accountDoWithHeader(localUser, method, host, path, body, nil)
+}
+
+// accountDoWithHeader is accountDo sending the extra header fields as well.
+func accountDoWithHeader(localUser *userPkg.User, method, host, path, body string, header http.Header) *http.Response {
+	client := http.Client{}
+	if !strings.HasPrefix(host, "http") {
+		host = "https://" + host
+	}
+	uri, err := url.ParseRequestURI(host + path)
+	if err != nil {
+		fmt.Printf("Valid URL is required '%s': %s\n", host+path, err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "Trying: ", uri.String())
+	req, err := http.NewRequest(method, uri.String(), strings.NewReader(body))
+	if err != nil {
+		fmt.Printf("Local error: %s\n", err)
+		os.Exit(1)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	n, err := noncePkg.ForUser(localUser)
+	if err != nil {
+		fmt.Printf("Local error: %s\n", err)
+		os.Exit(1)
+	}
+	req.Header.Set(consts.AUTHORIZATION_HEADER_NONCE, noncePkg.ToHeader(n))
+
+	res, err := client.Do(req)
+	if err != nil {
+		if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
+			fmt.Fprintf(os.Stderr, "Timeout error: %s\n", err)
+		} else if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
+			fmt.Fprintf(os.Stderr, "URL timeout error: %s\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "Other error: %s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "Could not query URL: %s\n", err)
+		os.Exit(1)
+	}
+	return res
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/email/address"
+	userPkg "email.mercata.com/internal/email/user"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const ENDPOINT_LOCAL_ACCOUNT = "/%s/%s/%s"
+const ENDPOINT_LOCAL_ACCOUNT_DELETION = "/%s/%s/%s/deletion"
+
+// go run cmd/client_api/* account-delete -user me@dejanstrbac.com -force-host http://127.0.0.1:4000 -yes
+func accountDeleteCommand(args []string) {
+	fs := flag.NewFlagSet("account-delete", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	yes := fs.Bool("yes", false, "confirm deleting the account with all its messages")
+	cancel := fs.Bool("cancel", false, "cancel a deletion still in its grace period")
+	status := fs.Bool("status", false, "show the pending deletion, if any")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	if *status {
+		for _, body := range accountRequest(safeUserAddress, http.MethodGet, ENDPOINT_LOCAL_ACCOUNT_DELETION, *hostOverride, "") {
+			fmt.Print(body)
+		}
+		return
+	}
+	if *cancel {
+		accountRequest(safeUserAddress, http.MethodDelete, ENDPOINT_LOCAL_ACCOUNT_DELETION, *hostOverride, "")
+		fmt.Printf("Deletion of %s cancelled\n", safeUserAddress)
+		return
+	}
+	if !*yes {
+		fmt.Printf("Deleting %s erases all its messages, links and profile, confirm with -yes\n", safeUserAddress)
+		os.Exit(1)
+	}
+
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+	path := accountPath(safeUserAddress, ENDPOINT_LOCAL_ACCOUNT)
+	for _, host := range accountHosts(safeUserAddress, *hostOverride) {
+		// Each host gets a confirmation of its own, they are short lived
+		confirmation, err := account.Confirm(localUser)
+		if err != nil {
+			fmt.Printf("Could not sign deletion: %s\n", err)
+			os.Exit(1)
+		}
+		header := http.Header{}
+		header.Set(consts.ACCOUNT_DELETION_HEADER, confirmation.ToHeader())
+		res := accountDoWithHeader(localUser, http.MethodDelete, host, path, "", header)
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not read response: %s\n", err)
+			os.Exit(1)
+		}
+		switch res.StatusCode {
+		case http.StatusOK:
+			fmt.Printf("Account %s deleted at %s\n", safeUserAddress, host)
+		case http.StatusAccepted:
+			fmt.Printf("Account %s to be deleted at %s, cancel with -cancel until then:\n%s", safeUserAddress, host, strings.TrimSpace(string(body))+"\n")
+		default:
+			fmt.Fprintf(os.Stderr, "Response code: %d\n", res.StatusCode)
+			os.Exit(1)
+		}
+	}
+}
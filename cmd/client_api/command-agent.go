@@ -0,0 +1,85 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/agent"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func agentCommand(args []string) {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+
+	accountEmails := fs.String("accounts", "", "comma separated accounts to unlock and serve")
+	socketPath := fs.String("socket", "", "socket path, defaults to $"+agent.AGENT_SOCKET_ENVIRONMENT_VARIABLE+" or ~/.email2/"+agent.AGENT_SOCKET_FILENAME)
+	listAccounts := fs.Bool("list", false, "lists the accounts served by the running agent")
+
+	fs.Parse(args)
+
+	if *socketPath != "" {
+		os.Setenv(agent.AGENT_SOCKET_ENVIRONMENT_VARIABLE, *socketPath)
+	}
+
+	if *listAccounts {
+		client, err := agent.Dial()
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+		defer client.Close()
+		accounts, err := client.Accounts()
+		if err != nil {
+			fmt.Println("Error: ", err)
+			os.Exit(1)
+		}
+		for _, account := range accounts {
+			fmt.Println(account)
+		}
+		return
+	}
+
+	if *accountEmails == "" {
+		fmt.Println("At least one account email address is required.")
+		os.Exit(1)
+	}
+
+	keyAgent := agent.New()
+	for _, accountEmail := range strings.Split(*accountEmails, ",") {
+		accountEmail = strings.TrimSpace(accountEmail)
+		if err := keyAgent.Unlock(accountEmail); err != nil {
+			fmt.Printf("Could not unlock keys of '%s': %s\n", accountEmail, err)
+			os.Exit(1)
+		}
+	}
+
+	path, err := agent.SocketPath()
+	if err != nil {
+		fmt.Println("Error: ", err)
+		os.Exit(1)
+	}
+	listener, err := agent.Listen(path)
+	if err != nil {
+		fmt.Println("Could not listen: ", err)
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Printf("Key agent serving %s at %s\n", strings.Join(keyAgent.Accounts(), ", "), path)
+	fmt.Printf("export %s=%s\n", agent.AGENT_SOCKET_ENVIRONMENT_VARIABLE, path)
+
+	err = keyAgent.Serve(listener)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		os.Exit(1)
+	}
+	fmt.Println("Key agent stopped")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"email.mercata.com/internal/email/address"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const ENDPOINT_LOCAL_AUDIT = "/%s/%s/%s/audit"
+
+// go run cmd/client_api/* audit-list -user me@dejanstrbac.com -since 2024-01-01T00:00:00Z -action link_deleted -force-host http://127.0.0.1:4000
+func auditListCommand(args []string) {
+	fs := flag.NewFlagSet("audit-list", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	since := fs.String("since", "", "list entries since the RFC3339 time only")
+	action := fs.String("action", "", "list entries of the action only, such as profile_changed or message_deleted")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	endpoint := ENDPOINT_LOCAL_AUDIT
+	query := url.Values{}
+	if *since != "" {
+		query.Set("since", *since)
+	}
+	if *action != "" {
+		query.Set("action", *action)
+	}
+	if len(query) > 0 {
+		// Escapes in the query are no formatting verbs of the endpoint
+		endpoint += "?" + strings.ReplaceAll(query.Encode(), "%", "%%")
+	}
+
+	fmt.Println("time,action,subject,request id,remote address,actor")
+	for _, body := range accountRequest(safeUserAddress, http.MethodGet, endpoint, *hostOverride, "") {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				fmt.Println(line)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"email.mercata.com/internal/email/address"
+	linksPkg "email.mercata.com/internal/email/links"
+	"email.mercata.com/internal/email/storage"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const ENDPOINT_LOCAL_BLOCKS_LIST = "/%s/%s/%s/blocks"
+const ENDPOINT_LOCAL_BLOCKS_ENTRY = "/%s/%s/%s/blocks/%s/%s"
+
+// go run cmd/client_api/* blocks-add -user me@dejanstrbac.com -contact spammer@example.com -force-host http://127.0.0.1:4000
+// go run cmd/client_api/* blocks-add -user me@dejanstrbac.com -key FINGERPRINT -force-host http://127.0.0.1:4000
+func blocksAddCommand(args []string) {
+	blocksChangeCommand("blocks-add", http.MethodPut, args)
+}
+
+// go run cmd/client_api/* blocks-remove -user me@dejanstrbac.com -contact spammer@example.com -force-host http://127.0.0.1:4000
+func blocksRemoveCommand(args []string) {
+	blocksChangeCommand("blocks-remove", http.MethodDelete, args)
+}
+
+func blocksChangeCommand(name, method string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	contactEmail := fs.String("contact", "", "contact whose link to block")
+	link := fs.String("link", "", "link to block")
+	fingerprint := fs.String("key", "", "signing key fingerprint to block")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	var kind, value string
+	switch {
+	case *contactEmail != "":
+		if !address.ValidEmailAddress(*contactEmail) {
+			fmt.Println("Error: bad contact email address format")
+			os.Exit(1)
+		}
+		safeContactAddress, _, _ := address.ParseEmailAddress(*contactEmail)
+		kind, value = storage.BLOCK_KIND_LINK, linksPkg.Make(safeUserAddress, safeContactAddress)
+	case *link != "":
+		kind, value = storage.BLOCK_KIND_LINK, *link
+	case *fingerprint != "":
+		kind, value = storage.BLOCK_KIND_KEY, *fingerprint
+	default:
+		fmt.Println("Error: one of -contact, -link or -key is required")
+		os.Exit(1)
+	}
+	entry, err := storage.BlockEntry(kind, value)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	parts := strings.SplitN(entry, storage.BLOCK_KIND_SEPARATOR, 2)
+	accountRequest(safeUserAddress, method, ENDPOINT_LOCAL_BLOCKS_ENTRY, *hostOverride, "", parts[0], parts[1])
+	if method == http.MethodDelete {
+		fmt.Printf("Unblocked %s\n", entry)
+	} else {
+		fmt.Printf("Blocked %s\n", entry)
+	}
+}
+
+// go run cmd/client_api/* blocks-list -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func blocksListCommand(args []string) {
+	fs := flag.NewFlagSet("blocks-list", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	for _, body := range accountRequest(safeUserAddress, http.MethodGet, ENDPOINT_LOCAL_BLOCKS_LIST, *hostOverride, "") {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				fmt.Println(line)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/keys"
+	"email.mercata.com/internal/email/pins"
+	profilePkg "email.mercata.com/internal/email/profile"
+	"email.mercata.com/internal/transparency"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// resolveContactProfile fetches the profile of a contact and checks its keys
+// against the pinned ones. Unverified key changes are only warned about,
+// unless strict, as when the keys are about to be used for encryption.
+func resolveContactProfile(contactAddress string, strict bool) (*profilePkg.Profile, error) {
+	safeAddress, domain, localPart := address.ParseEmailAddress(contactAddress)
+	profile, err := profilePkg.GetRemoteProfile(safeAddress, domain, localPart)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifyProfileKeyLog(profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: key log of '%s' does not back the served keys: %s\n", profile.RemoteHost, err)
+		if strict {
+			return profile, err
+		}
+	}
+
+	status, err := pins.Observe(profile)
+	switch status {
+	case pins.PIN_STATUS_NEW:
+		fmt.Fprintf(os.Stderr, "Pinned keys of '%s' on first use, verify them with contacts-verify\n", safeAddress)
+	case pins.PIN_STATUS_ROTATED:
+		fmt.Fprintf(os.Stderr, "Signing key of '%s' rotated, continuity confirmed by Last-Signing-Key\n", safeAddress)
+	case pins.PIN_STATUS_CHANGED:
+		fmt.Fprintf(os.Stderr, "WARNING: keys of '%s' changed and do not continue the pinned signing key\n", safeAddress)
+		if err == nil && strict {
+			err = pins.ErrorKeysChanged
+		}
+	}
+	return profile, err
+}
+
+// verifyProfileKeyLog checks that the profile keys are in the key log of
+// the host, and that the log is consistent with what was seen before.
+func verifyProfileKeyLog(profile *profilePkg.Profile) error {
+	if profile.KeyLogTreeHead == "" || profile.KeyLogInclusion == "" {
+		fmt.Fprintf(os.Stderr, "Host '%s' does not publish a key log\n", profile.RemoteHost)
+		return nil
+	}
+	return transparency.VerifyProfileKeys(profile.RemoteHost, profile.KeyLogTreeHead, profile.KeyLogInclusion,
+		profile.Address, profile.PublicSigningKeyBase64, profile.PublicEncryptionKeyBase64)
+}
+
+// go run cmd/client_api/* contacts-verify -account me@dejanstrbac.com -contact friend@dejanstrbac.com
+func contactsVerifyCommand(args []string) {
+	fs := flag.NewFlagSet("contacts-verify", flag.ExitOnError)
+	accountEmail := fs.String("account", "", "compare keys from the point of view of given account")
+	contactEmail := fs.String("contact", "", "contact whose keys are verified")
+	accept := fs.Bool("accept", false, "mark the current keys of the contact as verified")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) || !address.ValidEmailAddress(*contactEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	safeContact, domain, localPart := address.ParseEmailAddress(*contactEmail)
+
+	_, localSigningKey, err := keys.GetLocalSigningPublicKey(safeAddress)
+	if err != nil {
+		fmt.Printf("Could not read signing key of '%s': %s\n", safeAddress, err)
+		os.Exit(1)
+	}
+
+	profile, err := profilePkg.GetRemoteProfile(safeContact, domain, localPart)
+	if err != nil {
+		fmt.Printf("Profile could not be read: %s\n", err)
+		os.Exit(1)
+	}
+	if !profilePkg.IsFunctionalProfile(profile) {
+		fmt.Println("Error: profile has no signing key")
+		os.Exit(1)
+	}
+
+	pin, err := pins.Load(safeContact)
+	if err != nil {
+		fmt.Printf("Could not read pinned keys: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Contact:              ", safeContact)
+	fmt.Println("Status:               ", pins.Compare(pin, profile))
+	if pin != nil {
+		fmt.Println("Pinned Signing-Key:   ", pin.SigningKeyBase64)
+		fmt.Println("Pinned since:         ", pin.FirstSeen)
+		fmt.Println("Verified:             ", pin.Verified)
+	}
+	fmt.Println("Signing-Key:          ", profile.PublicSigningKeyBase64)
+	fmt.Println("Encryption-Key:       ", profile.PublicEncryptionKeyBase64)
+	fmt.Println("Encryption-Key fpr:   ", crypto.Fingerprint(profile.PublicEncryptionKey[:]))
+	if err := verifyProfileKeyLog(profile); err != nil {
+		fmt.Println("Key log:              ", err)
+	} else if profile.KeyLogTreeHead != "" {
+		fmt.Println("Key log:               keys included, log consistent")
+	}
+	fmt.Println()
+	fmt.Println("Safety number, compare it with the contact:")
+	fmt.Println()
+	fmt.Println("  ", pins.SafetyNumber(safeAddress, localSigningKey[:], safeContact, profile.PublicSigningKey[:]))
+	fmt.Println()
+
+	if !*accept {
+		fmt.Println("Run again with -accept once the numbers match.")
+		return
+	}
+	if err := pins.Verify(profile); err != nil {
+		fmt.Printf("Could not pin keys: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Keys of '%s' verified and pinned.\n", safeContact)
+}
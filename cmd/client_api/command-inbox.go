@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"email.mercata.com/internal/delegation"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/inbox"
+	linksPkg "email.mercata.com/internal/email/links"
+	messagePkg "email.mercata.com/internal/email/message"
+	"email.mercata.com/internal/email/storage"
+	userPkg "email.mercata.com/internal/email/user"
+	"email.mercata.com/internal/utils"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const ENDPOINT_LOCAL_FETCHER_KEY = "/%s/%s/%s/fetcher/key"
+const ENDPOINT_LOCAL_FETCHER_DELEGATIONS = "/%s/%s/%s/fetcher/delegations"
+const ENDPOINT_LOCAL_FETCHER_DELEGATION = "/%s/%s/%s/fetcher/delegations/%s"
+const ENDPOINT_LOCAL_FETCHER_FETCH = "/%s/%s/%s/fetcher/fetch/%s"
+const ENDPOINT_LOCAL_INBOX = "/%s/%s/%s/inbox"
+const ENDPOINT_LOCAL_INBOX_MESSAGE = "/%s/%s/%s/inbox/%s/%s"
+
+const DEFAULT_FETCH_DELEGATION_DAYS = 90
+
+// go run cmd/client_api/* fetcher-enable -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func fetcherEnableCommand(args []string) {
+	fs := flag.NewFlagSet("fetcher-enable", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	rotate := fs.Bool("rotate", false, "replace the fetch key, voiding all delegations")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+
+	path := accountPath(safeUserAddress, ENDPOINT_LOCAL_FETCHER_KEY)
+	for _, host := range accountHosts(safeUserAddress, *hostOverride) {
+		fetchKey, found := fetcherKey(localUser, host)
+		if !found || *rotate {
+			res := accountDo(localUser, http.MethodPost, host, path, "")
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil || res.StatusCode != http.StatusOK {
+				fmt.Fprintf(os.Stderr, "Could not create fetch key at %s: %d\n", host, res.StatusCode)
+				os.Exit(1)
+			}
+			fetchKey = strings.TrimSpace(string(body))
+		}
+		fmt.Printf("Fetch key at %s: %s\n", host, fetchKey)
+	}
+}
+
+// fetcherKey returns the fetch key of the account at the host, if it has one.
+func fetcherKey(localUser *userPkg.User, host string) (string, bool) {
+	res := accountDo(localUser, http.MethodGet, host, accountPath(localUser.Address, ENDPOINT_LOCAL_FETCHER_KEY), "")
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return "", false
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Could not read fetch key at %s: %d\n", host, res.StatusCode)
+		os.Exit(1)
+	}
+	return strings.TrimSpace(string(body)), true
+}
+
+// go run cmd/client_api/* fetcher-authorize -user me@dejanstrbac.com -author author@open.email -force-host http://127.0.0.1:4000
+func fetcherAuthorizeCommand(args []string) {
+	fs := flag.NewFlagSet("fetcher-authorize", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	authorEmail := fs.String("author", "", "author whose messages the server fetches")
+	days := fs.Int("days", DEFAULT_FETCH_DELEGATION_DAYS, "days until the delegation expires")
+	authorHosts := fs.String("author-host", "", "comma separated hosts of the author, looked up by the server if not given")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) || !address.ValidEmailAddress(*authorEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	expires := time.Now().Add(time.Duration(*days) * 24 * time.Hour)
+	if *days < 1 || expires.After(time.Now().Add(delegation.MAX_DELEGATION_TIME)) {
+		fmt.Printf("Error: delegations last from 1 to %d days\n", delegation.MAX_DELEGATION_TIME/(24*time.Hour))
+		os.Exit(1)
+	}
+	var hosts []string
+	if *authorHosts != "" {
+		hosts = strings.Split(*authorHosts, ",")
+		for _, host := range hosts {
+			if !inbox.ValidHost(host) {
+				fmt.Printf("Error: bad author host '%s'\n", host)
+				os.Exit(1)
+			}
+		}
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	safeAuthorAddress, _, _ := address.ParseEmailAddress(*authorEmail)
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+	link := linksPkg.Make(safeUserAddress, safeAuthorAddress)
+
+	// Every host fetches with a key of its own
+	for _, host := range accountHosts(safeUserAddress, *hostOverride) {
+		fetchKey, found := fetcherKey(localUser, host)
+		if !found {
+			fmt.Printf("No fetch key at %s, run fetcher-enable first\n", host)
+			os.Exit(1)
+		}
+		d, err := delegation.Sign(localUser, link, fetchKey, expires)
+		if err != nil {
+			fmt.Printf("Could not sign delegation: %s\n", err)
+			os.Exit(1)
+		}
+		record := strings.Join([]string{
+			inbox.FIELD_AUTHOR + ": " + safeAuthorAddress,
+			inbox.FIELD_HOSTS + ": " + strings.Join(hosts, " "),
+			inbox.FIELD_DELEGATION + ": " + d.ToHeader(),
+		}, "\n")
+		accountRequest(safeUserAddress, http.MethodPut, ENDPOINT_LOCAL_FETCHER_DELEGATION, host, record, link)
+		fmt.Printf("Server %s fetches messages of %s until %s\n", host, safeAuthorAddress, d.Expires)
+	}
+}
+
+// go run cmd/client_api/* fetcher-revoke -user me@dejanstrbac.com -author author@open.email -force-host http://127.0.0.1:4000
+func fetcherRevokeCommand(args []string) {
+	fs := flag.NewFlagSet("fetcher-revoke", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	authorEmail := fs.String("author", "", "author whose messages the server no longer fetches")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) || !address.ValidEmailAddress(*authorEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	safeAuthorAddress, _, _ := address.ParseEmailAddress(*authorEmail)
+
+	// The delegation stays valid until it expires, rotate the key to void it
+	accountRequest(safeUserAddress, http.MethodDelete, ENDPOINT_LOCAL_FETCHER_DELEGATION, *hostOverride, "", linksPkg.Make(safeUserAddress, safeAuthorAddress))
+	fmt.Printf("Fetching of %s stopped\n", safeAuthorAddress)
+}
+
+// go run cmd/client_api/* fetcher-list -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func fetcherListCommand(args []string) {
+	inboxPrintCommand("fetcher-list", ENDPOINT_LOCAL_FETCHER_DELEGATIONS, args)
+}
+
+// go run cmd/client_api/* fetcher-fetch -user me@dejanstrbac.com -author author@open.email -force-host http://127.0.0.1:4000
+func fetcherFetchCommand(args []string) {
+	fs := flag.NewFlagSet("fetcher-fetch", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	authorEmail := fs.String("author", "", "author whose messages to fetch now")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) || !address.ValidEmailAddress(*authorEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	safeAuthorAddress, _, _ := address.ParseEmailAddress(*authorEmail)
+
+	for _, body := range accountRequest(safeUserAddress, http.MethodPost, ENDPOINT_LOCAL_FETCHER_FETCH, *hostOverride, "", linksPkg.Make(safeUserAddress, safeAuthorAddress)) {
+		fmt.Printf("Fetched %s messages of %s\n", strings.TrimSpace(body), safeAuthorAddress)
+	}
+}
+
+// go run cmd/client_api/* inbox-list -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func inboxListCommand(args []string) {
+	inboxPrintCommand("inbox-list", ENDPOINT_LOCAL_INBOX, args)
+}
+
+func inboxPrintCommand(name, endpoint string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	for _, body := range accountRequest(safeUserAddress, http.MethodGet, endpoint, *hostOverride, "") {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				fmt.Println(line)
+			}
+		}
+	}
+}
+
+// go run cmd/client_api/* inbox-sync -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func inboxSyncCommand(args []string) {
+	fs := flag.NewFlagSet("inbox-sync", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	deleteSynced := fs.Bool("delete", false, "delete messages from the server inbox once synced")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+
+	synced := 0
+	for _, host := range accountHosts(safeUserAddress, *hostOverride) {
+		// Messages are kept locally by author, known from the delegations
+		authors := make(map[string]string)
+		for _, line := range strings.Fields(accountRequest(safeUserAddress, http.MethodGet, ENDPOINT_LOCAL_FETCHER_DELEGATIONS, host, "")[0]) {
+			parts := strings.Split(line, inbox.INBOX_COLUMN_SEPARATOR)
+			if len(parts) == 3 {
+				authors[parts[0]] = parts[1]
+			}
+		}
+
+		for _, line := range strings.Fields(accountRequest(safeUserAddress, http.MethodGet, ENDPOINT_LOCAL_INBOX, host, "")[0]) {
+			parts := strings.Split(line, inbox.INBOX_COLUMN_SEPARATOR)
+			if len(parts) != 2 || !utils.ValidMessageID(parts[1]) {
+				continue
+			}
+			link, messageID := parts[0], parts[1]
+			author, found := authors[link]
+			if !found {
+				fmt.Fprintf(os.Stderr, "Skipping message %s of revoked link %s\n", messageID, link)
+				continue
+			}
+			_, exists, err := storage.LocalTempMessageExists(author, messageID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error verifying temp directory existence: %s\n", err)
+				os.Exit(1)
+			}
+			if !exists {
+				err = inboxSyncMessage(localUser, host, author, link, messageID)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Could not sync message %s: %s\n", messageID, err)
+					continue
+				}
+				synced++
+				fmt.Printf("Message %s of %s synced\n", messageID, author)
+			}
+			if *deleteSynced {
+				accountRequest(safeUserAddress, http.MethodDelete, ENDPOINT_LOCAL_INBOX_MESSAGE, host, "", link, messageID)
+			}
+		}
+	}
+	fmt.Printf("Synced %d messages\n", synced)
+}
+
+// inboxSyncMessage stores the message as messages-fetch would have from
+// the author.
+func inboxSyncMessage(localUser *userPkg.User, host, author, link, messageID string) error {
+	res := accountDo(localUser, http.MethodGet, host, accountPath(localUser.Address, ENDPOINT_LOCAL_INBOX_MESSAGE, link, messageID), "")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("response code %d", res.StatusCode)
+	}
+	envelope, err := messagePkg.MessageFromHeadersData(res.Header)
+	if err != nil {
+		return err
+	}
+
+	messagePath, err := storage.CreateLocalTempMessageDir(author, messageID)
+	if err != nil {
+		return err
+	}
+	payloadPath, err := storage.LocalTempMessagePayloadPath(author, messageID)
+	if err != nil {
+		return err
+	}
+	envelopePath, err := storage.LocalTempMessageEnvelopePath(author, messageID)
+	if err != nil {
+		return err
+	}
+
+	payloadFile, err := os.Create(payloadPath)
+	if err != nil {
+		os.RemoveAll(messagePath)
+		return err
+	}
+	_, err = io.Copy(payloadFile, res.Body)
+	payloadFile.Close()
+	if err == nil {
+		err = os.WriteFile(envelopePath, []byte(strings.Join(envelope.EnvelopeHeadersList, "\n")+"\n"), 0644)
+	}
+	if err != nil {
+		// A partial message would pass as synced next time
+		os.RemoveAll(messagePath)
+	}
+	return err
+}
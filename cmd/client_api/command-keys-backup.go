@@ -0,0 +1,259 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/keys"
+	messagePkg "email.mercata.com/internal/email/message"
+	userPkg "email.mercata.com/internal/email/user"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const KEY_SHARE_MESSAGE_CATEGORY = "encryption-key"
+
+// go run cmd/client_api/* keys-backup -account me@dejanstrbac.com -shares 5 -threshold 3 -output-dir /tmp/shares
+func keysBackupCommand(args []string) {
+	fs := flag.NewFlagSet("keys-backup", flag.ExitOnError)
+	accountEmail := fs.String("account", "", "back up private keys of given account")
+	sharesCount := fs.Int("shares", 5, "number of shares to make")
+	threshold := fs.Int("threshold", 3, "number of shares required for recovery")
+	outputDir := fs.String("output-dir", "", "save shares in directory")
+	contactEmails := fs.String("contacts", "", "comma separated contacts, one per share, to encrypt shares for")
+	sealMessages := fs.Bool("seal", false, "author a message of category '"+KEY_SHARE_MESSAGE_CATEGORY+"' to each contact with its share")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	if *outputDir == "" {
+		fmt.Println("'-output-dir' path is required")
+		os.Exit(1)
+	}
+
+	var contacts []string
+	if *contactEmails != "" {
+		for _, contact := range strings.Split(*contactEmails, ",") {
+			contact = strings.TrimSpace(contact)
+			if !address.ValidEmailAddress(contact) {
+				fmt.Printf("Invalid contact '%s'\n", contact)
+				os.Exit(1)
+			}
+			safeContact, _, _ := address.ParseEmailAddress(contact)
+			contacts = append(contacts, safeContact)
+		}
+		if len(contacts) != *sharesCount {
+			fmt.Printf("%d contacts given for %d shares\n", len(contacts), *sharesCount)
+			os.Exit(1)
+		}
+	} else if *sealMessages {
+		fmt.Println("'-seal' requires '-contacts'")
+		os.Exit(1)
+	}
+
+	shares, err := keys.SplitLocalKeys(safeAddress, *sharesCount, *threshold)
+	if err != nil {
+		fmt.Printf("Could not split keys of '%s': %s\n", safeAddress, err)
+		os.Exit(1)
+	}
+
+	err = os.MkdirAll(*outputDir, 0700)
+	if err != nil {
+		fmt.Printf("Could not create output-dir: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf(" Key Shares (%s), %d of %d required\n", safeAddress, *threshold, *sharesCount)
+	fmt.Println(" ==============================================================")
+	for i, share := range shares {
+		filename := fmt.Sprintf("%s.share-%d", safeAddress, share.Index())
+		data := share.ToText()
+
+		if len(contacts) > 0 {
+			contact := contacts[i]
+			// Shares must not end up with a key swapped in by the contact's server
+			contactProfile, err := resolveContactProfile(contact, true)
+			if err != nil || contactProfile == nil || contactProfile.PublicEncryptionKeyBase64 == "" {
+				fmt.Printf("Could not read profile encryption key of '%s': %s\n", contact, err)
+				os.Exit(1)
+			}
+			data, err = share.EncryptFor(contact, contactProfile.PublicEncryptionKey)
+			if err != nil {
+				fmt.Printf("Could not encrypt share for '%s': %s\n", contact, err)
+				os.Exit(1)
+			}
+			filename += "." + contact
+		}
+
+		sharePath, err := filepath.Abs(filepath.Join(*outputDir, filename))
+		if err != nil {
+			fmt.Printf("Bad output-dir path: %s\n", err)
+			os.Exit(1)
+		}
+		err = ioutil.WriteFile(sharePath, []byte(data), 0600)
+		if err != nil {
+			fmt.Printf("Could not save share: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(" Share  \t" + sharePath)
+
+		if *sealMessages {
+			messagePath, err := sealKeyShareMessage(safeAddress, contacts[i], data)
+			if err != nil {
+				fmt.Printf("Could not author share message for '%s': %s\n", contacts[i], err)
+				os.Exit(1)
+			}
+			fmt.Println(" Message\t" + messagePath)
+		}
+	}
+}
+
+func sealKeyShareMessage(authorAddress, contact, encryptedShare string) (string, error) {
+	msg, err := messagePkg.NewMessage(authorAddress)
+	if err != nil {
+		return "", err
+	}
+	msg.SetSubject("Key backup share")
+	msg.SetCategory(KEY_SHARE_MESSAGE_CATEGORY)
+	err = msg.AddReader(contact)
+	if err != nil {
+		return "", err
+	}
+	msg.SetPlainContent([]byte(encryptedShare))
+	return msg.Seal()
+}
+
+// go run cmd/client_api/* keys-share-open -account friend@dejanstrbac.com -share /tmp/me@dejanstrbac.com.share-2.friend@dejanstrbac.com
+func keysShareOpenCommand(args []string) {
+	fs := flag.NewFlagSet("keys-share-open", flag.ExitOnError)
+	accountEmail := fs.String("account", "", "decrypt with the keys of given account")
+	sharePath := fs.String("share", "", "read the encrypted share from given file")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	data, err := ioutil.ReadFile(*sharePath)
+	if err != nil {
+		fmt.Printf("Could not read share '%s': %s\n", *sharePath, err)
+		os.Exit(1)
+	}
+	recipient, ciphertext, err := keys.EncryptedKeyShareFromText(string(data))
+	if err != nil {
+		fmt.Printf("Not an encrypted share '%s': %s\n", *sharePath, err)
+		os.Exit(1)
+	}
+	if recipient != "" && recipient != safeAddress {
+		fmt.Printf("Share is encrypted for '%s'\n", recipient)
+		os.Exit(1)
+	}
+
+	localUser, err := userPkg.LocalUser(safeAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeAddress, err)
+		os.Exit(1)
+	}
+	plaintext, err := localUser.DecryptAnonymous(ciphertext)
+	if err != nil {
+		fmt.Printf("Could not decrypt share: %s\n", err)
+		os.Exit(1)
+	}
+	if _, err := keys.KeyShareFromText(string(plaintext)); err != nil {
+		fmt.Printf("Decrypted share is not valid: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(string(plaintext))
+}
+
+// go run cmd/client_api/* keys-recover -account me@dejanstrbac.com -shares /tmp/share-1,/tmp/share-3,/tmp/share-4
+func keysRecoverCommand(args []string) {
+	fs := flag.NewFlagSet("keys-recover", flag.ExitOnError)
+	accountEmail := fs.String("account", "", "recover private keys of given account")
+	sharePaths := fs.String("shares", "", "comma separated share files")
+	overwrite := fs.Bool("overwrite", false, "overwriting existing keys")
+	storePlaintext := fs.Bool("plaintext", false, "store private keys without passphrase protection")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	if *sharePaths == "" {
+		fmt.Println("'-shares' files are required")
+		os.Exit(1)
+	}
+
+	var shares []*keys.KeyShare
+	for _, sharePath := range strings.Split(*sharePaths, ",") {
+		data, err := ioutil.ReadFile(strings.TrimSpace(sharePath))
+		if err != nil {
+			fmt.Printf("Could not read share '%s': %s\n", sharePath, err)
+			os.Exit(1)
+		}
+		share, err := keys.KeyShareFromText(string(data))
+		if err != nil {
+			fmt.Printf("Bad share '%s': %s\n", sharePath, err)
+			os.Exit(1)
+		}
+		if share.Account != "" && share.Account != safeAddress {
+			fmt.Printf("Share '%s' belongs to '%s'\n", sharePath, share.Account)
+			os.Exit(1)
+		}
+		shares = append(shares, share)
+	}
+
+	recovered, err := keys.RecoverKeys(shares)
+	if err != nil {
+		fmt.Printf("Could not recover keys: %s\n", err)
+		os.Exit(1)
+	}
+
+	var passphrase []byte
+	if !*storePlaintext {
+		passphrase, err = readNewPassphrase(safeAddress)
+		if err != nil {
+			fmt.Println("Could not read passphrase: ", err)
+			os.Exit(1)
+		}
+	}
+
+	var paths []string
+	path, err := keys.StoreLocalEncryptionPrivateKey(safeAddress, recovered.PrivateEncryptionKeyBase64, passphrase, *overwrite)
+	paths = append(paths, path)
+	if err == nil {
+		path, err = keys.StoreLocalEncryptionPublicKey(safeAddress, recovered.PublicEncryptionKeyBase64, *overwrite)
+		paths = append(paths, path)
+	}
+	if err == nil {
+		path, err = keys.StoreLocalSigningPrivateKey(safeAddress, recovered.PrivateSigningKeyBase64, passphrase, *overwrite)
+		paths = append(paths, path)
+	}
+	if err == nil {
+		path, err = keys.StoreLocalSigningPublicKey(safeAddress, recovered.PublicSigningKeyBase64, *overwrite)
+		paths = append(paths, path)
+	}
+	if err != nil {
+		fmt.Printf("Could not save key '%s': %s\n", path, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf(" Recovered Keys (%s)\n", safeAddress)
+	fmt.Println(" ==============================================================")
+	fmt.Println(" Encryption \t" + recovered.PublicEncryptionKeyBase64)
+	fmt.Println(" Signing    \t" + recovered.PublicSigningKeyBase64)
+	fmt.Println()
+	for _, path := range paths {
+		fmt.Println("        \t" + path)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"email.mercata.com/internal/crypto"
 	"email.mercata.com/internal/email/address"
 	"email.mercata.com/internal/email/keys"
-	"email.mercata.com/internal/email/profile"
 	"flag"
 	"fmt"
 	"os"
@@ -16,6 +15,7 @@ func keysGenCommand(args []string) {
 	generateEncKeyPair := fs.Bool("encryption", true, "generates only a new encryption key pair")
 	generateSigKeyPair := fs.Bool("signing", true, "generates only a new signing key pair")
 	generateForceOverwrite := fs.Bool("overwrite", false, "overwriting existing keys")
+	storePlaintext := fs.Bool("plaintext", false, "store private keys without passphrase protection")
 	accountEmail := fs.String("account", "", "saves generated key pair for given account")
 
 	fs.Parse(args)
@@ -32,11 +32,21 @@ func keysGenCommand(args []string) {
 
 	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
 
+	var passphrase []byte
+	if !*storePlaintext {
+		var err error
+		passphrase, err = readNewPassphrase(safeAddress)
+		if err != nil {
+			fmt.Println("Could not read passphrase: ", err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Println()
 
 	if *generateEncKeyPair {
 		privKeyEncodedStr, pubKeyEncodedStr := crypto.GenerateEncryptionKeys()
-		privKeyPath, err := keys.StoreLocalEncryptionPrivateKey(safeAddress, privKeyEncodedStr, *generateForceOverwrite)
+		privKeyPath, err := keys.StoreLocalEncryptionPrivateKey(safeAddress, privKeyEncodedStr, passphrase, *generateForceOverwrite)
 		if err != nil {
 			fmt.Println("Could not save private encryption key: ", err)
 			os.Exit(1)
@@ -57,7 +67,7 @@ func keysGenCommand(args []string) {
 
 	if *generateSigKeyPair {
 		privKeyEncodedStr, pubKeyEncodedStr := crypto.GenerateSigningKeys()
-		privKeyPath, err := keys.StoreLocalSigningPrivateKey(safeAddress, privKeyEncodedStr, *generateForceOverwrite)
+		privKeyPath, err := keys.StoreLocalSigningPrivateKey(safeAddress, privKeyEncodedStr, passphrase, *generateForceOverwrite)
 		if err != nil {
 			fmt.Println("Could not save private signing key: ", err)
 			os.Exit(1)
@@ -76,6 +86,57 @@ func keysGenCommand(args []string) {
 	}
 }
 
+// go run cmd/client_api/* keys-protect -account me@dejanstrbac.com
+func keysProtectCommand(args []string) {
+	fs := flag.NewFlagSet("keys-protect", flag.ExitOnError)
+	accountEmail := fs.String("account", "", "protect plaintext private keys of given account")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+
+	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	var plaintextSuffixes []string
+	for _, suffix := range keys.PRIVATE_KEY_SUFFIXES {
+		protected, err := keys.LocalKeyIsProtected(safeAddress, suffix)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			fmt.Printf("Could not read key '%s': %s\n", suffix, err)
+			os.Exit(1)
+		}
+		if protected {
+			fmt.Printf(" Already protected \t%s\n", suffix)
+			continue
+		}
+		plaintextSuffixes = append(plaintextSuffixes, suffix)
+	}
+
+	if len(plaintextSuffixes) == 0 {
+		fmt.Println("No plaintext private keys to protect.")
+		return
+	}
+
+	passphrase, err := readNewPassphrase(safeAddress)
+	if err != nil {
+		fmt.Println("Could not read passphrase: ", err)
+		os.Exit(1)
+	}
+
+	for _, suffix := range plaintextSuffixes {
+		keyPath, _, err := keys.ProtectLocalKey(safeAddress, suffix, passphrase)
+		if err != nil {
+			fmt.Printf("Could not protect key '%s': %s\n", keyPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf(" Protected \t%s\n", keyPath)
+	}
+}
+
 func keysLookupCommand(args []string) {
 	fs := flag.NewFlagSet("keys-lookup", flag.ExitOnError)
 
@@ -88,11 +149,11 @@ func keysLookupCommand(args []string) {
 		os.Exit(1)
 	}
 
-	safeAddress, domain, user := address.ParseEmailAddress(*accountEmail)
+	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
 
-	profile, err := profile.GetRemoteProfile(safeAddress, domain, user)
+	profile, err := resolveContactProfile(safeAddress, false)
 	if err != nil || profile == nil {
-		fmt.Println("Error: profile could not be read")
+		fmt.Println("Error: profile could not be read: ", err)
 		return
 	}
 
@@ -127,7 +127,7 @@ func linksListCommand(args []string) {
 			if line == "" {
 				continue
 			}
-			contact, err := crypto.DecryptAnonymous(localUser.PrivateEncryptionKey, localUser.PublicEncryptionKey, line)
+			contact, err := localUser.DecryptAnonymous(line)
 			if err != nil {
 				fmt.Println("line could not be decrypted: ", line)
 				continue
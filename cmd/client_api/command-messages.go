@@ -355,6 +355,7 @@ func messagesStoreCommand(args []string) {
 	accountEmail := fs.String("user", "", "update profile for given user")
 	sourcePath := fs.String("message-path", "", "read message data from path")
 	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	notifyReaders := fs.Bool("notify-readers", true, "queue notifications for the readers, delivered by outbox-run")
 	fs.Parse(args)
 
 	if !address.ValidEmailAddress(*accountEmail) {
@@ -487,6 +488,15 @@ func messagesStoreCommand(args []string) {
 		}
 	}
 	fmt.Println("Message stored")
+
+	if *notifyReaders && !message.IsBroadcast {
+		count, err := enqueueReaderNotifications(authorUser, *sourcePath)
+		if err != nil {
+			fmt.Printf("Could not queue reader notifications: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Queued notifications for %d readers, deliver them with outbox-run\n", count)
+	}
 }
 
 func writeEnvelopeAsRequestHeaders(envelopeContent []byte, req *http.Request) error {
@@ -734,7 +744,7 @@ func messagesOpenCommand(args []string) {
 		os.Exit(1)
 	}
 
-	authorUser, err := userPkg.LocalUser(*authorEmail)
+	authorUser, err := userPkg.LocalPublicUser(*authorEmail)
 	if err != nil {
 		fmt.Printf("Could not initialize local author '%s': %s\n", *authorEmail, err)
 		os.Exit(1)
@@ -7,8 +7,12 @@ import (
 	"email.mercata.com/internal/email/address"
 	linksPkg "email.mercata.com/internal/email/links"
 	mcaPkg "email.mercata.com/internal/email/mca"
+	notificationPkg "email.mercata.com/internal/email/notification"
+	"email.mercata.com/internal/email/pins"
 	userPkg "email.mercata.com/internal/email/user"
 	noncePkg "email.mercata.com/internal/nonce"
+	stampPkg "email.mercata.com/internal/stamp"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -16,16 +20,25 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const ENDPOINT_REMOTE_NOTIFICATION_STORE = "/%s/%s/%s/link/%s/notifications"
 const ENDPOINT_LOCAL_NOTIFICATION_LIST = "/%s/%s/%s/notifications"
+const ENDPOINT_LOCAL_NOTIFICATION_ACKNOWLEDGE = "/%s/%s/%s/notifications/acknowledge"
+const ENDPOINT_LOCAL_NOTIFICATION_DELETE = "/%s/%s/%s/notifications/delete"
+const ENDPOINT_LOCAL_NOTIFICATION_STREAM = "/%s/%s/%s/notifications/stream"
+
+const NOTIFICATIONS_WATCH_MAX_BACKOFF = time.Minute
 
 func notificationsListCommand(args []string) {
 	fs := flag.NewFlagSet("notifications-list", flag.ExitOnError)
 	accountEmail := fs.String("user", "", "use local profile of given user")
 	processNotifications := fs.Bool("process", false, "process notifications after fetching them")
+	since := fs.String("since", "", "only notifications received after given RFC3339 time")
+	unacknowledged := fs.Bool("unacknowledged", false, "only notifications not acknowledged yet")
 	hostOverride := fs.String("force-host", "", "enforce given host for the request")
 	fs.Parse(args)
 
@@ -45,6 +58,16 @@ func notificationsListCommand(args []string) {
 	}
 
 	path := fmt.Sprintf(ENDPOINT_LOCAL_NOTIFICATION_LIST, consts.PRIVATE_API_PATH_PREFIX, userDomain, userLocalPart)
+	query := url.Values{}
+	if *since != "" {
+		query.Set("since", *since)
+	}
+	if *unacknowledged {
+		query.Set("unacknowledged", "yes")
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
 
 	var hosts []string
 	if *hostOverride != "" {
@@ -116,23 +139,30 @@ func notificationsListCommand(args []string) {
 			if line == "" {
 				continue
 			}
-			notificationParts := strings.SplitN(line, ",", 2)
-
-			decryptedData, err := crypto.DecryptAnonymous(localUser.PrivateEncryptionKey, localUser.PublicEncryptionKey, notificationParts[1])
+			n, err := notificationPkg.FromLine(line)
+			if err != nil {
+				fmt.Printf("Bad notification line: %s\n", line)
+				continue
+			}
 
+			decryptedData, err := localUser.DecryptAnonymous(n.Notifier)
 			if err != nil {
-				fmt.Printf("Error: %s\n", err)
-				os.Exit(1)
+				fmt.Printf("Notification %s could not be decrypted: %s\n", n.ID, err)
+				continue
 			}
 
 			authorAddress := string(decryptedData)
 			link := linksPkg.Make(safeUserAddress, authorAddress)
-			if link != notificationParts[0] {
+			if link != n.Link {
 				fmt.Printf("Link mismatch on notification from %s\n", authorAddress)
 				continue
 			}
 
-			fmt.Printf("Verified: %s\n", authorAddress)
+			status := "new"
+			if n.Acknowledged != "" {
+				status = "acknowledged " + n.Acknowledged
+			}
+			fmt.Printf("Verified: %s #%d %s %s (%s)\n", authorAddress, n.Sequence, n.ID, n.Received, status)
 		}
 
 	}
@@ -161,23 +191,7 @@ func notificationsStoreCommand(args []string) {
 		os.Exit(1)
 	}
 
-	readerUser, err := userPkg.LocalUser(*readerEmail) // TODO: fetch from remote
-	if err != nil {
-		fmt.Printf("Could not initialize remote user '%s': %s\n", *readerEmail, err)
-		os.Exit(1)
-	}
-
-	// Notifications include own address of the notifier, encrypted
-	callerAddress := []byte(localUser.Address)
-	callerAddressEncrypted, err := crypto.EncryptAnonymous(readerUser.PublicEncryptionKey, callerAddress)
-	if err != nil {
-		fmt.Printf("Could not encrypt caller address '%s': %s\n", localUser.Address, err)
-		os.Exit(1)
-	}
-
 	safeReaderAddress, readerDomain, readerLocalPart := address.ParseEmailAddress(*readerEmail)
-	link := linksPkg.Make(*accountEmail, safeReaderAddress)
-	path := fmt.Sprintf(ENDPOINT_REMOTE_NOTIFICATION_STORE, consts.PUBLIC_API_PATH_PREFIX, readerDomain, readerLocalPart, link)
 
 	var hosts []string
 	if *hostOverride != "" {
@@ -191,51 +205,291 @@ func notificationsStoreCommand(args []string) {
 	}
 
 	for _, host := range hosts {
-		client := http.Client{}
-		if !strings.HasPrefix(host, "http") {
-			host = "https://" + host
-		}
-		uri, err := url.ParseRequestURI(host + path)
+		err = notifyReader(localUser, safeReaderAddress, host)
 		if err != nil {
-			fmt.Print("Valid URL is required '%s': %s\n", uri, err)
+			if errors.Is(err, errorNotificationsRefused) {
+				fmt.Printf("Remote reader [%s] does not accept non-contact notifications\n", *readerEmail)
+				os.Exit(0)
+			}
+			fmt.Fprintf(os.Stderr, "%s\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Trying: ", uri.String())
-		req, err := http.NewRequest("PUT", uri.String(), strings.NewReader(callerAddressEncrypted))
+		fmt.Printf("Notified user %s at %s\n", *readerEmail, host)
+	}
+}
+
+var errorNotificationsRefused = errors.New("reader does not accept non-contact notifications")
+
+// notifyReader leaves a notification for the reader at one of their hosts,
+// paying with a stamp when the host demands one.
+func notifyReader(localUser *userPkg.User, readerAddress, host string) error {
+	readerUser, err := userPkg.LocalPublicUser(readerAddress) // TODO: fetch from remote
+	if err != nil {
+		return fmt.Errorf("could not initialize remote user '%s': %w", readerAddress, err)
+	}
+	_, err = pins.CheckKeys(readerUser.Address, readerUser.PublicSigningKeyBase64, readerUser.PublicEncryptionKeyBase64)
+	if err != nil {
+		return fmt.Errorf("keys of reader '%s': %w", readerAddress, err)
+	}
+
+	// Notifications include own address of the notifier, encrypted
+	callerAddress := []byte(localUser.Address)
+	callerAddressEncrypted, err := crypto.EncryptAnonymous(readerUser.PublicEncryptionKey, callerAddress)
+	if err != nil {
+		return fmt.Errorf("could not encrypt caller address '%s': %w", localUser.Address, err)
+	}
+
+	safeReaderAddress, readerDomain, readerLocalPart := address.ParseEmailAddress(readerAddress)
+	link := linksPkg.Make(localUser.Address, safeReaderAddress)
+	path := fmt.Sprintf(ENDPOINT_REMOTE_NOTIFICATION_STORE, consts.PUBLIC_API_PATH_PREFIX, readerDomain, readerLocalPart, link)
+
+	notifierHeader := strings.Join([]string{
+		"algorithm=" + crypto.ANONYMOUS_ENCRYPTION_CIPHER,
+		"value=" + callerAddressEncrypted,
+		"key=" + readerUser.PublicEncryptionKeyFingerprint,
+	}, "; ")
+
+	client := http.Client{}
+	if !strings.HasPrefix(host, "http") {
+		host = "https://" + host
+	}
+	uri, err := url.ParseRequestURI(host + path)
+	if err != nil {
+		return fmt.Errorf("valid URL is required '%s': %w", host+path, err)
+	}
+	fmt.Println("Trying: ", uri.String())
+
+	var notificationStamp *stampPkg.Stamp
+	var res *http.Response
+	for {
+		req, err := http.NewRequest("HEAD", uri.String(), nil)
 		if err != nil {
-			fmt.Printf("Local error: %s\n", err)
-			os.Exit(1)
+			return err
+		}
+		req.Header.Set(consts.NOTIFICATION_ORIGIN_HEADER, notifierHeader)
+		if notificationStamp != nil {
+			req.Header.Set(consts.NOTIFICATION_STAMP_HEADER, notificationStamp.ToHeader())
 		}
-		req.Header.Set("Content-Type", "text/plain")
 		n, err := noncePkg.ForUser(localUser)
 		if err != nil {
-			fmt.Printf("Local error: %s\n", err)
-			os.Exit(1)
+			return err
 		}
 		req.Header.Set(consts.AUTHORIZATION_HEADER_NONCE, noncePkg.ToHeader(n))
 
-		res, err := client.Do(req)
+		res, err = client.Do(req)
 		if err != nil {
 			if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
-				fmt.Fprintf(os.Stderr, "Timeout error: %s\n", err)
+				return fmt.Errorf("timeout error: %w", err)
 			} else if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
-				fmt.Fprintf(os.Stderr, "URL timeout error: %s\n", err)
-			} else {
-				fmt.Fprintf(os.Stderr, "Other error: %s\n", err)
+				return fmt.Errorf("URL timeout error: %w", err)
 			}
-			fmt.Fprintf(os.Stderr, "Could not query URL: %s\n", err)
-			os.Exit(1)
+			return fmt.Errorf("could not query URL: %w", err)
 		}
-		defer res.Body.Close()
+		res.Body.Close()
 
-		if res.StatusCode != http.StatusOK {
-			if res.StatusCode == http.StatusForbidden {
-				fmt.Printf("Remote reader [%s] does not accept non-contact notifications\n", *readerEmail)
-				os.Exit(0)
+		// Without a link, the reader may demand proof of work before taking the notification
+		if res.StatusCode != http.StatusPreconditionRequired || notificationStamp != nil {
+			break
+		}
+		bitCount, err := strconv.Atoi(res.Header.Get(consts.NOTIFICATION_STAMP_BITS_HEADER))
+		if err != nil {
+			return fmt.Errorf("bad stamp difficulty demanded: %w", err)
+		}
+		fmt.Printf("Computing a %d bit notification stamp\n", bitCount)
+		notificationStamp, err = stampPkg.Mint(link, localUser.PublicSigningKeyFingerprint, bitCount)
+		if err != nil {
+			return fmt.Errorf("could not compute notification stamp: %w", err)
+		}
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusForbidden:
+		return errorNotificationsRefused
+	default:
+		return fmt.Errorf("response code: %d", res.StatusCode)
+	}
+}
+
+// go run cmd/client_api/* notifications-ack -user me@dejanstrbac.com -ids ID1,ID2 -force-host http://127.0.0.1:4000
+func notificationsAckCommand(args []string) {
+	notificationsChangeCommand("notifications-ack", ENDPOINT_LOCAL_NOTIFICATION_ACKNOWLEDGE, "Acknowledged", args)
+}
+
+// go run cmd/client_api/* notifications-delete -user me@dejanstrbac.com -all -force-host http://127.0.0.1:4000
+func notificationsDeleteCommand(args []string) {
+	notificationsChangeCommand("notifications-delete", ENDPOINT_LOCAL_NOTIFICATION_DELETE, "Deleted", args)
+}
+
+func notificationsChangeCommand(name, endpoint, done string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	accountEmail := fs.String("user", "", "use local profile of given user")
+	ids := fs.String("ids", "", "comma separated notification IDs")
+	all := fs.Bool("all", false, "all notifications")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	var selected []string
+	if *all {
+		selected = []string{notificationPkg.ALL_NOTIFICATIONS}
+	} else {
+		for _, id := range strings.Split(*ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				selected = append(selected, id)
 			}
-			fmt.Fprintf(os.Stderr, "Response code: %d\n", res.StatusCode)
+		}
+	}
+	if len(selected) == 0 {
+		fmt.Println("Error: -ids or -all is required")
+		os.Exit(1)
+	}
+
+	for _, body := range accountRequest(safeUserAddress, http.MethodPost, endpoint, *hostOverride, strings.Join(selected, "\n")) {
+		fmt.Printf("%s %s notifications\n", done, strings.TrimSpace(body))
+	}
+}
+
+// Watches the notification stream of the account, reconnecting from the
+// last event seen whenever the connection drops.
+// go run cmd/client_api/* notifications-watch -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func notificationsWatchCommand(args []string) {
+	fs := flag.NewFlagSet("notifications-watch", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "use local profile of given user")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+
+	safeUserAddress, userDomain, userLocalPart := address.ParseEmailAddress(*accountEmail)
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+
+	path := fmt.Sprintf(ENDPOINT_LOCAL_NOTIFICATION_STREAM, consts.PRIVATE_API_PATH_PREFIX, userDomain, userLocalPart)
+
+	var hosts []string
+	if *hostOverride != "" {
+		hosts = []string{*hostOverride}
+	} else {
+		hosts, err = mcaPkg.LookupEmailHosts(userDomain, userLocalPart)
+		if err != nil || len(hosts) == 0 {
+			fmt.Println("No hosts to contact")
 			os.Exit(1)
 		}
-		fmt.Printf("Notified user %s at %s", *readerEmail, host)
 	}
+
+	lastEventID := ""
+	backoff := time.Second
+	for {
+		for _, host := range hosts {
+			if !strings.HasPrefix(host, "http") {
+				host = "https://" + host
+			}
+			uri, err := url.ParseRequestURI(host + path)
+			if err != nil {
+				fmt.Printf("Valid URL is required '%s': %s\n", host+path, err)
+				os.Exit(1)
+			}
+			fmt.Fprintln(os.Stderr, "Watching: ", uri.String())
+
+			connected, err := watchNotificationStream(uri.String(), localUser, safeUserAddress, &lastEventID)
+			if connected {
+				backoff = time.Second
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Stream interrupted: %s\n", err)
+			}
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > NOTIFICATIONS_WATCH_MAX_BACKOFF {
+			backoff = NOTIFICATIONS_WATCH_MAX_BACKOFF
+		}
+	}
+}
+
+// watchNotificationStream prints the notifications of the stream until it
+// ends, keeping the ID of the last one to resume from.
+func watchNotificationStream(uri string, localUser *userPkg.User, safeUserAddress string, lastEventID *string) (bool, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastEventID != "" {
+		req.Header.Set("Last-Event-ID", *lastEventID)
+	}
+	n, err := noncePkg.ForUser(localUser)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set(consts.AUTHORIZATION_HEADER_NONCE, noncePkg.ToHeader(n))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("response code: %d", res.StatusCode)
+	}
+
+	var eventID, data string
+	reader := bufio.NewReader(res.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return true, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			// A blank line dispatches the event
+			if data != "" {
+				printStreamedNotification(localUser, safeUserAddress, data)
+				if eventID != "" {
+					*lastEventID = eventID
+				}
+			}
+			eventID, data = "", ""
+		case strings.HasPrefix(line, ":"):
+			// Heartbeat
+		case strings.HasPrefix(line, "id:"):
+			eventID = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+}
+
+func printStreamedNotification(localUser *userPkg.User, safeUserAddress, data string) {
+	n, err := notificationPkg.FromLine(data)
+	if err != nil {
+		fmt.Printf("Bad notification event: %s\n", data)
+		return
+	}
+	decryptedData, err := localUser.DecryptAnonymous(n.Notifier)
+	if err != nil {
+		fmt.Printf("Notification %s could not be decrypted: %s\n", n.ID, err)
+		return
+	}
+	authorAddress := string(decryptedData)
+	if linksPkg.Make(safeUserAddress, authorAddress) != n.Link {
+		fmt.Printf("Link mismatch on notification from %s\n", authorAddress)
+		return
+	}
+	fmt.Printf("Verified: %s #%d %s %s\n", authorAddress, n.Sequence, n.ID, n.Received)
 }
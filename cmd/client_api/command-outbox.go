@@ -0,0 +1,207 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/address"
+	mcaPkg "email.mercata.com/internal/email/mca"
+	messagePkg "email.mercata.com/internal/email/message"
+	"email.mercata.com/internal/email/outbox"
+	userPkg "email.mercata.com/internal/email/user"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// enqueueReaderNotifications puts a notification for every reader of the
+// stored message in the outbox, except for the author.
+func enqueueReaderNotifications(authorUser *userPkg.User, messageDirPath string) (int, error) {
+	opened, err := messagePkg.OpenEnvelope(messageDirPath, authorUser, userPkg.AsReader(authorUser))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, reader := range opened.Readers {
+		if reader.Address == "" || reader.Address == authorUser.Address {
+			continue
+		}
+		_, err = outbox.Enqueue(authorUser.Address, reader.Address, opened.ID)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// go run cmd/client_api/* outbox-run -user me@dejanstrbac.com
+func outboxRunCommand(args []string) {
+	fs := flag.NewFlagSet("outbox-run", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "deliver the outbox of given user")
+	retryFailed := fs.Bool("retry-failed", false, "put failed entries back in the queue first")
+	hostOverride := fs.String("force-host", "", "enforce given host for all readers")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+
+	err = outbox.Prune(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not prune outbox: %s\n", err)
+		os.Exit(1)
+	}
+	entries, err := outbox.List(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not read outbox: %s\n", err)
+		os.Exit(1)
+	}
+	hostStates, err := outbox.LoadHosts(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not read outbox hosts: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Readers sharing a domain and local part share hosts, look them up once
+	readerHosts := make(map[string][]string)
+	now := time.Now()
+	delivered, deferred, failed := 0, 0, 0
+
+	for _, e := range entries {
+		if *retryFailed && e.Status == outbox.STATUS_FAILED {
+			e.Retry()
+		}
+		if !e.Due(now) {
+			if e.Status == outbox.STATUS_PENDING {
+				deferred++
+			}
+			continue
+		}
+
+		hosts, found := readerHosts[e.Reader]
+		if !found {
+			if *hostOverride != "" {
+				hosts = []string{*hostOverride}
+			} else {
+				_, readerDomain, readerLocalPart := address.ParseEmailAddress(e.Reader)
+				hosts, err = mcaPkg.LookupEmailHosts(readerDomain, readerLocalPart)
+				if err == nil && len(hosts) == 0 {
+					err = errors.New("no hosts to contact")
+				}
+				if err != nil {
+					e.Failed("", err, false)
+					saveOutboxEntry(safeUserAddress, e)
+					deferred++
+					continue
+				}
+			}
+			readerHosts[e.Reader] = hosts
+		}
+
+		// Delivered once any host of the reader takes the notification
+		var lastHost string
+		var lastErr error
+		permanent, attempted, ok := false, false, false
+		for _, host := range hosts {
+			if !outbox.HostAvailable(hostStates, host, now) {
+				continue
+			}
+			attempted = true
+			lastHost = host
+			lastErr = notifyReader(localUser, e.Reader, host)
+			if lastErr == nil {
+				outbox.HostSucceeded(hostStates, host)
+				ok = true
+				break
+			}
+			if errors.Is(lastErr, errorNotificationsRefused) {
+				// The host answered, only the reader says no
+				permanent = true
+				break
+			}
+			outbox.HostFailed(hostStates, host)
+		}
+
+		switch {
+		case ok:
+			e.Delivered(lastHost)
+			delivered++
+			fmt.Printf("Notified %s of message %s\n", e.Reader, e.MessageID)
+		case !attempted:
+			// All hosts are backed off, the entry waits without using up attempts
+			deferred++
+			continue
+		default:
+			e.Failed(lastHost, lastErr, permanent)
+			if e.Status == outbox.STATUS_FAILED {
+				failed++
+			} else {
+				deferred++
+			}
+			fmt.Fprintf(os.Stderr, "Could not notify %s: %s\n", e.Reader, lastErr)
+		}
+		saveOutboxEntry(safeUserAddress, e)
+		err = outbox.SaveHosts(safeUserAddress, hostStates)
+		if err != nil {
+			fmt.Printf("Could not save outbox hosts: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("Delivered: %d, pending: %d, failed: %d\n", delivered, deferred, failed)
+}
+
+func saveOutboxEntry(userAddress string, e *outbox.Entry) {
+	err := outbox.Save(userAddress, e)
+	if err != nil {
+		fmt.Printf("Could not save outbox entry %s: %s\n", e.ID, err)
+		os.Exit(1)
+	}
+}
+
+// go run cmd/client_api/* outbox-status -user me@dejanstrbac.com
+func outboxStatusCommand(args []string) {
+	fs := flag.NewFlagSet("outbox-status", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "show the outbox of given user")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	entries, err := outbox.List(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not read outbox: %s\n", err)
+		os.Exit(1)
+	}
+	for _, e := range entries {
+		fmt.Printf("%s %-9s %s message %s, %d attempts", e.ID, e.Status, e.Reader, e.MessageID, e.Attempts)
+		if e.Status == outbox.STATUS_PENDING {
+			fmt.Printf(", next %s", e.NextAttempt)
+		}
+		if e.LastError != "" {
+			fmt.Printf(", last error at %s: %s", e.LastHost, e.LastError)
+		}
+		fmt.Println()
+	}
+
+	hostStates, err := outbox.LoadHosts(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not read outbox hosts: %s\n", err)
+		os.Exit(1)
+	}
+	for _, h := range hostStates {
+		if h.RetryAfter.After(time.Now()) {
+			fmt.Printf("Backing off %s after %d failures, until %s\n", h.Host, h.Failures, h.RetryAfter.Format(time.RFC3339))
+		}
+	}
+}
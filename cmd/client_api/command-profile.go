@@ -29,9 +29,9 @@ func profileFetchCommand(args []string) {
 		os.Exit(1)
 	}
 
-	safeAddress, domain, user := address.ParseEmailAddress(*accountEmail)
+	safeAddress, _, _ := address.ParseEmailAddress(*accountEmail)
 
-	profile, err := profilePkg.GetRemoteProfile(safeAddress, domain, user)
+	profile, err := resolveContactProfile(safeAddress, false)
 	if err != nil {
 		fmt.Printf("Profile could not be read: %s\n", err)
 		os.Exit(1)
@@ -20,6 +20,7 @@ func provisionCommand(args []string) {
 	fs := flag.NewFlagSet("provision", flag.ExitOnError)
 	accountEmail := fs.String("user", "", "provision new account with address")
 	sourceFile := fs.String("source-file", "", "read profile from given file")
+	inviteCode := fs.String("invite", "", "invite code, where the domain demands one")
 	hostOverride := fs.String("force-host", "", "enforce given host for the request")
 	fs.Parse(args)
 
@@ -84,6 +85,9 @@ func provisionCommand(args []string) {
 		req.Header = http.Header{
 			consts.AUTHORIZATION_HEADER_NONCE: {nonce.ToHeader(n)},
 		}
+		if *inviteCode != "" {
+			req.Header.Set(consts.PROVISIONING_INVITE_HEADER, *inviteCode)
+		}
 		res, err := client.Do(req)
 		fmt.Println("Response:", res.Status)
 	}
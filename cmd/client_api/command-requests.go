@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/email/address"
+	linksPkg "email.mercata.com/internal/email/links"
+	mcaPkg "email.mercata.com/internal/email/mca"
+	"email.mercata.com/internal/email/requests"
+	userPkg "email.mercata.com/internal/email/user"
+	noncePkg "email.mercata.com/internal/nonce"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const ENDPOINT_REMOTE_CONTACT_REQUEST = "/%s/%s/%s/link/%s/requests"
+const ENDPOINT_LOCAL_CONTACT_REQUESTS_LIST = "/%s/%s/%s/requests"
+const ENDPOINT_LOCAL_CONTACT_REQUEST = "/%s/%s/%s/requests/%s"
+
+// go run cmd/client_api/* requests-send -user me@dejanstrbac.com -contact stranger@mercata.com -message "We met at the conference"
+func requestsSendCommand(args []string) {
+	fs := flag.NewFlagSet("requests-send", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	contactEmail := fs.String("contact", "", "address to ask for becoming a contact")
+	introduction := fs.String("message", "", "short introduction for the contact")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	if !address.ValidEmailAddress(*contactEmail) {
+		fmt.Println("Error: not present or bad contact email address format")
+		os.Exit(1)
+	}
+
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+
+	safeContactAddress, contactDomain, contactLocalPart := address.ParseEmailAddress(*contactEmail)
+	contactProfile, err := resolveContactProfile(safeContactAddress, true)
+	if err != nil {
+		fmt.Printf("Could not fetch profile of '%s': %s\n", safeContactAddress, err)
+		os.Exit(1)
+	}
+
+	// Signed so the contact can tell the request was made by the address it names
+	signature, err := localUser.SignData(requests.IntroductionSignedData(safeUserAddress, safeContactAddress, *introduction))
+	if err != nil {
+		fmt.Printf("Could not sign introduction: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Only the contact learns who is asking
+	introductionEncrypted, err := crypto.EncryptAnonymous(contactProfile.User.PublicEncryptionKey, requests.MakeIntroduction(safeUserAddress, signature, *introduction))
+	if err != nil {
+		fmt.Printf("Could not encrypt introduction: %s\n", err)
+		os.Exit(1)
+	}
+	if len(introductionEncrypted) > consts.MAX_CONTACT_REQUEST_SIZE {
+		fmt.Println("Error: introduction is too long")
+		os.Exit(1)
+	}
+
+	link := linksPkg.Make(safeUserAddress, safeContactAddress)
+	path := fmt.Sprintf(ENDPOINT_REMOTE_CONTACT_REQUEST, consts.PUBLIC_API_PATH_PREFIX, contactDomain, contactLocalPart, link)
+
+	var hosts []string
+	if *hostOverride != "" {
+		hosts = []string{*hostOverride}
+	} else {
+		hosts, err = mcaPkg.LookupEmailHosts(contactDomain, contactLocalPart)
+		if err != nil || len(hosts) == 0 {
+			fmt.Println("No hosts to contact")
+			os.Exit(1)
+		}
+	}
+
+	for _, host := range hosts {
+		client := http.Client{}
+		if !strings.HasPrefix(host, "http") {
+			host = "https://" + host
+		}
+		uri, err := url.ParseRequestURI(host + path)
+		if err != nil {
+			fmt.Printf("Valid URL is required '%s': %s\n", host+path, err)
+			os.Exit(1)
+		}
+		fmt.Println("Trying: ", uri.String())
+		req, err := http.NewRequest("PUT", uri.String(), strings.NewReader(introductionEncrypted))
+		if err != nil {
+			fmt.Printf("Local error: %s\n", err)
+			os.Exit(1)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		n, err := noncePkg.ForUser(localUser)
+		if err != nil {
+			fmt.Printf("Local error: %s\n", err)
+			os.Exit(1)
+		}
+		req.Header.Set(consts.AUTHORIZATION_HEADER_NONCE, noncePkg.ToHeader(n))
+
+		res, err := client.Do(req)
+		if err != nil {
+			if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
+				fmt.Fprintf(os.Stderr, "Timeout error: %s\n", err)
+			} else if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
+				fmt.Fprintf(os.Stderr, "URL timeout error: %s\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Other error: %s\n", err)
+			}
+			fmt.Fprintf(os.Stderr, "Could not query URL: %s\n", err)
+			os.Exit(1)
+		}
+		res.Body.Close()
+
+		switch res.StatusCode {
+		case http.StatusOK:
+			// One host holding the request is enough
+			fmt.Printf("Asked %s to become a contact at %s\n", safeContactAddress, host)
+			return
+		case http.StatusConflict:
+			fmt.Printf("%s already knows you\n", safeContactAddress)
+			return
+		case http.StatusTooManyRequests:
+			fmt.Fprintf(os.Stderr, "Too many requests, retry after %s seconds\n", res.Header.Get("Retry-After"))
+			os.Exit(1)
+		default:
+			fmt.Fprintf(os.Stderr, "Response code: %d\n", res.StatusCode)
+			os.Exit(1)
+		}
+	}
+}
+
+// go run cmd/client_api/* requests-list -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func requestsListCommand(args []string) {
+	fs := flag.NewFlagSet("requests-list", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+
+	for _, body := range accountRequest(safeUserAddress, http.MethodGet, ENDPOINT_LOCAL_CONTACT_REQUESTS_LIST, *hostOverride, "") {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			request, err := requests.FromLine(line)
+			if err != nil {
+				fmt.Println("Bad request line: ", line)
+				continue
+			}
+			introduction, err := localUser.DecryptAnonymous(request.Introduction)
+			if err != nil {
+				fmt.Printf("Request on link %s could not be decrypted\n", request.Link)
+				continue
+			}
+			intro, err := requests.ParseIntroduction(introduction)
+			if err != nil {
+				fmt.Printf("Request on link %s: %s\n", request.Link, err)
+				continue
+			}
+			// Anyone can make the link of two addresses, a mismatch only
+			// tells the introduction was not written for this link
+			if linksPkg.Make(safeUserAddress, intro.From) != request.Link {
+				fmt.Printf("Link mismatch on request claiming to be from %s\n", intro.From)
+				continue
+			}
+			fmt.Printf("From:      %s\n", intro.From)
+			fmt.Printf("Requested: %s\n", request.Requested)
+			fmt.Printf("Key:       %s\n", request.RequesterKey)
+			fmt.Printf("Verified:  %s\n", verifyContactRequest(safeUserAddress, request, intro))
+			fmt.Printf("Message:   %s\n", intro.Message)
+			fmt.Println("----------")
+		}
+	}
+}
+
+// verifyContactRequest tells whether the request was made with the signing
+// key of the address it claims, by the profile of that address, and why not.
+func verifyContactRequest(userAddress string, request *requests.Request, intro *requests.Introduction) string {
+	requesterProfile, err := resolveContactProfile(intro.From, false)
+	if err != nil {
+		return fmt.Sprintf("NO, could not fetch profile of %s: %s", intro.From, err)
+	}
+	if requesterProfile.User.PublicSigningKeyFingerprint != request.RequesterKey {
+		return fmt.Sprintf("NO, MISMATCH: the key is not the signing key of %s", intro.From)
+	}
+	signedData := requests.IntroductionSignedData(intro.From, userAddress, intro.Message)
+	if intro.Signature == "" || !crypto.VerifySignature(requesterProfile.User.PublicSigningKey, intro.Signature, signedData) {
+		return fmt.Sprintf("NO, the introduction is not signed by %s", intro.From)
+	}
+	return "yes"
+}
+
+// go run cmd/client_api/* requests-accept -user me@dejanstrbac.com -contact stranger@mercata.com -force-host http://127.0.0.1:4000
+func requestsAcceptCommand(args []string) {
+	fs := flag.NewFlagSet("requests-accept", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	contactEmail := fs.String("contact", "", "requester to accept as a contact")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) || !address.ValidEmailAddress(*contactEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	safeContactAddress, _, _ := address.ParseEmailAddress(*contactEmail)
+
+	localUser, err := userPkg.LocalUser(safeUserAddress)
+	if err != nil {
+		fmt.Printf("Could not initialize local user '%s': %s\n", safeUserAddress, err)
+		os.Exit(1)
+	}
+	// Stored as any link, with the contact address readable only to the user
+	contactAddressEncrypted, err := crypto.EncryptAnonymous(localUser.PublicEncryptionKey, []byte(safeContactAddress))
+	if err != nil {
+		fmt.Printf("Could not encrypt contact address '%s': %s\n", safeContactAddress, err)
+		os.Exit(1)
+	}
+
+	link := linksPkg.Make(safeUserAddress, safeContactAddress)
+	accountRequest(safeUserAddress, http.MethodPost, ENDPOINT_LOCAL_CONTACT_REQUEST, *hostOverride, contactAddressEncrypted, link)
+	fmt.Printf("Accepted %s as a contact\n", safeContactAddress)
+}
+
+// go run cmd/client_api/* requests-reject -user me@dejanstrbac.com -contact stranger@mercata.com -block [-key FINGERPRINT] -force-host http://127.0.0.1:4000
+func requestsRejectCommand(args []string) {
+	fs := flag.NewFlagSet("requests-reject", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	contactEmail := fs.String("contact", "", "requester to reject")
+	block := fs.Bool("block", false, "also block the link and signing key of the requester")
+	requesterKey := fs.String("key", "", "reject only the request of this signing key fingerprint, blocking the key only")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) || !address.ValidEmailAddress(*contactEmail) {
+		fmt.Println("Error: not present or bad email address format")
+		os.Exit(1)
+	}
+	if *requesterKey != "" && !requests.ValidRequesterKey(*requesterKey) {
+		fmt.Println("Error: key must be a signing key fingerprint")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+	safeContactAddress, _, _ := address.ParseEmailAddress(*contactEmail)
+
+	var query []string
+	if *block {
+		query = append(query, "block=yes")
+	}
+	if *requesterKey != "" {
+		query = append(query, "key="+*requesterKey)
+	}
+	endpoint := ENDPOINT_LOCAL_CONTACT_REQUEST
+	if len(query) > 0 {
+		endpoint += "?" + strings.Join(query, "&")
+	}
+	link := linksPkg.Make(safeUserAddress, safeContactAddress)
+	accountRequest(safeUserAddress, http.MethodDelete, endpoint, *hostOverride, "", link)
+	if *block {
+		fmt.Printf("Rejected and blocked %s\n", safeContactAddress)
+	} else {
+		fmt.Printf("Rejected %s\n", safeContactAddress)
+	}
+}
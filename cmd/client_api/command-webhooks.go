@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/webhook"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const ENDPOINT_LOCAL_WEBHOOKS = "/%s/%s/%s/webhooks"
+const ENDPOINT_LOCAL_WEBHOOKS_DEAD = "/%s/%s/%s/webhooks/dead"
+const ENDPOINT_LOCAL_WEBHOOK = "/%s/%s/%s/webhooks/%s"
+
+// go run cmd/client_api/* webhooks-add -user me@dejanstrbac.com -url https://bot.example.com/hook -force-host http://127.0.0.1:4000
+func webhooksAddCommand(args []string) {
+	fs := flag.NewFlagSet("webhooks-add", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	hookURL := fs.String("url", "", "URL to post new notifications to")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	if !webhook.ValidURL(*hookURL) {
+		fmt.Printf("Error: %s\n", webhook.ErrorBadWebhookURL)
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	// Every host keeps its own hooks, each with its own secret
+	for _, body := range accountRequest(safeUserAddress, http.MethodPost, ENDPOINT_LOCAL_WEBHOOKS, *hostOverride, *hookURL) {
+		parts := strings.SplitN(strings.TrimSpace(body), webhook.WEBHOOKS_COLUMN_SEPARATOR, 2)
+		if len(parts) != 2 {
+			fmt.Println("Bad response: ", body)
+			continue
+		}
+		fmt.Printf("ID:     %s\n", parts[0])
+		fmt.Printf("Secret: %s\n", parts[1])
+		fmt.Println("The secret is shown only once, keep it to verify deliveries")
+	}
+}
+
+// go run cmd/client_api/* webhooks-list -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func webhooksListCommand(args []string) {
+	webhooksPrintCommand("webhooks-list", ENDPOINT_LOCAL_WEBHOOKS, args)
+}
+
+// go run cmd/client_api/* webhooks-dead -user me@dejanstrbac.com -force-host http://127.0.0.1:4000
+func webhooksDeadCommand(args []string) {
+	webhooksPrintCommand("webhooks-dead", ENDPOINT_LOCAL_WEBHOOKS_DEAD, args)
+}
+
+func webhooksPrintCommand(name, endpoint string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	for _, body := range accountRequest(safeUserAddress, http.MethodGet, endpoint, *hostOverride, "") {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" {
+				fmt.Println(line)
+			}
+		}
+	}
+}
+
+// go run cmd/client_api/* webhooks-remove -user me@dejanstrbac.com -id HOOKID -force-host http://127.0.0.1:4000
+func webhooksRemoveCommand(args []string) {
+	fs := flag.NewFlagSet("webhooks-remove", flag.ExitOnError)
+	accountEmail := fs.String("user", "", "email address of the local user")
+	id := fs.String("id", "", "ID of the webhook to remove")
+	hostOverride := fs.String("force-host", "", "enforce given host for the request")
+	fs.Parse(args)
+
+	if !address.ValidEmailAddress(*accountEmail) {
+		fmt.Println("Error: not present or bad user email address format")
+		os.Exit(1)
+	}
+	if *id == "" {
+		fmt.Println("Error: -id is required")
+		os.Exit(1)
+	}
+	safeUserAddress, _, _ := address.ParseEmailAddress(*accountEmail)
+
+	accountRequest(safeUserAddress, http.MethodDelete, ENDPOINT_LOCAL_WEBHOOK, *hostOverride, "", *id)
+	fmt.Printf("Removed webhook %s\n", *id)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"email.mercata.com/internal/email/keys"
 	"flag"
 	"fmt"
 	"os"
@@ -10,16 +11,52 @@ import (
 type CommandFunc func([]string)
 
 var commandMap = map[string]CommandFunc{
-	"keys-gen":    keysGenCommand,
-	"keys-lookup": keysLookupCommand,
+	"agent": agentCommand,
+
+	"keys-gen":     keysGenCommand,
+	"keys-lookup":  keysLookupCommand,
+	"keys-protect": keysProtectCommand,
+
+	"keys-backup":     keysBackupCommand,
+	"keys-recover":    keysRecoverCommand,
+	"keys-share-open": keysShareOpenCommand,
+
+	"contacts-verify": contactsVerifyCommand,
 
 	"links-make":   linksMakeCommand,
 	"links-list":   linksListCommand,
 	"links-store":  linksStoreCommand,
 	"links-delete": linksDeleteCommand,
 
-	"notifications-store": notificationsStoreCommand,
-	"notifications-list":  notificationsListCommand,
+	"blocks-add":    blocksAddCommand,
+	"blocks-remove": blocksRemoveCommand,
+	"blocks-list":   blocksListCommand,
+
+	"requests-send":   requestsSendCommand,
+	"requests-list":   requestsListCommand,
+	"requests-accept": requestsAcceptCommand,
+	"requests-reject": requestsRejectCommand,
+
+	"notifications-store":  notificationsStoreCommand,
+	"notifications-list":   notificationsListCommand,
+	"notifications-ack":    notificationsAckCommand,
+	"notifications-delete": notificationsDeleteCommand,
+	"notifications-watch":  notificationsWatchCommand,
+
+	"webhooks-add":    webhooksAddCommand,
+	"webhooks-list":   webhooksListCommand,
+	"webhooks-remove": webhooksRemoveCommand,
+	"webhooks-dead":   webhooksDeadCommand,
+
+	"audit-list": auditListCommand,
+
+	"fetcher-enable":    fetcherEnableCommand,
+	"fetcher-authorize": fetcherAuthorizeCommand,
+	"fetcher-revoke":    fetcherRevokeCommand,
+	"fetcher-list":      fetcherListCommand,
+	"fetcher-fetch":     fetcherFetchCommand,
+	"inbox-list":        inboxListCommand,
+	"inbox-sync":        inboxSyncCommand,
 
 	"messages-open":   messagesOpenCommand,
 	"messages-author": messagesAuthorCommand,
@@ -30,18 +67,24 @@ var commandMap = map[string]CommandFunc{
 	"messages-store":  messagesStoreCommand,
 	"messages-delete": messagesDeleteCommand,
 
+	"outbox-run":    outboxRunCommand,
+	"outbox-status": outboxStatusCommand,
+
 	"profile-fetch":       profileFetchCommand,
 	"profile-store":       profileStoreCommand,
 	"profile-image-fetch": profileImageFetchCommand,
 	"profile-image-store": profileImageStoreCommand,
 
-	"provision": provisionCommand,
+	"provision":      provisionCommand,
+	"account-delete": accountDeleteCommand,
 }
 
 func main() {
 	fs := flag.NewFlagSet("api-client", flag.ExitOnError)
 	fs.Parse(os.Args[1:])
 
+	keys.PassphraseProvider = readPassphrase
+
 	if len(os.Args) < 2 {
 		fmt.Fprintf(os.Stderr, "Usage: %s <flag-set>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Available flag sets: %s\n", strings.Join(getAvailableCommands(), ", "))
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+)
+
+// Non-interactive use, such as scripts, provides the passphrase through the environment
+const PASSPHRASE_ENVIRONMENT_VARIABLE = "EMAIL2_PASSPHRASE"
+
+var errorNoTerminal = errors.New("no terminal to prompt for passphrase, set " + PASSPHRASE_ENVIRONMENT_VARIABLE)
+
+func readPassphrase(emailAddress string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PASSPHRASE_ENVIRONMENT_VARIABLE); ok {
+		return []byte(passphrase), nil
+	}
+	return promptPassphrase(fmt.Sprintf("Passphrase for %s: ", emailAddress))
+}
+
+func readNewPassphrase(emailAddress string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PASSPHRASE_ENVIRONMENT_VARIABLE); ok {
+		if passphrase == "" {
+			return nil, errors.New("empty passphrase in " + PASSPHRASE_ENVIRONMENT_VARIABLE)
+		}
+		return []byte(passphrase), nil
+	}
+
+	passphrase, err := promptPassphrase(fmt.Sprintf("New passphrase for %s: ", emailAddress))
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	confirmation, err := promptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if string(passphrase) != string(confirmation) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func promptPassphrase(prompt string) ([]byte, error) {
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) {
+		return nil, errorNoTerminal
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(stdin)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return passphrase, nil
+}
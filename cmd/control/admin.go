@@ -0,0 +1,90 @@
+package main
+
+import (
+	"email.mercata.com/internal/admin"
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/nonce"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// adminTarget is where an admin command acts: on the data directory
+// directly, or through the admin API of a running server when -server is
+// given, signing with the operator's key file.
+type adminTarget struct {
+	dataDirPath *string
+	server      *string
+	keyPath     *string
+}
+
+func adminFlags(fs *flag.FlagSet) *adminTarget {
+	return &adminTarget{
+		dataDirPath: fs.String("data-dir", "/tmp", "user data directory path of the server"),
+		server:      fs.String("server", "", "URL of a running server to use the admin API of, instead of the data directory"),
+		keyPath:     fs.String("key", "admin.key", "admin key file signing requests to -server"),
+	}
+}
+
+// run prints the lines of the direct function, or the response of the
+// admin API to the request, and exits on errors.
+func (t *adminTarget) run(method, body string, direct func() ([]string, error), endpoint string, pathArgs ...string) {
+	var lines []string
+	var err error
+	if *t.server == "" {
+		lines, err = direct()
+	} else {
+		lines, err = t.request(method, body, endpoint, pathArgs...)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+func (t *adminTarget) request(method, body, endpoint string, pathArgs ...string) ([]string, error) {
+	keyPair, err := admin.LoadKeyPair(*t.keyPath)
+	if err != nil {
+		return nil, err
+	}
+	n, err := nonce.New(keyPair.PublicKey, keyPair.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	endpointArgs := []interface{}{consts.ADMIN_API_PATH_PREFIX}
+	for _, arg := range pathArgs {
+		endpointArgs = append(endpointArgs, url.PathEscape(arg))
+	}
+	uri := strings.TrimSuffix(*t.server, "/") + fmt.Sprintf(endpoint, endpointArgs...)
+	req, err := http.NewRequest(method, uri, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(consts.AUTHORIZATION_HEADER_NONCE, nonce.ToHeader(n))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("response code %d", res.StatusCode)
+	}
+	text := strings.TrimSpace(string(resBody))
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
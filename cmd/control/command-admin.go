@@ -0,0 +1,295 @@
+package main
+
+import (
+	"email.mercata.com/internal/admin"
+	"email.mercata.com/internal/consts"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// accountFlags adds the flags naming an account to the flag set
+func accountFlags(fs *flag.FlagSet) (*string, *string) {
+	return fs.String("domain", "", "domain of the account"), fs.String("user", "", "local part of the account")
+}
+
+// go run cmd/control/* admin-keygen -out admin.key
+func adminKeygenCommand(args []string) {
+	fs := flag.NewFlagSet("admin-keygen", flag.ExitOnError)
+	out := fs.String("out", "admin.key", "file to write the new key pair to")
+	fs.Parse(args)
+
+	keyPair, err := admin.GenerateKeyPair(*out)
+	if err != nil {
+		fmt.Printf("Could not generate admin key: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Key pair written to %s, add this line to the -admin-keys file of the server:\n", *out)
+	fmt.Printf("%s %s\n", keyPair.PublicKeyBase64, *out)
+}
+
+// go run cmd/control/* stats -data-dir /var/lib/email
+func statsCommand(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	target := adminFlags(fs)
+	fs.Parse(args)
+
+	target.run(http.MethodGet, "", func() ([]string, error) {
+		stats, err := admin.ServerStats(*target.dataDirPath)
+		if err != nil {
+			return nil, err
+		}
+		return stats.ToLines(), nil
+	}, "/%s/stats")
+}
+
+// go run cmd/control/* domains-list -server https://mail.open.email -key admin.key
+func domainsListCommand(args []string) {
+	fs := flag.NewFlagSet("domains-list", flag.ExitOnError)
+	target := adminFlags(fs)
+	fs.Parse(args)
+
+	target.run(http.MethodGet, "", func() ([]string, error) {
+		domains, err := admin.ListDomains(*target.dataDirPath)
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, d := range domains {
+			lines = append(lines, d.ToLine())
+		}
+		return lines, nil
+	}, "/%s/domains")
+}
+
+// go run cmd/control/* domains-add -data-dir /var/lib/email -domain open.email -mode invite
+func domainsAddCommand(args []string) {
+	fs := flag.NewFlagSet("domains-add", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain := fs.String("domain", "", "domain to host")
+	mode := fs.String("mode", "invite", "provisioning mode of the domain: open, invite or closed")
+	fs.Parse(args)
+
+	target.run(http.MethodPut, *mode, func() ([]string, error) {
+		return nil, admin.AddProvisioningDomain(*target.dataDirPath, *domain, *mode)
+	}, "/%s/domains/%s", *domain)
+	fmt.Printf("Domain %s provisioning %s\n", *domain, *mode)
+}
+
+// go run cmd/control/* domains-usage -server https://mail.open.email -key open.email.key -domain open.email
+func domainsUsageCommand(args []string) {
+	fs := flag.NewFlagSet("domains-usage", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain := fs.String("domain", "", "domain to sum up the accounts of")
+	fs.Parse(args)
+
+	target.run(http.MethodGet, "", func() ([]string, error) {
+		d, err := admin.DomainUsage(*target.dataDirPath, *domain)
+		if err != nil {
+			return nil, err
+		}
+		return []string{d.ToLine()}, nil
+	}, "/%s/domains/%s", *domain)
+}
+
+// go run cmd/control/* domain-admins-add -data-dir /var/lib/email -domain open.email -key <base64> -name ops@open.email
+func domainAdminsAddCommand(args []string) {
+	fs := flag.NewFlagSet("domain-admins-add", flag.ExitOnError)
+	dataDirPath := fs.String("data-dir", "/tmp", "user data directory path of the server")
+	domain := fs.String("domain", "", "domain the key administers")
+	key := fs.String("key", "", "base64 public signing key, as printed by admin-keygen")
+	name := fs.String("name", "", "name logged for the actions of the key")
+	fs.Parse(args)
+
+	err := admin.AddDomainKey(*dataDirPath, *domain, *key, *name)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Key added to the admins of %s\n", *domain)
+}
+
+// go run cmd/control/* domain-admins-list -data-dir /var/lib/email -domain open.email
+func domainAdminsListCommand(args []string) {
+	fs := flag.NewFlagSet("domain-admins-list", flag.ExitOnError)
+	dataDirPath := fs.String("data-dir", "/tmp", "user data directory path of the server")
+	domain := fs.String("domain", "", "domain to list the admin keys of")
+	fs.Parse(args)
+
+	lines, err := admin.ListDomainKeys(*dataDirPath, *domain)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+// go run cmd/control/* domain-admins-remove -data-dir /var/lib/email -domain open.email -key <base64>
+func domainAdminsRemoveCommand(args []string) {
+	fs := flag.NewFlagSet("domain-admins-remove", flag.ExitOnError)
+	dataDirPath := fs.String("data-dir", "/tmp", "user data directory path of the server")
+	domain := fs.String("domain", "", "domain the key administers")
+	key := fs.String("key", "", "base64 public signing key to remove")
+	fs.Parse(args)
+
+	err := admin.RemoveDomainKey(*dataDirPath, *domain, *key)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Key removed from the admins of %s\n", *domain)
+}
+
+// go run cmd/control/* accounts-list -data-dir /var/lib/email -domain open.email
+func accountsListCommand(args []string) {
+	fs := flag.NewFlagSet("accounts-list", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain := fs.String("domain", "", "domain to list the accounts of")
+	fs.Parse(args)
+
+	target.run(http.MethodGet, "", func() ([]string, error) {
+		accounts, err := admin.ListAccounts(*target.dataDirPath, *domain)
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for _, a := range accounts {
+			lines = append(lines, a.ToLine())
+		}
+		return lines, nil
+	}, "/%s/domains/%s/accounts", *domain)
+}
+
+// go run cmd/control/* accounts-profile -data-dir /var/lib/email -domain open.email -user alice
+func accountsProfileCommand(args []string) {
+	fs := flag.NewFlagSet("accounts-profile", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	fs.Parse(args)
+
+	target.run(http.MethodGet, "", func() ([]string, error) {
+		data, err := admin.Profile(*target.dataDirPath, *domain, *user)
+		if err != nil {
+			return nil, err
+		}
+		return []string{string(data)}, nil
+	}, "/%s/domains/%s/accounts/%s/profile", *domain, *user)
+}
+
+// go run cmd/control/* accounts-provision -server https://mail.open.email -key open.email.key -domain open.email -user alice -profile alice.profile
+func accountsProvisionCommand(args []string) {
+	fs := flag.NewFlagSet("accounts-provision", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	profilePath := fs.String("profile", "", "profile file of the account, holding at least its public keys")
+	tombstoneDays := fs.Int("tombstone-days", 365, "days the names of deleted accounts are held back, as on the server")
+	fs.Parse(args)
+
+	profileData, err := os.ReadFile(*profilePath)
+	if err != nil {
+		fmt.Printf("Could not read profile: %s\n", err)
+		os.Exit(1)
+	}
+	target.run(http.MethodPost, string(profileData), func() ([]string, error) {
+		_, err := admin.Provision(*target.dataDirPath, *domain, *user, profileData, nil, time.Duration(*tombstoneDays)*24*time.Hour)
+		return nil, err
+	}, "/%s/domains/%s/accounts/%s", *domain, *user)
+	fmt.Printf("Account %s@%s provisioned\n", *user, *domain)
+}
+
+// go run cmd/control/* accounts-quota -data-dir /var/lib/email -domain open.email -user alice -bytes 500000000
+func accountsQuotaCommand(args []string) {
+	fs := flag.NewFlagSet("accounts-quota", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	maxSize := fs.Int64("bytes", 0, "most bytes the account may store, 0 for the limit of the server")
+	fs.Parse(args)
+
+	target.run(http.MethodPut, strconv.FormatInt(*maxSize, 10), func() ([]string, error) {
+		// Without the settings of the server, quotas above those of a domain are capped when messages are stored
+		return nil, admin.SetQuota(*target.dataDirPath, *domain, *user, *maxSize, consts.MAX_HOME_DIR_SIZE)
+	}, "/%s/domains/%s/accounts/%s/quota", *domain, *user)
+	fmt.Printf("Quota of %s@%s set to %d\n", *user, *domain, *maxSize)
+}
+
+// go run cmd/control/* accounts-suspend -data-dir /var/lib/email -domain open.email -user alice -reason "Spam"
+func accountsSuspendCommand(args []string) {
+	fs := flag.NewFlagSet("accounts-suspend", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	reason := fs.String("reason", "", "reason given to those reaching the account")
+	fs.Parse(args)
+
+	target.run(http.MethodPut, *reason, func() ([]string, error) {
+		return nil, admin.Suspend(*target.dataDirPath, *domain, *user, *reason)
+	}, "/%s/domains/%s/accounts/%s/suspension", *domain, *user)
+	fmt.Printf("Account %s@%s suspended\n", *user, *domain)
+}
+
+// go run cmd/control/* accounts-unsuspend -data-dir /var/lib/email -domain open.email -user alice
+func accountsUnsuspendCommand(args []string) {
+	fs := flag.NewFlagSet("accounts-unsuspend", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	fs.Parse(args)
+
+	target.run(http.MethodDelete, "", func() ([]string, error) {
+		return nil, admin.Unsuspend(*target.dataDirPath, *domain, *user)
+	}, "/%s/domains/%s/accounts/%s/suspension", *domain, *user)
+	fmt.Printf("Account %s@%s unsuspended\n", *user, *domain)
+}
+
+// go run cmd/control/* accounts-delete -data-dir /var/lib/email -domain open.email -user alice -yes
+func accountsDeleteCommand(args []string) {
+	fs := flag.NewFlagSet("accounts-delete", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	yes := fs.Bool("yes", false, "confirm erasing the account with all its data")
+	fs.Parse(args)
+
+	if !*yes {
+		fmt.Printf("Deleting %s@%s erases all its data, confirm with -yes\n", *user, *domain)
+		os.Exit(1)
+	}
+	target.run(http.MethodDelete, "", func() ([]string, error) {
+		return nil, admin.Delete(*target.dataDirPath, *domain, *user)
+	}, "/%s/domains/%s/accounts/%s", *domain, *user)
+	fmt.Printf("Account %s@%s deleted\n", *user, *domain)
+}
+
+// go run cmd/control/* index-rebuild -data-dir /var/lib/email -domain open.email -user alice
+func indexRebuildCommand(args []string) {
+	fs := flag.NewFlagSet("index-rebuild", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	fs.Parse(args)
+
+	target.run(http.MethodPost, "", func() ([]string, error) {
+		count, err := admin.RebuildIndex(*target.dataDirPath, *domain, *user)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strconv.Itoa(count)}, nil
+	}, "/%s/domains/%s/accounts/%s/index", *domain, *user)
+}
+
+// go run cmd/control/* notifications-purge -data-dir /var/lib/email -domain open.email -user alice
+func notificationsPurgeCommand(args []string) {
+	fs := flag.NewFlagSet("notifications-purge", flag.ExitOnError)
+	target := adminFlags(fs)
+	domain, user := accountFlags(fs)
+	fs.Parse(args)
+
+	target.run(http.MethodDelete, "", func() ([]string, error) {
+		count, err := admin.PurgeNotifications(*target.dataDirPath, *domain, *user)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strconv.Itoa(count)}, nil
+	}, "/%s/domains/%s/accounts/%s/notifications", *domain, *user)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"email.mercata.com/internal/provisioning"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// domainPath returns the directory of a domain hosted in the data directory,
+// made first if create is set.
+func domainPath(dataDirPath, domain string, create bool) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" || strings.ContainsAny(domain, "/\\") || strings.HasPrefix(domain, ".") {
+		fmt.Println("Error: not present or bad domain")
+		os.Exit(1)
+	}
+	path := filepath.Join(dataDirPath, domain)
+	if create {
+		err := os.MkdirAll(path, 0700)
+		if err != nil {
+			fmt.Printf("Could not create domain directory: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	exists, err := utils.FilePathExists(path)
+	if err != nil || !exists {
+		fmt.Printf("Error: domain %s is not hosted in %s\n", domain, dataDirPath)
+		os.Exit(1)
+	}
+	return path
+}
+
+// go run cmd/control/* invites-issue -data-dir /var/lib/email -domain open.email -uses 1 -days 14
+func invitesIssueCommand(args []string) {
+	fs := flag.NewFlagSet("invites-issue", flag.ExitOnError)
+	dataDirPath := fs.String("data-dir", "/tmp", "user data directory path of the server")
+	domain := fs.String("domain", "", "domain the invite is for")
+	localPart := fs.String("local-part", "", "restrict the invite to one local part, required for reserved names")
+	uses := fs.Int("uses", 1, "accounts the invite provisions, 0 for any number")
+	days := fs.Int("days", 0, "days until the invite expires, 0 for never")
+	fs.Parse(args)
+
+	// Invites may precede the first account of the domain
+	path := domainPath(*dataDirPath, *domain, true)
+	if *uses < 0 || *days < 0 {
+		fmt.Println("Error: uses and days may not be negative")
+		os.Exit(1)
+	}
+	var expires time.Time
+	if *days > 0 {
+		expires = time.Now().Add(time.Duration(*days) * 24 * time.Hour)
+	}
+
+	invite, err := provisioning.IssueInvite(path, *localPart, *uses, expires)
+	if err != nil {
+		fmt.Printf("Could not issue invite: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(invite.Code)
+}
+
+// go run cmd/control/* invites-list -data-dir /var/lib/email -domain open.email
+func invitesListCommand(args []string) {
+	fs := flag.NewFlagSet("invites-list", flag.ExitOnError)
+	dataDirPath := fs.String("data-dir", "/tmp", "user data directory path of the server")
+	domain := fs.String("domain", "", "domain to list the invites of")
+	fs.Parse(args)
+
+	path := domainPath(*dataDirPath, *domain, false)
+	invites, err := provisioning.ListInvites(path)
+	if err != nil {
+		fmt.Printf("Could not read invites: %s\n", err)
+		os.Exit(1)
+	}
+	now := time.Now()
+	for _, invite := range invites {
+		localPart, uses, expires, state := invite.LocalPart, fmt.Sprintf("%d/unlimited", invite.Used), invite.Expires, "valid"
+		if localPart == "" {
+			localPart = "*"
+		}
+		if invite.Uses > 0 {
+			uses = fmt.Sprintf("%d/%d", invite.Used, invite.Uses)
+		}
+		if expires == "" {
+			expires = "never"
+		}
+		if !invite.Valid(invite.LocalPart, now) {
+			state = "spent"
+		}
+		fmt.Printf("%s %-6s name %s, used %s, expires %s\n", invite.Code, state, localPart, uses, expires)
+	}
+}
+
+// go run cmd/control/* invites-revoke -data-dir /var/lib/email -domain open.email -code Ab12...
+func invitesRevokeCommand(args []string) {
+	fs := flag.NewFlagSet("invites-revoke", flag.ExitOnError)
+	dataDirPath := fs.String("data-dir", "/tmp", "user data directory path of the server")
+	domain := fs.String("domain", "", "domain of the invite")
+	code := fs.String("code", "", "invite code to revoke")
+	fs.Parse(args)
+
+	path := domainPath(*dataDirPath, *domain, false)
+	err := provisioning.RevokeInvite(path, *code)
+	if err != nil {
+		if errors.Is(err, provisioning.ErrorNoInvite) {
+			fmt.Printf("No invite %s on %s\n", *code, *domain)
+		} else {
+			fmt.Printf("Could not revoke invite: %s\n", err)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("Invite %s revoked\n", *code)
+}
@@ -1 +1,66 @@
 package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Operator tool working on the data directory of the server, or through the
+// admin API of a running one
+
+type CommandFunc func([]string)
+
+var commandMap = map[string]CommandFunc{
+	"admin-keygen":         adminKeygenCommand,
+	"stats":                statsCommand,
+	"domains-list":         domainsListCommand,
+	"domains-add":          domainsAddCommand,
+	"domains-usage":        domainsUsageCommand,
+	"domain-admins-add":    domainAdminsAddCommand,
+	"domain-admins-list":   domainAdminsListCommand,
+	"domain-admins-remove": domainAdminsRemoveCommand,
+	"accounts-list":        accountsListCommand,
+	"accounts-profile":     accountsProfileCommand,
+	"accounts-provision":   accountsProvisionCommand,
+	"accounts-quota":       accountsQuotaCommand,
+	"accounts-suspend":     accountsSuspendCommand,
+	"accounts-unsuspend":   accountsUnsuspendCommand,
+	"accounts-delete":      accountsDeleteCommand,
+	"index-rebuild":        indexRebuildCommand,
+	"notifications-purge":  notificationsPurgeCommand,
+	"invites-issue":        invitesIssueCommand,
+	"invites-list":         invitesListCommand,
+	"invites-revoke":       invitesRevokeCommand,
+}
+
+func main() {
+	fs := flag.NewFlagSet("control", flag.ExitOnError)
+	fs.Parse(os.Args[1:])
+
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <flag-set>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Available flag sets: %s\n", strings.Join(getAvailableCommands(), ", "))
+		return
+	}
+
+	if len(fs.Args()) > 0 {
+		cmdName := fs.Arg(0)
+		if cmdFunc, found := commandMap[cmdName]; found {
+			cmdFunc(fs.Args()[1:])
+		} else {
+			fmt.Fprintf(os.Stderr, "Unknown flag set: %s\n", os.Args[1])
+		}
+	}
+}
+
+func getAvailableCommands() []string {
+	var available []string
+	for cmd := range commandMap {
+		available = append(available, cmd)
+	}
+	sort.Strings(available)
+	return available
+}
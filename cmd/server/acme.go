@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+/* With -acme the server obtains the certificates of its hosts itself, from
+ * Let's Encrypt or the ACME directory given with -acme-directory. They are
+ * issued on the first TLS handshake naming a host the agent serves:
+ *
+ *	the agent hostname and the hostnames of configured domains
+ *	domains with a home directory or a configuration, CNAMEd to this server
+ *	mail.<domain> of those, the host clients look at by default
+ *
+ * Domains bringing their own certificate are left alone. Certificates are
+ * cached in <data-dir>/.acme and renewed ahead of their expiry. Challenges
+ * are answered by TLS-ALPN on the server port, and also over HTTP when
+ * -acme-http-address is given. A test server's roots may be trusted with
+ * -acme-ca-roots.
+ ***************************************************************************/
+
+const ACME_CACHE_DIRECTORY = ".acme"
+const DEFAULT_MAIL_HOSTNAME_PREFIX = "mail."
+
+var ErrorHostNotServed = errors.New("host not served by this agent")
+var ErrorBadCARoots = errors.New("no certificates in ACME CA roots")
+
+func (app *application) newCertManager(cfg *config) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: cfg.acme.directoryURL}
+	if cfg.acme.caRootsPath != "" {
+		data, err := os.ReadFile(cfg.acme.caRootsPath)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, ErrorBadCARoots
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(filepath.Join(cfg.dataDirPath, ACME_CACHE_DIRECTORY)),
+		HostPolicy: app.acmeHostPolicy,
+		Client:     client,
+		Email:      cfg.acme.email,
+	}, nil
+}
+
+// acmeHostPolicy allows certificates for the hosts the agent serves only,
+// so that any name pointed at the server cannot run up issuance limits.
+func (app *application) acmeHostPolicy(_ context.Context, host string) error {
+	host = strings.ToLower(host)
+	if host == strings.ToLower(app.config().mailAgentHostname) {
+		return nil
+	}
+	if c := app.domains().ForHost(host); c != nil {
+		if c.Certificate != nil {
+			return ErrorHostNotServed
+		}
+		return nil
+	}
+	for _, domain := range []string{host, strings.TrimPrefix(host, DEFAULT_MAIL_HOSTNAME_PREFIX)} {
+		if domain == "" || strings.HasPrefix(domain, ".") || strings.ContainsAny(domain, `/\`) {
+			continue
+		}
+		if c := app.domains().Get(domain); c != nil {
+			if c.Certificate != nil {
+				return ErrorHostNotServed
+			}
+			return nil
+		}
+		exists, err := app.domainHomePathExists(domain)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+	return ErrorHostNotServed
+}
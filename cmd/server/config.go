@@ -0,0 +1,428 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/domains"
+	"email.mercata.com/internal/email/notification"
+	"email.mercata.com/internal/logging"
+	"email.mercata.com/internal/provisioning"
+	"email.mercata.com/internal/ratelimit"
+	"email.mercata.com/internal/utils"
+)
+
+/* Settings come from flags and, with -config, from a TOML file of these
+ * sections, all optional:
+ *
+ *	[listener]       address, port, shutdown_timeout, metrics_address
+ *	[tls]            enabled, cert, key
+ *	[acme]           enabled, directory, email, http_address, ca_roots
+ *	[server]         agent_hostname, data_dir, domains_dir, admin_keys
+ *	[limits]         max_profile_size, max_message_size, max_account_size,
+ *	                 notification_time, cache_duration, stamp_bits,
+ *	                 min_free_space
+ *	[rate_limits]    ip, link, fingerprint, provision, contact_request
+ *	[provisioning]   domains, mode, tombstone_days
+ *	[retention]      message_days, notification_days, deletion_grace_days
+ *	[webhooks]       allow_private
+ *	[inbox_fetcher]  enabled, allow_private
+ *	[log]            level
+ *
+ * Every setting stands for the flag named in CONFIG_FLAGS and takes the
+ * same values, durations as strings such as "10m". Flags given on the
+ * command line win over the file.
+ *
+ * On SIGHUP the file, the domains directory and the TLS certificates are
+ * read again. Requests in flight finish with the settings they started
+ * with. The listener, ACME, the data directory, admin keys, webhooks and
+ * the inbox fetcher keep their settings until a restart.
+ ***************************************************************************/
+
+var CONFIG_FLAGS = map[string]string{
+	"listener.address":              "address",
+	"listener.port":                 "port",
+	"listener.shutdown_timeout":     "shutdown-timeout",
+	"listener.metrics_address":      "metrics-address",
+	"tls.enabled":                   "tls",
+	"tls.cert":                      "tls-cert",
+	"tls.key":                       "tls-key",
+	"acme.enabled":                  "acme",
+	"acme.directory":                "acme-directory",
+	"acme.email":                    "acme-email",
+	"acme.http_address":             "acme-http-address",
+	"acme.ca_roots":                 "acme-ca-roots",
+	"server.agent_hostname":         "agent-hostname",
+	"server.data_dir":               "data-dir",
+	"server.domains_dir":            "domains-dir",
+	"server.admin_keys":             "admin-keys",
+	"limits.max_profile_size":       "max-profile-size",
+	"limits.max_message_size":       "max-message-size",
+	"limits.max_account_size":       "max-account-size",
+	"limits.notification_time":      "notification-time",
+	"limits.cache_duration":         "cache-duration",
+	"limits.stamp_bits":             "stamp-bits",
+	"limits.min_free_space":         "min-free-space",
+	"rate_limits.ip":                "ratelimit-ip",
+	"rate_limits.link":              "ratelimit-link",
+	"rate_limits.fingerprint":       "ratelimit-fingerprint",
+	"rate_limits.provision":         "ratelimit-provision",
+	"rate_limits.contact_request":   "ratelimit-contact-request",
+	"provisioning.domains":          "provision",
+	"provisioning.mode":             "provision-mode",
+	"provisioning.tombstone_days":   "tombstone-days",
+	"retention.message_days":        "retention-days",
+	"retention.notification_days":   "notification-ttl-days",
+	"retention.deletion_grace_days": "deletion-grace-days",
+	"webhooks.allow_private":        "webhooks-allow-private",
+	"inbox_fetcher.enabled":         "inbox-fetcher",
+	"inbox_fetcher.allow_private":   "inbox-fetcher-allow-private",
+	"log.level":                     "log-level",
+}
+
+var ErrorUnknownSetting = errors.New("unknown setting")
+var ErrorBadSetting = errors.New("setting is not a string, integer, boolean or list of strings")
+
+// Settings kept as given until the configuration is complete
+type rawSettings struct {
+	provisioningDomains string
+	logLevel            string
+	rates               map[string]*string
+}
+
+func registerFlags(fs *flag.FlagSet, cfg *config, raw *rawSettings) {
+	fs.StringVar(&cfg.listenAddress, "address", "", "Address to listen on, all interfaces if not given")
+	fs.IntVar(&cfg.port, "port", 4000, "Server port")
+	fs.StringVar(&cfg.metricsAddress, "metrics-address", "", "Address of a listener of its own for /metrics, /debug/vars, /healthz and /readyz, such as 127.0.0.1:9090, served on the server port to loopback clients if not given")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 5*time.Minute, "How long requests such as uploads may run on after SIGINT or SIGTERM")
+	fs.StringVar(&cfg.mailAgentHostname, "agent-hostname", "", "Public agent hostname")
+	fs.StringVar(&cfg.domainsDirPath, "domains-dir", "", "Directory of per-domain settings, one directory per hosted domain")
+	fs.StringVar(&cfg.dataDirPath, "data-dir", "/tmp", "User data directory path")
+	fs.IntVar(&cfg.notificationStampBits, "stamp-bits", 0, "Proof of work bits demanded from notifiers without a link, 0 to leave it to accounts")
+
+	fs.Int64Var(&cfg.limits.maxProfileSize, "max-profile-size", consts.MAX_PROFILE_SIZE, "Largest profile accepted, in bytes")
+	fs.Int64Var(&cfg.limits.maxMessageSize, "max-message-size", consts.DEFAULT_MAX_CONTENT_SIZE, "Largest message stored, in bytes, unless the domain sets its own")
+	fs.Int64Var(&cfg.limits.maxAccountSize, "max-account-size", consts.MAX_HOME_DIR_SIZE, "Most an account may store, in bytes, unless the domain sets its own")
+	fs.DurationVar(&cfg.limits.notificationTime, "notification-time", consts.MAX_NOTIFICATION_TIME, "How long acknowledged notifications are kept")
+	fs.DurationVar(&cfg.limits.cacheDuration, "cache-duration", consts.MAX_CACHE_DURATION*time.Second, "How long clients may cache public profiles and images")
+	fs.Int64Var(&cfg.limits.minFreeSpace, "min-free-space", consts.DEFAULT_MAX_CONTENT_SIZE, "Free bytes below which the data directory is reported unhealthy")
+
+	fs.IntVar(&cfg.retention.messageDays, "retention-days", 0, "Days messages are kept, unless the domain sets its own, 0 to keep them")
+	fs.IntVar(&cfg.retention.notificationDays, "notification-ttl-days", 0, "Days notifications are kept, unless the domain sets its own, 0 to keep them")
+	fs.IntVar(&cfg.deletionGraceDays, "deletion-grace-days", 0, "Days accounts deleted by their owners are kept before erasure, 0 to erase at once")
+	fs.StringVar(&cfg.adminKeysPath, "admin-keys", "", "File of public signing keys allowed to use the admin API, disabled if not given")
+	fs.BoolVar(&cfg.webhooksAllowPrivate, "webhooks-allow-private", false, "Allow webhooks to loopback and private network addresses")
+	fs.BoolVar(&cfg.inboxFetcher.enabled, "inbox-fetcher", false, "Fetch messages of links delegated by accounts into their inbox")
+	fs.BoolVar(&cfg.inboxFetcher.allowPrivate, "inbox-fetcher-allow-private", false, "Allow the inbox fetcher to reach loopback and private network addresses")
+
+	fs.StringVar(&raw.logLevel, "log-level", logging.INFO.String(), "Least level of log entries written: debug, info, warn or error")
+
+	fs.StringVar(&raw.provisioningDomains, "provision", "", "Enable provisioning on listed comma separated domains")
+	fs.StringVar(&cfg.provisioning.mode, "provision-mode", provisioning.MODE_OPEN, "Provisioning mode of domains without a policy: open, invite or closed")
+	fs.IntVar(&cfg.provisioning.tombstoneDays, "tombstone-days", 365, "Days the names of deleted accounts may not be provisioned again")
+
+	fs.BoolVar(&cfg.tls.enabled, "tls", false, "Enable TLS")
+	fs.StringVar(&cfg.tls.certPath, "tls-cert", "./tls/cert.pem", "TLS Certificate path")
+	fs.StringVar(&cfg.tls.keyPath, "tls-key", "./tls/key.pem", "TLS Key path")
+
+	fs.BoolVar(&cfg.acme.enabled, "acme", false, "Obtain and renew TLS certificates of the served hosts by ACME, implies TLS")
+	fs.StringVar(&cfg.acme.directoryURL, "acme-directory", autocert.DefaultACMEDirectory, "ACME directory URL")
+	fs.StringVar(&cfg.acme.email, "acme-email", "", "Contact address given to the ACME CA")
+	fs.StringVar(&cfg.acme.httpAddress, "acme-http-address", "", "Address to answer ACME HTTP challenges on, such as :80, TLS-ALPN only if not given")
+	fs.StringVar(&cfg.acme.caRootsPath, "acme-ca-roots", "", "PEM file of roots to trust the ACME directory with, for test servers")
+
+	raw.rates = map[string]*string{
+		"ratelimit-ip":              fs.String("ratelimit-ip", "300/m", "Public requests allowed per client IP, as count/period (s, m, h, d), 0 for unlimited"),
+		"ratelimit-link":            fs.String("ratelimit-link", "60/m", "Authenticated public requests allowed per link"),
+		"ratelimit-fingerprint":     fs.String("ratelimit-fingerprint", "120/m", "Authenticated public requests allowed per signing key fingerprint"),
+		"ratelimit-provision":       fs.String("ratelimit-provision", "1/h", "Provisioning requests allowed per client IP"),
+		"ratelimit-contact-request": fs.String("ratelimit-contact-request", "5/h", "Contact requests allowed per client IP"),
+	}
+}
+
+// loadConfig builds the configuration from the defaults, the file if
+// there is one and the flags given on the command line, in that order.
+func loadConfig(path string, overrides map[string]string) (*config, error) {
+	cfg := &config{}
+	var raw rawSettings
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	registerFlags(fs, cfg, &raw)
+
+	if path != "" {
+		settings, err := readConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, s := range settings {
+			err = fs.Set(CONFIG_FLAGS[s.key], s.value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", path, s.key, err)
+			}
+		}
+	}
+	for name, value := range overrides {
+		err := fs.Set(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+
+	err := cfg.complete(&raw)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+type setting struct {
+	key   string
+	value string
+}
+
+// readConfigFile returns the settings of the file as flag values, in the
+// order of their keys.
+func readConfigFile(path string) ([]setting, error) {
+	var sections map[string]map[string]interface{}
+	_, err := toml.DecodeFile(path, &sections)
+	if err != nil {
+		return nil, err
+	}
+	var settings []setting
+	for section, values := range sections {
+		for name, value := range values {
+			key := section + "." + name
+			if _, found := CONFIG_FLAGS[key]; !found {
+				return nil, fmt.Errorf("%s: %w", key, ErrorUnknownSetting)
+			}
+			s, err := settingValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			settings = append(settings, setting{key: key, value: s})
+		}
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].key < settings[j].key
+	})
+	return settings, nil
+}
+
+func settingValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", ErrorBadSetting
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", ErrorBadSetting
+}
+
+// complete parses and validates what the flags could not
+func (cfg *config) complete(raw *rawSettings) error {
+	cfg.provisioning.domains = nil
+	if raw.provisioningDomains != "" {
+		for _, domainHostname := range strings.Split(raw.provisioningDomains, ",") {
+			domainHostname = strings.ToLower(strings.TrimSpace(domainHostname))
+			if utils.IsValidHostname(domainHostname) {
+				cfg.provisioning.domains = append(cfg.provisioning.domains, domainHostname)
+			}
+		}
+	}
+
+	for _, rate := range []struct {
+		flag string
+		rate *ratelimit.Rate
+	}{
+		{"ratelimit-ip", &cfg.rateLimits.ip},
+		{"ratelimit-link", &cfg.rateLimits.link},
+		{"ratelimit-fingerprint", &cfg.rateLimits.fingerprint},
+		{"ratelimit-provision", &cfg.rateLimits.provision},
+		{"ratelimit-contact-request", &cfg.rateLimits.contactRequest},
+	} {
+		parsed, err := ratelimit.ParseRate(*raw.rates[rate.flag])
+		if err != nil {
+			return fmt.Errorf("-%s: %w", rate.flag, err)
+		}
+		*rate.rate = parsed
+	}
+
+	level, err := logging.ParseLevel(raw.logLevel)
+	if err != nil {
+		return fmt.Errorf("-log-level: %w", err)
+	}
+	cfg.logLevel = level
+
+	if !provisioning.ValidMode(cfg.provisioning.mode) {
+		return fmt.Errorf("-provision-mode: %w", provisioning.ErrorBadPolicy)
+	}
+	for _, positive := range []struct {
+		flag  string
+		value int64
+	}{
+		{"max-profile-size", cfg.limits.maxProfileSize},
+		{"max-message-size", cfg.limits.maxMessageSize},
+		{"max-account-size", cfg.limits.maxAccountSize},
+		{"notification-time", int64(cfg.limits.notificationTime)},
+	} {
+		if positive.value <= 0 {
+			return fmt.Errorf("-%s: must be positive", positive.flag)
+		}
+	}
+	for _, nonNegative := range []struct {
+		flag  string
+		value int64
+	}{
+		{"cache-duration", int64(cfg.limits.cacheDuration)},
+		{"shutdown-timeout", int64(cfg.shutdownTimeout)},
+		{"min-free-space", cfg.limits.minFreeSpace},
+		{"stamp-bits", int64(cfg.notificationStampBits)},
+		{"tombstone-days", int64(cfg.provisioning.tombstoneDays)},
+		{"deletion-grace-days", int64(cfg.deletionGraceDays)},
+		{"retention-days", int64(cfg.retention.messageDays)},
+		{"notification-ttl-days", int64(cfg.retention.notificationDays)},
+	} {
+		if nonNegative.value < 0 {
+			return fmt.Errorf("-%s: may not be negative", nonNegative.flag)
+		}
+	}
+	if cfg.port <= 0 || cfg.port > 65535 {
+		return fmt.Errorf("-port: not a port number")
+	}
+	return nil
+}
+
+// config returns the settings requests are served with
+func (app *application) config() *config {
+	return app.cfg.Load()
+}
+
+// domains returns the settings of the hosted domains
+func (app *application) domains() *domains.Set {
+	return app.domainSet.Load()
+}
+
+// getCertificate picks the certificate of the domain the client asks for,
+// then one by ACME, then the one of -tls-cert.
+func (app *application) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, _ := app.domains().GetCertificate(hello); cert != nil {
+		return cert, nil
+	}
+	if app.certManager != nil {
+		cert, err := app.certManager.GetCertificate(hello)
+		if err == nil || app.certificate.Load() == nil {
+			return cert, err
+		}
+	}
+	return app.certificate.Load(), nil
+}
+
+// reloadOnHangup reloads the configuration on every SIGHUP, for as long as
+// the server runs
+func (app *application) reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		err := app.reloadConfig()
+		if err != nil {
+			app.log.Error("Could not reload configuration, keeping the current one", "error", err)
+			continue
+		}
+		app.log.Info("Reloaded configuration")
+	}
+}
+
+// reloadConfig loads everything before changing anything, so that a bad
+// configuration leaves the server as it was.
+func (app *application) reloadConfig() error {
+	next, err := loadConfig(app.configPath, app.configOverrides)
+	if err != nil {
+		return err
+	}
+	app.keepStartupSettings(next, app.config())
+
+	domainSet, err := domains.Load(next.domainsDirPath)
+	if err != nil {
+		return fmt.Errorf("-domains-dir: %w", err)
+	}
+	var certificate *tls.Certificate
+	if next.tls.enabled {
+		cert, err := tls.LoadX509KeyPair(next.tls.certPath, next.tls.keyPath)
+		if err != nil {
+			return fmt.Errorf("-tls-cert: %w", err)
+		}
+		certificate = &cert
+	}
+
+	app.domainSet.Store(domainSet)
+	if certificate != nil {
+		app.certificate.Store(certificate)
+	}
+	app.limiters.ip.SetRate(next.rateLimits.ip)
+	app.limiters.link.SetRate(next.rateLimits.link)
+	app.limiters.fingerprint.SetRate(next.rateLimits.fingerprint)
+	app.limiters.provision.SetRate(next.rateLimits.provision)
+	app.limiters.contactRequest.SetRate(next.rateLimits.contactRequest)
+	notification.SetMaxAcknowledgedAge(next.limits.notificationTime)
+	app.log.SetLevel(next.logLevel)
+	app.cfg.Store(next)
+	return nil
+}
+
+// keepStartupSettings carries over the settings that only change with a
+// restart, telling about those changed in the file.
+func (app *application) keepStartupSettings(next, current *config) {
+	for _, s := range []struct {
+		flag    string
+		changed bool
+	}{
+		{"address", next.listenAddress != current.listenAddress},
+		{"port", next.port != current.port},
+		{"metrics-address", next.metricsAddress != current.metricsAddress},
+		{"tls", next.tls.enabled != current.tls.enabled},
+		{"acme", next.acme != current.acme},
+		{"data-dir", next.dataDirPath != current.dataDirPath},
+		{"admin-keys", next.adminKeysPath != current.adminKeysPath},
+		{"webhooks-allow-private", next.webhooksAllowPrivate != current.webhooksAllowPrivate},
+		{"inbox-fetcher", next.inboxFetcher != current.inboxFetcher},
+	} {
+		if s.changed {
+			app.log.Warn("Configuration changes on restart only", "flag", s.flag)
+		}
+	}
+	next.listenAddress = current.listenAddress
+	next.port = current.port
+	next.metricsAddress = current.metricsAddress
+	next.tls.enabled = current.tls.enabled
+	next.acme = current.acme
+	next.dataDirPath = current.dataDirPath
+	next.adminKeysPath = current.adminKeysPath
+	next.webhooksAllowPrivate = current.webhooksAllowPrivate
+	next.inboxFetcher = current.inboxFetcher
+}
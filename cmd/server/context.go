@@ -7,3 +7,5 @@ const signingFingerprintContextKey = contextKey("signing-fingerprint")
 const userContextKey = contextKey("user")
 const domainContextKey = contextKey("domain")
 const linkContextKey = contextKey("link")
+const adminContextKey = contextKey("admin")
+const requestInfoContextKey = contextKey("request-info")
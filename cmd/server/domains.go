@@ -0,0 +1,142 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/notification"
+	"email.mercata.com/internal/email/requests"
+	"email.mercata.com/internal/email/storage"
+	"email.mercata.com/internal/provisioning"
+	"email.mercata.com/internal/utils"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How often messages, notifications and contact requests past their time are removed
+const EXPIRE_DOMAIN_DATA_INTERVAL = time.Hour
+
+// domainPolicy returns the provisioning policy of the domain, from the
+// configuration directory, the data directory or the defaults, and whether
+// the domain is open to provisioning at all.
+func (app *application) domainPolicy(domain string) (*provisioning.Policy, bool, error) {
+	domainPath := filepath.Join(app.config().dataDirPath, domain)
+	if c := app.domains().Get(domain); c != nil && c.PolicyPath != "" {
+		policy, err := provisioning.LoadPolicyFile(c.PolicyPath, app.config().provisioning.mode)
+		return policy, true, err
+	}
+	open := utils.ListContains(app.config().provisioning.domains, domain) || provisioning.HasPolicy(domainPath)
+	policy, err := provisioning.LoadPolicy(domainPath, app.config().provisioning.mode)
+	return policy, open, err
+}
+
+// maxMessageSize is the largest message accounts of the domain may store
+func (app *application) maxMessageSize(domain string) int64 {
+	if c := app.domains().Get(domain); c != nil && c.MaxMessageSize > 0 {
+		return c.MaxMessageSize
+	}
+	return app.config().limits.maxMessageSize
+}
+
+// maxAccountSize is the most an account of the domain may store in all
+func (app *application) maxAccountSize(domain string) int64 {
+	if c := app.domains().Get(domain); c != nil && c.MaxAccountSize > 0 {
+		return c.MaxAccountSize
+	}
+	return app.config().limits.maxAccountSize
+}
+
+// accountSizeLimit is the most the account may store, its quota if below
+// the limit of its domain
+func (app *application) accountSizeLimit(userHomeDirPath, domain string) (int64, error) {
+	maxHomeDirSize := app.maxAccountSize(domain)
+	quota, err := account.Quota(userHomeDirPath)
+	if err != nil {
+		return 0, err
+	}
+	if quota > 0 && quota < maxHomeDirSize {
+		maxHomeDirSize = quota
+	}
+	return maxHomeDirSize, nil
+}
+
+// mailAgentHostnames are listed in the mail.txt served for the host
+func (app *application) mailAgentHostnames(host string) []string {
+	if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+		host = host[:i]
+	}
+	if c := app.domains().ForHost(host); c != nil && len(c.Hostnames) > 0 {
+		return c.Hostnames
+	}
+	return []string{app.config().mailAgentHostname}
+}
+
+// expireDomainData removes messages and notifications of the accounts past
+// the retention of their domain, or of the server, acknowledged
+// notifications past the notification time and contact requests past
+// theirs, for as long as the server runs
+func (app *application) expireDomainData() {
+	defer app.janitors.Done()
+	ticker := time.NewTicker(EXPIRE_DOMAIN_DATA_INTERVAL)
+	defer ticker.Stop()
+	for {
+		cfg := app.config()
+		domainNames, err := utils.ListDirectories(cfg.dataDirPath)
+		if err != nil {
+			app.log.Error("Could not list domains to expire", "error", err)
+		}
+		for _, domain := range domainNames {
+			if strings.HasPrefix(domain, ".") {
+				continue
+			}
+			retentionDays, notificationTTLDays := cfg.retention.messageDays, cfg.retention.notificationDays
+			if c := app.domains().Get(domain); c != nil {
+				if c.RetentionDays > 0 {
+					retentionDays = c.RetentionDays
+				}
+				if c.NotificationTTLDays > 0 {
+					notificationTTLDays = c.NotificationTTLDays
+				}
+			}
+			users, err := utils.ListDirectories(filepath.Join(cfg.dataDirPath, domain))
+			if err != nil {
+				continue
+			}
+			for _, user := range users {
+				if strings.HasPrefix(user, ".") {
+					continue
+				}
+				userHomeDirPath := filepath.Join(cfg.dataDirPath, domain, user)
+				if retentionDays > 0 {
+					deleted, err := storage.ExpireMessages(userHomeDirPath, time.Now().AddDate(0, 0, -retentionDays))
+					if err != nil {
+						app.log.Error("Could not expire messages", "account", address.JoinAddress(domain, user), "error", err)
+					} else if deleted > 0 {
+						app.log.Info("Expired messages", "account", address.JoinAddress(domain, user), "count", deleted)
+						app.metrics.messagesDeleted.Add(float64(deleted), domain, MESSAGE_DELETED_EXPIRED)
+					}
+				}
+				if notificationTTLDays > 0 {
+					_, err := notification.Expire(userHomeDirPath, time.Now().AddDate(0, 0, -notificationTTLDays))
+					if err != nil {
+						app.log.Error("Could not expire notifications", "account", address.JoinAddress(domain, user), "error", err)
+					}
+				}
+				// Acknowledged ones go after the notification time, whatever the TTL
+				_, err := notification.Sweep(userHomeDirPath)
+				if err != nil {
+					app.log.Error("Could not sweep notifications", "account", address.JoinAddress(domain, user), "error", err)
+				}
+				err = requests.Expire(userHomeDirPath)
+				if err != nil {
+					app.log.Error("Could not expire contact requests", "account", address.JoinAddress(domain, user), "error", err)
+				}
+			}
+		}
+		select {
+		case <-app.stopping.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"email.mercata.com/internal/admin"
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/email/audit"
+	"email.mercata.com/internal/provisioning"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest suspension reason, provisioning mode or quota accepted in a body
+const MAX_ADMIN_BODY_SIZE = 1024
+
+// adminError maps the errors of the admin package to responses
+func (app *application) adminError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, admin.ErrorBadName), errors.Is(err, provisioning.ErrorBadPolicy), errors.Is(err, admin.ErrorBadProfile),
+		errors.Is(err, provisioning.ErrorBadLocalPart), errors.Is(err, account.ErrorBadQuota):
+		app.clientError(w, http.StatusBadRequest)
+	case errors.Is(err, admin.ErrorNoDomain), errors.Is(err, admin.ErrorNoAccount), errors.Is(err, account.ErrorNotSuspended):
+		app.notFound(w)
+	case errors.Is(err, admin.ErrorAccountExists), errors.Is(err, admin.ErrorNameBuried):
+		app.clientError(w, http.StatusConflict)
+	case errors.Is(err, provisioning.ErrorNameForbidden), errors.Is(err, provisioning.ErrorDomainFull):
+		app.forbidden(w)
+	default:
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) writeAdminLines(w http.ResponseWriter, r *http.Request, lines []string) {
+	w.Header().Set("Content-Type", "text/plain")
+	for _, line := range lines {
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			app.requestLog(r).Error("Could not write admin response", "error", err)
+			return
+		}
+	}
+}
+
+func (app *application) logAdmin(r *http.Request, action string) {
+	// The admin is named by the request log
+	app.requestLog(r).Info("Admin " + action)
+}
+
+func readAdminBody(r *http.Request) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, MAX_ADMIN_BODY_SIZE))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (app *application) getAdminStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := admin.ServerStats(app.config().dataDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.writeAdminLines(w, r, stats.ToLines())
+}
+
+func (app *application) listAdminDomains(w http.ResponseWriter, r *http.Request) {
+	domains, err := admin.ListDomains(app.config().dataDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	var lines []string
+	for _, d := range domains {
+		lines = append(lines, d.ToLine())
+	}
+	app.writeAdminLines(w, r, lines)
+}
+
+// Hosts the domain open to provisioning in the mode given in the body
+func (app *application) storeAdminDomain(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain := params.ByName("domain")
+	mode, err := readAdminBody(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = admin.AddProvisioningDomain(app.config().dataDirPath, domain, mode)
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logAdmin(r, fmt.Sprintf("domain %s provisioning %s", domain, mode))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) getAdminDomainUsage(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	d, err := admin.DomainUsage(app.config().dataDirPath, params.ByName("domain"))
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.writeAdminLines(w, r, []string{d.ToLine()})
+}
+
+func (app *application) listAdminAccounts(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	accounts, err := admin.ListAccounts(app.config().dataDirPath, params.ByName("domain"))
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	var lines []string
+	for _, a := range accounts {
+		lines = append(lines, a.ToLine())
+	}
+	app.writeAdminLines(w, r, lines)
+}
+
+func (app *application) getAdminProfile(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	data, err := admin.Profile(app.config().dataDirPath, params.ByName("domain"), params.ByName("user"))
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = w.Write(data)
+	if err != nil {
+		app.requestLog(r).Error("Could not write admin response", "error", err)
+	}
+}
+
+// auditAccount appends the admin action to the trail of the account, which
+// the action was on and so exists
+func (app *application) auditAccount(r *http.Request, domain, user, action, subject string) {
+	userHomeDirPath, err := admin.AccountPath(app.config().dataDirPath, domain, user)
+	if err != nil {
+		app.requestLog(r).Error("Could not append to audit trail", "action", action, "error", err)
+		return
+	}
+	app.audit(r, userHomeDirPath, action, subject)
+}
+
+// Provisions the account with the profile in the body, whatever the
+// provisioning mode of the domain
+func (app *application) provisionAdminAccount(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain, user := params.ByName("domain"), params.ByName("user")
+	profileData, err := io.ReadAll(io.LimitReader(r.Body, app.config().limits.maxProfileSize))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	policy, _, err := app.domainPolicy(strings.ToLower(domain))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	p, err := admin.Provision(app.config().dataDirPath, domain, user, profileData, policy, time.Duration(app.config().provisioning.tombstoneDays)*24*time.Hour)
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logProfileKeys(p)
+	app.logAdmin(r, fmt.Sprintf("provisioned %s", p.User.Address))
+	userHomeDirPath, _, err := app.userHomePath(strings.ToLower(domain), strings.ToLower(user))
+	if err == nil {
+		app.audit(r, userHomeDirPath, audit.ACTION_PROVISIONED, p.User.Address)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Sets the quota of the account to the bytes in the body, 0 to remove it
+func (app *application) setAdminQuota(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain, user := params.ByName("domain"), params.ByName("user")
+	body, err := readAdminBody(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	maxSize, err := strconv.ParseInt(body, 10, 64)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	err = admin.SetQuota(app.config().dataDirPath, domain, user, maxSize, app.maxAccountSize(strings.ToLower(domain)))
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logAdmin(r, fmt.Sprintf("set quota of %s@%s to %d", user, domain, maxSize))
+	app.auditAccount(r, domain, user, audit.ACTION_QUOTA_SET, strconv.FormatInt(maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Suspends the account for the reason given in the body
+func (app *application) suspendAdminAccount(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain, user := params.ByName("domain"), params.ByName("user")
+	reason, err := readAdminBody(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = admin.Suspend(app.config().dataDirPath, domain, user, reason)
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logAdmin(r, fmt.Sprintf("suspended %s@%s", user, domain))
+	app.auditAccount(r, domain, user, audit.ACTION_SUSPENDED, reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) unsuspendAdminAccount(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain, user := params.ByName("domain"), params.ByName("user")
+	err := admin.Unsuspend(app.config().dataDirPath, domain, user)
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logAdmin(r, fmt.Sprintf("unsuspended %s@%s", user, domain))
+	app.auditAccount(r, domain, user, audit.ACTION_UNSUSPENDED, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) deleteAdminAccount(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain, user := params.ByName("domain"), params.ByName("user")
+	err := admin.Delete(app.config().dataDirPath, domain, user)
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logAdmin(r, fmt.Sprintf("deleted %s@%s", user, domain))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Rebuilds the messages index and answers with the number of entries
+func (app *application) rebuildAdminIndex(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain, user := params.ByName("domain"), params.ByName("user")
+	count, err := admin.RebuildIndex(app.config().dataDirPath, domain, user)
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logAdmin(r, fmt.Sprintf("rebuilt index of %s@%s", user, domain))
+	app.writeAdminLines(w, r, []string{strconv.Itoa(count)})
+}
+
+// Deletes all notifications and answers with their number
+func (app *application) purgeAdminNotifications(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	domain, user := params.ByName("domain"), params.ByName("user")
+	count, err := admin.PurgeNotifications(app.config().dataDirPath, domain, user)
+	if err != nil {
+		app.adminError(w, r, err)
+		return
+	}
+	app.logAdmin(r, fmt.Sprintf("purged notifications of %s@%s", user, domain))
+	app.auditAccount(r, domain, user, audit.ACTION_NOTIFICATIONS_PURGED, strconv.Itoa(count))
+	app.writeAdminLines(w, r, []string{strconv.Itoa(count)})
+}
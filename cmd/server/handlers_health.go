@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"email.mercata.com/internal/utils"
+)
+
+// Prefix of the files written to tell whether the data directory is writable
+const HEALTH_CHECK_FILENAME_PREFIX = ".healthz-"
+
+// checkHealth answers with a line per check and whether all passed
+func (app *application) checkHealth() ([]string, bool) {
+	cfg := app.config()
+	healthy := true
+	var lines []string
+
+	err := checkWritable(cfg.dataDirPath)
+	if err != nil {
+		app.log.Error("Health check: data directory not writable", "error", err)
+		lines = append(lines, "data-dir: not writable")
+		healthy = false
+	} else {
+		lines = append(lines, "data-dir: ok")
+	}
+
+	free, err := utils.FreeSpace(cfg.dataDirPath)
+	switch {
+	case err != nil:
+		app.log.Warn("Health check: free space unknown", "error", err)
+		lines = append(lines, "free-space: unknown")
+		healthy = false
+	case free < cfg.limits.minFreeSpace:
+		lines = append(lines, fmt.Sprintf("free-space: %d bytes, below %d", free, cfg.limits.minFreeSpace))
+		healthy = false
+	default:
+		lines = append(lines, fmt.Sprintf("free-space: %d bytes", free))
+	}
+	return lines, healthy
+}
+
+func checkWritable(dirPath string) error {
+	file, err := os.CreateTemp(dirPath, HEALTH_CHECK_FILENAME_PREFIX+"*")
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString("ok\n")
+	closeErr := file.Close()
+	removeErr := os.Remove(file.Name())
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return removeErr
+}
+
+func (app *application) writeHealth(w http.ResponseWriter, lines []string, healthy bool) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// Whether the server can store what it is sent
+func (app *application) getHealth(w http.ResponseWriter, r *http.Request) {
+	lines, healthy := app.checkHealth()
+	app.writeHealth(w, lines, healthy)
+}
+
+// Whether the server should be sent requests, not so once it drains
+func (app *application) getReadiness(w http.ResponseWriter, r *http.Request) {
+	lines, healthy := app.checkHealth()
+	app.drainMutex.Lock()
+	draining := app.draining
+	app.drainMutex.Unlock()
+	if draining {
+		lines = append(lines, "draining")
+		healthy = false
+	}
+	app.writeHealth(w, lines, healthy)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/audit"
+	profilePkg "email.mercata.com/internal/email/profile"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How often accounts are checked for deletions past their grace period
+const ERASE_DUE_ACCOUNTS_INTERVAL = time.Hour
+
+// Deletes the own account on a signed confirmation, at once or after the
+// grace period of the server, which is then answered with 202 Accepted
+func (app *application) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	confirmation, err := account.ConfirmationFromHeader(r.Header.Get(consts.ACCOUNT_DELETION_HEADER))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	// Only the current signing key of the profile confirms
+	localProfile, err := profilePkg.GetLocalProfile(userHomeDirPath, domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	accountAddress := address.JoinAddress(domain, user)
+	err = confirmation.Verify(accountAddress, localProfile.User.PublicSigningKey)
+	if err != nil {
+		app.requestLog(r).Info("Refused deletion of account", "error", err)
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	if app.config().deletionGraceDays > 0 {
+		deletion, err := account.ScheduleDeletion(userHomeDirPath, time.Duration(app.config().deletionGraceDays)*24*time.Hour)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.requestLog(r).Info("Account to be deleted", "erase_after", deletion.EraseAfter)
+		app.audit(r, userHomeDirPath, audit.ACTION_DELETION_REQUESTED, deletion.EraseAfter)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, strings.Join(deletion.ToLines(), "\n"))
+		return
+	}
+
+	err = account.Erase(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.requestLog(r).Info("Account deleted by its owner")
+	w.WriteHeader(http.StatusOK)
+}
+
+// The pending deletion of the own account, if any
+func (app *application) getAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	deletion, err := account.PendingDeletion(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if deletion == nil {
+		app.notFound(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprintln(w, strings.Join(deletion.ToLines(), "\n"))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = account.CancelDeletion(userHomeDirPath)
+	if err != nil {
+		if errors.Is(err, account.ErrorNoDeletion) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	app.requestLog(r).Info("Account deletion cancelled")
+	app.audit(r, userHomeDirPath, audit.ACTION_DELETION_CANCELLED, "")
+	w.WriteHeader(http.StatusOK)
+}
+
+// eraseDueAccounts erases the accounts whose grace period has passed, for
+// as long as the server runs
+func (app *application) eraseDueAccounts() {
+	defer app.janitors.Done()
+	ticker := time.NewTicker(ERASE_DUE_ACCOUNTS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		erased, err := account.EraseDue(app.config().dataDirPath, func(accountAddress string, err error) {
+			app.log.Error("Could not erase deleted account", "account", accountAddress, "error", err)
+		})
+		if err != nil {
+			app.log.Error("Could not erase deleted accounts", "error", err)
+		}
+		for _, accountAddress := range erased {
+			app.log.Info("Account deleted by its owner", "account", accountAddress)
+		}
+		select {
+		case <-app.stopping.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/audit"
+	"email.mercata.com/internal/utils"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Audit trail of the account, oldest first, as stored. It may be narrowed
+// down with the query parameters since (RFC3339) and action.
+func (app *application) listAudit(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var since time.Time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		t, err := utils.ParseRFC3339Time(sinceStr)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		since = *t
+	}
+
+	entries, err := audit.Entries(userHomeDirPath, since, r.URL.Query().Get("action"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, e := range entries {
+		_, err = fmt.Fprintln(w, e.ToLine())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
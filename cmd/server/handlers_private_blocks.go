@@ -0,0 +1,102 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/audit"
+	"email.mercata.com/internal/email/storage"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+func (app *application) listBlocks(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := storage.ListBlocks(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, entry := range entries {
+		_, err = fmt.Fprintln(w, entry)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
+
+func (app *application) storeBlock(w http.ResponseWriter, r *http.Request) {
+	app.changeBlock(w, r, storage.AddBlock, audit.ACTION_BLOCK_ADDED)
+}
+
+func (app *application) deleteBlock(w http.ResponseWriter, r *http.Request) {
+	app.changeBlock(w, r, storage.RemoveBlock, audit.ACTION_BLOCK_REMOVED)
+}
+
+func (app *application) changeBlock(w http.ResponseWriter, r *http.Request, change func(userHomeDirPath, kind, value string) error, action string) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	kind, value := params.ByName("kind"), params.ByName("value")
+	err = change(userHomeDirPath, kind, value)
+	if err != nil {
+		if errors.Is(err, storage.ErrorBadBlockEntry) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	entry, _ := storage.BlockEntry(kind, value)
+	app.audit(r, userHomeDirPath, action, entry)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// callerIsBlocked tells whether the authenticated public caller is on the
+// blocklist of the account.
+func (app *application) callerIsBlocked(r *http.Request, userHomeDirPath string) (bool, error) {
+	link, _ := r.Context().Value(linkContextKey).(string)
+	fingerprint, _ := r.Context().Value(signingFingerprintContextKey).(string)
+	return storage.IsBlocked(userHomeDirPath, link, fingerprint)
+}
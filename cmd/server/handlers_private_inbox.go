@@ -0,0 +1,470 @@
+package main
+
+import (
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/audit"
+	"email.mercata.com/internal/email/inbox"
+	"email.mercata.com/internal/email/links"
+	profilePkg "email.mercata.com/internal/email/profile"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Largest delegation record accepted
+const MAX_FETCH_DELEGATION_SIZE = 4096
+
+// Hosts of an author an account may name instead of looking them up
+const MAX_FETCH_DELEGATION_HOSTS = 5
+
+// The public part of the fetch key, which the owner delegates to
+func (app *application) getFetchKey(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	fetchKey, err := inbox.LoadFetchKey(userHomeDirPath)
+	if err != nil {
+		if errors.Is(err, inbox.ErrorNoFetchKey) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprintln(w, fetchKey.PublicKeyBase64)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// Makes a new fetch key, voiding all delegations to the previous one
+func (app *application) createFetchKey(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	fetchKey, err := inbox.CreateFetchKey(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, userHomeDirPath, audit.ACTION_FETCH_KEY_CREATED, fetchKey.Fingerprint)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprintln(w, fetchKey.PublicKeyBase64)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) listFetchDelegations(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	records, err := inbox.ListDelegations(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, record := range records {
+		_, err = fmt.Fprintln(w, record.ToLine())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
+
+// The body holds the delegation record, which must delegate the link
+// between the account and the author to the current fetch key.
+func (app *application) storeFetchDelegation(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	link := strings.ToLower(params.ByName("link"))
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MAX_FETCH_DELEGATION_SIZE+1))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if len(body) > MAX_FETCH_DELEGATION_SIZE {
+		app.clientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	record, err := inbox.RecordFromData(link, body)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if !address.ValidEmailAddress(record.Author) || len(record.Hosts) > MAX_FETCH_DELEGATION_HOSTS {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	for _, host := range record.Hosts {
+		if !inbox.ValidHost(host) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	record.Author, _, _ = address.ParseEmailAddress(record.Author)
+	if links.Make(record.Author, user+"@"+domain) != link {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	fetchKey, err := inbox.LoadFetchKey(userHomeDirPath)
+	if err != nil {
+		if errors.Is(err, inbox.ErrorNoFetchKey) {
+			app.clientError(w, http.StatusConflict)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	profile, err := profilePkg.GetLocalProfile(userHomeDirPath, domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// Only the account may delegate, and only to the key of this server
+	if record.Delegation.FetchKey != fetchKey.PublicKeyBase64 || record.Delegation.ReaderKey != profile.User.PublicSigningKeyBase64 {
+		app.forbidden(w)
+		return
+	}
+	_, err = record.Delegation.Verify(link, fetchKey.Fingerprint)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = inbox.StoreDelegation(userHomeDirPath, record)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, userHomeDirPath, audit.ACTION_FETCH_DELEGATION_STORED, link)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) deleteFetchDelegation(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	link := strings.ToLower(params.ByName("link"))
+	if !links.Valid(link) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = inbox.DeleteDelegation(userHomeDirPath, link)
+	if err != nil {
+		if errors.Is(err, inbox.ErrorNoDelegation) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, userHomeDirPath, audit.ACTION_FETCH_DELEGATION_DELETED, link)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Fetches the link right away rather than waiting for a notification,
+// responding with the count of messages fetched
+func (app *application) fetchDelegatedLink(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	link := strings.ToLower(params.ByName("link"))
+	if !links.Valid(link) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	count, err := app.fetcher.Fetch(userHomeDirPath, link)
+	if err != nil {
+		switch {
+		case errors.Is(err, inbox.ErrorNoDelegation):
+			app.notFound(w)
+		case errors.Is(err, inbox.ErrorFetchRunning):
+			app.clientError(w, http.StatusConflict)
+		case errors.Is(err, inbox.ErrorAccountFull):
+			app.clientError(w, http.StatusRequestEntityTooLarge)
+		default:
+			// Failures reaching the author are not ours
+			app.requestLog(r).Warn("Inbox fetch failed", "fetched_link", link, "error", err)
+			app.clientError(w, http.StatusBadGateway)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprintln(w, count)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) listInbox(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := inbox.List(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, message := range messages {
+		_, err = fmt.Fprintln(w, message.ToLine())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
+
+// Served as the author served it, the envelope as headers and the payload
+// as body
+func (app *application) getInboxMessage(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	link := strings.ToLower(params.ByName("link"))
+	messageID := strings.ToLower(params.ByName("messageid"))
+	if !links.Valid(link) || !utils.ValidMessageID(messageID) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if !inbox.MessageExists(userHomeDirPath, link, messageID) {
+		app.notFound(w)
+		return
+	}
+
+	envelopeFileContents, err := ioutil.ReadFile(inbox.EnvelopePath(userHomeDirPath, link, messageID))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = writeEnvelopeAsResponseHeaders(&envelopeFileContents, w)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	payloadFile, err := os.Open(inbox.PayloadPath(userHomeDirPath, link, messageID))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	defer payloadFile.Close()
+	payloadFileStat, err := payloadFile.Stat()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename="+consts.MESSAGE_DIR_PAYLOAD_FILE_NAME)
+	w.Header().Set("Content-Length", strconv.FormatInt(payloadFileStat.Size(), 10))
+	http.ServeContent(w, r, consts.MESSAGE_DIR_PAYLOAD_FILE_NAME, payloadFileStat.ModTime(), payloadFile)
+}
+
+func (app *application) deleteInboxMessage(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	link := strings.ToLower(params.ByName("link"))
+	messageID := strings.ToLower(params.ByName("messageid"))
+	if !links.Valid(link) || !utils.ValidMessageID(messageID) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = inbox.Delete(userHomeDirPath, link, messageID)
+	if err != nil {
+		if errors.Is(err, inbox.ErrorNoInboxMessage) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
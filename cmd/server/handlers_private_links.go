@@ -1,6 +1,7 @@
 package main
 
 import (
+	"email.mercata.com/internal/email/audit"
 	"email.mercata.com/internal/email/storage"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
@@ -23,7 +24,7 @@ func (app *application) listLinks(w http.ResponseWriter, r *http.Request) {
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -33,13 +34,13 @@ func (app *application) listLinks(w http.ResponseWriter, r *http.Request) {
 
 	linksLines, err := storage.ListLinks(userHomeDirPath)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	for _, line := range linksLines {
 		_, err = fmt.Fprintln(w, line)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 	}
@@ -59,7 +60,7 @@ func (app *application) storeLink(w http.ResponseWriter, r *http.Request) {
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -72,15 +73,16 @@ func (app *application) storeLink(w http.ResponseWriter, r *http.Request) {
 
 	contact, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	err = storage.StoreLink(userHomeDirPath, link, contact)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
+	app.audit(r, userHomeDirPath, audit.ACTION_LINK_STORED, link)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -99,7 +101,7 @@ func (app *application) deleteLink(w http.ResponseWriter, r *http.Request) {
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -112,9 +114,10 @@ func (app *application) deleteLink(w http.ResponseWriter, r *http.Request) {
 
 	err = storage.DeleteLink(userHomeDirPath, link)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
+	app.audit(r, userHomeDirPath, audit.ACTION_LINK_DELETED, link)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/audit"
 	messagePkg "email.mercata.com/internal/email/message"
 	"email.mercata.com/internal/email/storage"
 	utils "email.mercata.com/internal/utils"
@@ -30,38 +30,49 @@ func (app *application) getMessagesStatus(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	userHomeDirPath := filepath.Join(app.config.dataDirPath, domain, user)
+	userHomeDirPath := filepath.Join(app.config().dataDirPath, domain, user)
 
 	w.Header().Set("Content-Type", "text/plain")
 
 	msgRow, err := storage.MessagesStatus(userHomeDirPath)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	for _, line := range msgRow {
 		_, err = fmt.Fprintln(w, line)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 	}
 }
 
 func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
-	maxAllowedSize := int64(consts.DEFAULT_MAX_CONTENT_SIZE)
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	maxAllowedSize := app.maxMessageSize(domain)
 
 	contentLength := maxAllowedSize
 	contentLengthStr := r.Header.Get("Content-Length")
 	if contentLengthStr == "" {
-		app.infoLog.Printf("Content-Length not provided, assuming maximum allowed size: %d", consts.DEFAULT_MAX_CONTENT_SIZE)
+		app.requestLog(r).Debug("Content-Length not provided, assuming maximum allowed size", "max_size", maxAllowedSize)
 	} else {
 		var err error
 		contentLength, err = strconv.ParseInt(contentLengthStr, 10, 64)
 		if err != nil {
 			contentLength = maxAllowedSize
-			app.errorLog.Printf("Invalid Content-Length provided [%s], assuming maximum allowed size %d", contentLengthStr, maxAllowedSize)
+			app.requestLog(r).Warn("Invalid Content-Length provided, assuming maximum allowed size", "content_length", contentLengthStr, "max_size", maxAllowedSize)
 		} else {
 			if contentLength > maxAllowedSize {
 				http.Error(w, "Unacceptable Message-Size", http.StatusBadRequest)
@@ -72,20 +83,9 @@ func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
 
 	limitedReader := io.LimitReader(r.Body, contentLength)
 
-	domain, ok := r.Context().Value(domainContextKey).(string)
-	if !ok {
-		app.clientError(w, http.StatusBadRequest)
-		return
-	}
-	user, ok := r.Context().Value(userContextKey).(string)
-	if !ok {
-		app.clientError(w, http.StatusBadRequest)
-		return
-	}
-
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -96,11 +96,16 @@ func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
 	// Maybe the user tries to fill up our server
 	homeDirSize, err := utils.DirectorySize(userHomeDirPath)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
+	}
+	maxHomeDirSize, err := app.accountSizeLimit(userHomeDirPath, domain)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
-	if (homeDirSize + contentLength) > consts.MAX_HOME_DIR_SIZE {
-		app.infoLog.Printf("home directory too large %d, not accepting additional %d", homeDirSize, contentLength)
+	if (homeDirSize + contentLength) > maxHomeDirSize {
+		app.requestLog(r).Info("Home directory too large for message", "home_size", homeDirSize, "content_length", contentLength)
 		app.clientError(w, http.StatusRequestEntityTooLarge)
 		return
 	}
@@ -108,14 +113,14 @@ func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
 	// We need the message ID from the envelope
 	message, err := messagePkg.MessageFromHeadersData(r.Header)
 	if err != nil {
-		app.errorLog.Printf("failed to parse request headers %s", err)
-		app.serverError(w, err)
+		app.requestLog(r).Warn("Could not parse message headers", "error", err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	_, messageExists, err := storage.MessageExists(userHomeDirPath, message.ID)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if messageExists {
@@ -125,8 +130,8 @@ func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
 
 	messagePath, err := storage.CreateMessageDir(userHomeDirPath, message.ID)
 	if err != nil {
-		app.errorLog.Printf("failed to create message path: %s", err)
-		app.serverError(w, err)
+		app.requestLog(r).Error("Could not create message path", "error", err)
+		app.serverError(w, r, err)
 		return
 	}
 	envelopePath := storage.MessageEnvelopePath(userHomeDirPath, message.ID)
@@ -134,14 +139,14 @@ func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
 
 	payloadFile, err := os.Create(payloadPath)
 	if err != nil {
-		app.errorLog.Printf("failed to create body file: %s", err)
+		app.requestLog(r).Error("Could not create body file", "error", err)
 		// DRY-UP!
-		err := storage.DeleteMessageDir(userHomeDirPath, message.ID)
-		if err != nil {
-			app.errorLog.Printf("FATAL: failed to remove message path [%s]:", messagePath, err)
+		removeErr := storage.DeleteMessageDir(userHomeDirPath, message.ID)
+		if removeErr != nil {
+			app.requestLog(r).Error("Could not remove message path", "path", messagePath, "error", removeErr)
 		}
 
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -151,13 +156,13 @@ func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
 	_, err = io.CopyBuffer(payloadFile, limitedReader, buffer)
 
 	if err != nil {
-		app.errorLog.Printf("failed to save request body: %s", err)
+		app.requestLog(r).Error("Could not save message body", "error", err)
 		// DRY-UP!
-		err := storage.DeleteMessageDir(userHomeDirPath, message.ID)
-		if err != nil {
-			app.errorLog.Printf("FATAL: failed to remove message path [%s]:", messagePath, err)
+		removeErr := storage.DeleteMessageDir(userHomeDirPath, message.ID)
+		if removeErr != nil {
+			app.requestLog(r).Error("Could not remove message path", "path", messagePath, "error", removeErr)
 		}
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	defer r.Body.Close()
@@ -165,31 +170,33 @@ func (app *application) storeMessage(w http.ResponseWriter, r *http.Request) {
 	envelopeDumpStr := []byte(strings.Join(message.EnvelopeHeadersList, "\n"))
 	err = ioutil.WriteFile(envelopePath, append(envelopeDumpStr, '\n'), 0644)
 	if err != nil {
-		app.errorLog.Printf("failed to save request envelope: %s", err)
+		app.requestLog(r).Error("Could not save message envelope", "error", err)
 		// DRY-UP!
-		err := storage.DeleteMessageDir(userHomeDirPath, message.ID)
-		if err != nil {
-			app.errorLog.Printf("FATAL: failed to remove message path [%s]:", messagePath, err)
+		removeErr := storage.DeleteMessageDir(userHomeDirPath, message.ID)
+		if removeErr != nil {
+			app.requestLog(r).Error("Could not remove message path", "path", messagePath, "error", removeErr)
 		}
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	for _, reader := range message.Readers {
 		err := storage.WriteMessageIndex(userHomeDirPath, reader.Link, reader.User.PublicSigningKeyFingerprint, message.StreamID, message.ID)
 		if err != nil {
-			app.errorLog.Printf("FATAL: failed to write message index: %s", err)
+			app.requestLog(r).Error("Could not write message index", "error", err)
 			// DRY-UP!
-			err := storage.DeleteMessageDir(userHomeDirPath, message.ID)
-			if err != nil {
-				app.errorLog.Printf("FATAL: failed to remove message path [%s]:", messagePath, err)
+			removeErr := storage.DeleteMessageDir(userHomeDirPath, message.ID)
+			if removeErr != nil {
+				app.requestLog(r).Error("Could not remove message path", "path", messagePath, "error", removeErr)
 			}
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
-		app.infoLog.Printf("added message reader %s for message %s", reader.Link, message.ID)
+		app.requestLog(r).Debug("Added message reader", "reader", reader.Link, "message_id", message.ID)
 	}
-	app.infoLog.Printf("message stored in %s", messagePath)
+	app.requestLog(r).Info("Message stored", "message_id", message.ID, "path", messagePath)
+	app.metrics.messagesStored.Inc(domain)
+	app.audit(r, userHomeDirPath, audit.ACTION_MESSAGE_STORED, message.ID)
 }
 
 func (app *application) deleteMessage(w http.ResponseWriter, r *http.Request) {
@@ -206,7 +213,7 @@ func (app *application) deleteMessage(w http.ResponseWriter, r *http.Request) {
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -223,7 +230,7 @@ func (app *application) deleteMessage(w http.ResponseWriter, r *http.Request) {
 
 	messagePath, messageExists, err := storage.MessageExists(userHomeDirPath, messageID)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !messageExists {
@@ -232,15 +239,17 @@ func (app *application) deleteMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	err = storage.DeleteMessageDir(userHomeDirPath, messageID)
 	if err != nil {
-		app.errorLog.Printf("FATAL: failed to remove message path [%s]:", messagePath, err)
-		app.serverError(w, err)
+		app.requestLog(r).Error("Could not remove message path", "path", messagePath, "error", err)
+		app.serverError(w, r, err)
 		return
 	}
-	app.infoLog.Printf("Removed message path [%s]:", messagePath)
+	app.requestLog(r).Info("Message deleted", "message_id", messageID, "path", messagePath)
+	app.metrics.messagesDeleted.Inc(domain, MESSAGE_DELETED_BY_OWNER)
+	app.audit(r, userHomeDirPath, audit.ACTION_MESSAGE_DELETED, messageID)
 
 	err = storage.RemoveMessageFromIndex(userHomeDirPath, messageID)
 	if err != nil {
-		app.errorLog.Printf("failed to remove message from index [%s]:", messagePath, err)
+		app.requestLog(r).Warn("Could not remove message from index", "path", messagePath, "error", err)
 		// Not fatal as the cleanup will remove stale entries
 	}
 	w.WriteHeader(http.StatusOK)
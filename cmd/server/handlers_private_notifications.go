@@ -1,11 +1,20 @@
 package main
 
 import (
+	"email.mercata.com/internal/consts"
 	"email.mercata.com/internal/email/notification"
+	"email.mercata.com/internal/utils"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 )
 
+const NOTIFICATIONS_STREAM_EVENT = "notification"
+const NOTIFICATIONS_STREAM_HEARTBEAT = 30 * time.Second
+
 func (app *application) getNotifications(w http.ResponseWriter, r *http.Request) {
 	domain, ok := r.Context().Value(domainContextKey).(string)
 	if !ok {
@@ -20,7 +29,7 @@ func (app *application) getNotifications(w http.ResponseWriter, r *http.Request)
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -28,17 +37,176 @@ func (app *application) getNotifications(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	lines, err := notification.ListAll(userHomeDirPath)
+	// ?since=RFC3339 lists only later notifications, ?unacknowledged=yes only those not handled
+	var filter notification.Filter
+	if since := r.URL.Query().Get("since"); since != "" {
+		sinceTime, err := utils.ParseRFC3339Time(since)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		filter.Since = *sinceTime
+	}
+	filter.Unacknowledged = strings.ToLower(r.URL.Query().Get("unacknowledged")) == "yes"
+
+	notifications, err := notification.List(userHomeDirPath, filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	for _, n := range notifications {
+		_, err = fmt.Fprintln(w, n.ToLine())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
+
+func (app *application) acknowledgeNotifications(w http.ResponseWriter, r *http.Request) {
+	app.changeNotifications(w, r, notification.Acknowledge)
+}
+
+func (app *application) deleteNotifications(w http.ResponseWriter, r *http.Request) {
+	app.changeNotifications(w, r, notification.Delete)
+}
+
+// The body lists the notification IDs one per line, or "all".
+// The number of changed notifications is returned.
+func (app *application) changeNotifications(w http.ResponseWriter, r *http.Request, change func(homeDirPath string, ids []string) (int, error)) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, consts.MAX_PROFILE_SIZE))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	ids := strings.Fields(string(body))
+	if len(ids) == 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	changed, err := change(userHomeDirPath, ids)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
-	for _, line := range lines {
-		_, err = fmt.Fprintln(w, line)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, changed)
+}
+
+// Server-Sent Events stream of notifications as they are stored. Clients
+// reconnecting with Last-Event-ID first get what they missed since.
+func (app *application) streamNotifications(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.serverError(w, r, errors.New("streaming unsupported"))
+		return
+	}
+
+	// Subscribing before the replay, nothing stored in between is lost
+	events, unsubscribe := app.notificationHub.Subscribe(accountKey(domain, user))
+	defer unsubscribe()
+
+	var missed []*notification.Notification
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		missed, err = notification.Since(userHomeDirPath, lastEventID)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	sent := make(map[string]bool)
+	for _, n := range missed {
+		writeNotificationEvent(w, n)
+		sent[n.ID] = true
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(NOTIFICATIONS_STREAM_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
 			return
+
+		// Clients resume from the last event on another server
+		case <-app.stopping.Done():
+			return
+
+		case n, open := <-events:
+			if !open {
+				// Fell behind, the client resumes from the last event
+				return
+			}
+			if sent[n.ID] {
+				continue
+			}
+			writeNotificationEvent(w, n)
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
 		}
 	}
 }
+
+func writeNotificationEvent(w io.Writer, n *notification.Notification) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", n.ID, NOTIFICATIONS_STREAM_EVENT, n.ToLine())
+}
+
+func accountKey(domain, user string) string {
+	return domain + "/" + user
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"email.mercata.com/internal/consts"
 	addressPkg "email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/audit"
 	"email.mercata.com/internal/email/profile"
 	"email.mercata.com/internal/utils"
 	"io/ioutil"
@@ -23,7 +24,7 @@ func (app *application) queryAccessToProfile(w http.ResponseWriter, r *http.Requ
 
 	_, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -33,7 +34,7 @@ func (app *application) queryAccessToProfile(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *application) setProfile(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, consts.MAX_PROFILE_SIZE)
+	r.Body = http.MaxBytesReader(w, r.Body, app.config().limits.maxProfileSize)
 
 	domain, ok := r.Context().Value(domainContextKey).(string)
 	if !ok {
@@ -48,7 +49,7 @@ func (app *application) setProfile(w http.ResponseWriter, r *http.Request) {
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -58,7 +59,7 @@ func (app *application) setProfile(w http.ResponseWriter, r *http.Request) {
 
 	profData, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -76,9 +77,11 @@ func (app *application) setProfile(w http.ResponseWriter, r *http.Request) {
 
 	err = profile.SetLocalProfile(userHomeDirPath, &profData)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
+	app.logProfileKeys(&p)
+	app.audit(r, userHomeDirPath, audit.ACTION_PROFILE_CHANGED, p.User.PublicSigningKeyFingerprint)
 }
 
 func (app *application) setProfileImage(w http.ResponseWriter, r *http.Request) {
@@ -97,7 +100,7 @@ func (app *application) setProfileImage(w http.ResponseWriter, r *http.Request)
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -108,25 +111,26 @@ func (app *application) setProfileImage(w http.ResponseWriter, r *http.Request)
 	// TODO: Limit request size!
 	profImageData, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	filetype, err := utils.DetermineFileTypeOfData(&profImageData)
 	if err != nil {
-		app.errorLog.Printf("could not determine image mimetype: %s", err)
+		app.requestLog(r).Warn("Could not determine image mimetype", "error", err)
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 	if !profile.ImageMimeTypeIsPermitted(filetype) {
-		app.errorLog.Printf("unpermitted image mimetype: %s", filetype)
+		app.requestLog(r).Info("Unpermitted image mimetype", "mimetype", filetype)
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
 	err = profile.SetLocalProfileImage(userHomeDirPath, &profImageData)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
+	app.audit(r, userHomeDirPath, audit.ACTION_PROFILE_IMAGE_CHANGED, filetype)
 }
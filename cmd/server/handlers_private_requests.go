@@ -0,0 +1,215 @@
+package main
+
+import (
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/audit"
+	"email.mercata.com/internal/email/links"
+	"email.mercata.com/internal/email/requests"
+	"email.mercata.com/internal/email/storage"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func (app *application) listContactRequests(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	pending, err := requests.ListAll(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, request := range pending {
+		_, err = fmt.Fprintln(w, request.ToLine())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
+
+// Accepting a request stores the link, with the contact address encrypted
+// by the owner as the body, as when storing any link.
+func (app *application) acceptContactRequest(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	link := strings.ToLower(params.ByName("link"))
+	if !links.Valid(link) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	requestExists, err := requests.Exists(userHomeDirPath, link)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !requestExists {
+		app.notFound(w)
+		return
+	}
+
+	contact, err := io.ReadAll(io.LimitReader(r.Body, consts.MAX_CONTACT_REQUEST_SIZE+1))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if len(contact) > consts.MAX_CONTACT_REQUEST_SIZE {
+		app.clientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(contact) == 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = storage.StoreLink(userHomeDirPath, link, contact)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, userHomeDirPath, audit.ACTION_LINK_STORED, link)
+	err = requests.Delete(userHomeDirPath, link)
+	if err != nil && !errors.Is(err, requests.ErrorNoRequest) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Rejecting drops the requests of the link and, with ?block=yes, blocks
+// both the link and the signing keys that made them. With ?key= only the
+// request of that key is dropped, and only the key blocked, as when it is
+// not the key of the address it claims.
+func (app *application) rejectContactRequest(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	link := strings.ToLower(params.ByName("link"))
+	requesterKey := strings.ToLower(r.URL.Query().Get("key"))
+	if !links.Valid(link) || (requesterKey != "" && !requests.ValidRequesterKey(requesterKey)) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var pending []*requests.Request
+	if requesterKey != "" {
+		request, err := requests.Load(userHomeDirPath, link, requesterKey)
+		if err != nil {
+			if errors.Is(err, requests.ErrorNoRequest) {
+				app.notFound(w)
+				return
+			}
+			app.serverError(w, r, err)
+			return
+		}
+		pending = append(pending, request)
+	} else {
+		pending, err = requests.ListLink(userHomeDirPath, link)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if len(pending) == 0 {
+			app.notFound(w)
+			return
+		}
+	}
+
+	if strings.ToLower(r.URL.Query().Get("block")) == "yes" {
+		if requesterKey == "" {
+			err = storage.AddBlock(userHomeDirPath, storage.BLOCK_KIND_LINK, link)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			app.audit(r, userHomeDirPath, audit.ACTION_BLOCK_ADDED, storage.BLOCK_KIND_LINK+storage.BLOCK_KIND_SEPARATOR+link)
+		}
+		for _, request := range pending {
+			if request.RequesterKey == "" {
+				continue
+			}
+			err = storage.AddBlock(userHomeDirPath, storage.BLOCK_KIND_KEY, request.RequesterKey)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			app.audit(r, userHomeDirPath, audit.ACTION_BLOCK_ADDED, storage.BLOCK_KIND_KEY+storage.BLOCK_KIND_SEPARATOR+request.RequesterKey)
+		}
+	}
+
+	if requesterKey != "" {
+		err = requests.DeleteKey(userHomeDirPath, link, requesterKey)
+	} else {
+		err = requests.Delete(userHomeDirPath, link)
+	}
+	if err != nil && !errors.Is(err, requests.ErrorNoRequest) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/audit"
+	"email.mercata.com/internal/webhook"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Longest webhook URL accepted
+const MAX_WEBHOOK_URL_LENGTH = 2048
+
+func (app *application) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	hooks, err := webhook.List(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, hook := range hooks {
+		_, err = fmt.Fprintln(w, hook.ToPublicLine())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
+
+// The body holds the URL, the response the ID and secret of the new hook.
+// The secret is not shown again.
+func (app *application) storeWebhook(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MAX_WEBHOOK_URL_LENGTH+1))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if len(body) > MAX_WEBHOOK_URL_LENGTH {
+		app.clientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	hook, err := webhook.Add(userHomeDirPath, strings.TrimSpace(string(body)))
+	if err != nil {
+		switch {
+		case errors.Is(err, webhook.ErrorBadWebhookURL):
+			app.clientError(w, http.StatusBadRequest)
+		case errors.Is(err, webhook.ErrorTooManyWebhooks):
+			app.clientError(w, http.StatusConflict)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.audit(r, userHomeDirPath, audit.ACTION_WEBHOOK_CREATED, hook.ID)
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, err = fmt.Fprintln(w, hook.ID+webhook.WEBHOOKS_COLUMN_SEPARATOR+hook.Secret)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id := params.ByName("id")
+	err = webhook.Remove(userHomeDirPath, id)
+	if err != nil {
+		if errors.Is(err, webhook.ErrorNoWebhook) {
+			app.notFound(w)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, userHomeDirPath, audit.ACTION_WEBHOOK_DELETED, id)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Deliveries which failed all attempts, most recent last
+func (app *application) listWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	lines, err := webhook.DeadLetters(userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	for _, line := range lines {
+		_, err = fmt.Fprintln(w, line)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"email.mercata.com/internal/email/account"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
@@ -8,7 +9,9 @@ import (
 )
 
 func (app *application) getWellKnownFile(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintln(w, app.config.mailAgentHostname)
+	for _, hostname := range app.mailAgentHostnames(r.Host) {
+		fmt.Fprintln(w, hostname)
+	}
 }
 
 func (app *application) checkDomainDelegation(w http.ResponseWriter, r *http.Request) {
@@ -16,10 +19,11 @@ func (app *application) checkDomainDelegation(w http.ResponseWriter, r *http.Req
 	domain := strings.ToLower(params.ByName("domain"))
 	domainExists, err := app.domainHomePathExists(domain)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
-	if !domainExists {
+	// Configured domains are served before their first account
+	if !domainExists && app.domains().Get(domain) == nil {
 		app.notFound(w)
 		return
 	}
@@ -36,15 +40,24 @@ func (app *application) checkUserDelegation(w http.ResponseWriter, r *http.Reque
 	params := httprouter.ParamsFromContext(r.Context())
 	domain := strings.ToLower(params.ByName("domain"))
 	localPart := strings.ToLower(params.ByName("user"))
-	_, homeDirExists, err := app.userHomePath(domain, localPart)
+	userHomeDir, homeDirExists, err := app.userHomePath(domain, localPart)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
 		app.notFound(w)
 		return
 	}
+	suspension, err := account.Suspended(userHomeDir)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if suspension != nil {
+		app.accountSuspended(w, suspension)
+		return
+	}
 
 	if r.Method == http.MethodHead {
 		w.WriteHeader(http.StatusOK)
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"email.mercata.com/internal/email/profile"
+	"email.mercata.com/internal/transparency"
+	"email.mercata.com/internal/utils"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Signed head of the key transparency log, in the header format also
+// served along profiles.
+func (app *application) getKeyLogHead(w http.ResponseWriter, r *http.Request) {
+	treeHead := app.keyLog.TreeHead()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(transparency.KEY_LOG_TREE_HEAD_HEADER, treeHead.ToHeader())
+	w.Write([]byte(treeHead.ToHeader() + "\n"))
+}
+
+// Consistency proof between two tree sizes, one base64 hash per line.
+func (app *application) getKeyLogConsistency(w http.ResponseWriter, r *http.Request) {
+	firstSize, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	secondSize, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	proof, err := app.keyLog.ConsistencyProof(firstSize, secondSize)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(strings.ReplaceAll(transparency.EncodeHashes(proof), transparency.PROOF_PATH_SEPARATOR, "\n") + "\n"))
+}
+
+// logProfileKeys appends the profile keys to the key log when they changed
+func (app *application) logProfileKeys(p *profile.Profile) {
+	if !profile.IsFunctionalProfile(p) {
+		return
+	}
+	_, err := app.keyLog.Observe(p.Address, p.PublicSigningKeyBase64, p.PublicEncryptionKeyBase64)
+	if err != nil {
+		app.log.Error("Could not log keys", "account", p.Address, "error", err)
+	}
+}
+
+// logHostedKeys logs the keys of all hosted accounts, for those predating
+// the log or whose profile was changed on disk. Past startup, keys are
+// logged as profiles are written.
+func (app *application) logHostedKeys() {
+	dataDirPath := app.config().dataDirPath
+	domainNames, err := utils.ListDirectories(dataDirPath)
+	if err != nil {
+		app.log.Error("Could not list domains for the key log", "error", err)
+		return
+	}
+	for _, domain := range domainNames {
+		if strings.HasPrefix(domain, ".") {
+			continue
+		}
+		userNames, err := utils.ListDirectories(filepath.Join(dataDirPath, domain))
+		if err != nil {
+			app.log.Error("Could not list accounts for the key log", "domain", domain, "error", err)
+			continue
+		}
+		for _, user := range userNames {
+			p, err := profile.GetLocalProfile(filepath.Join(dataDirPath, domain, user), domain, user)
+			if err != nil {
+				continue
+			}
+			app.logProfileKeys(p)
+		}
+	}
+}
+
+// setKeyLogHeaders attaches the signed tree head and the inclusion proof
+// of the current profile keys of the account.
+func (app *application) setKeyLogHeaders(w http.ResponseWriter, userHomeDir, domain, user string) {
+	p, err := profile.GetLocalProfile(userHomeDir, domain, user)
+	if err != nil {
+		return
+	}
+
+	treeHead, inclusion := app.keyLog.Snapshot(p.Address)
+	w.Header().Set(transparency.KEY_LOG_TREE_HEAD_HEADER, treeHead.ToHeader())
+	if inclusion != nil {
+		w.Header().Set(transparency.KEY_LOG_INCLUSION_HEADER, inclusion.ToHeader())
+	}
+}
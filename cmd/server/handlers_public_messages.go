@@ -29,19 +29,19 @@ func (app *application) listBroadcastMessages(w http.ResponseWriter, r *http.Req
 	}
 
 	stream := strings.ToLower(params.ByName("stream"))
-	userHomeDirPath := filepath.Join(app.config.dataDirPath, domain, user)
+	userHomeDirPath := filepath.Join(app.config().dataDirPath, domain, user)
 
 	w.Header().Set("Content-Type", "text/plain")
 
 	messageIDs, err := storage.FilterMessagesIndex(userHomeDirPath, "", "", stream)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	for _, messageID := range messageIDs {
 		_, messageExists, err := storage.MessageExists(userHomeDirPath, messageID)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 		if !messageExists {
@@ -49,7 +49,7 @@ func (app *application) listBroadcastMessages(w http.ResponseWriter, r *http.Req
 		}
 		_, err = fmt.Fprintln(w, messageID)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 	}
@@ -68,7 +68,7 @@ func (app *application) getBroadcastMessage(w http.ResponseWriter, r *http.Reque
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -78,7 +78,7 @@ func (app *application) getBroadcastMessage(w http.ResponseWriter, r *http.Reque
 
 	_, messageExists, err := storage.MessageExists(userHomeDirPath, messageID)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !messageExists {
@@ -88,7 +88,7 @@ func (app *application) getBroadcastMessage(w http.ResponseWriter, r *http.Reque
 
 	envelopeFileContents, err := ioutil.ReadFile(storage.MessageEnvelopePath(userHomeDirPath, messageID))
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -102,19 +102,19 @@ func (app *application) getBroadcastMessage(w http.ResponseWriter, r *http.Reque
 
 	err = writeEnvelopeAsResponseHeaders(&envelopeFileContents, w)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	payloadFile, err := os.Open(storage.MessagePayloadPath(userHomeDirPath, messageID))
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	defer payloadFile.Close()
 	payloadFileStat, err := payloadFile.Stat()
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -150,20 +150,30 @@ func (app *application) listLinkMessages(w http.ResponseWriter, r *http.Request)
 	params := httprouter.ParamsFromContext(r.Context())
 	stream := strings.ToLower(params.ByName("stream"))
 
-	userHomeDirPath := filepath.Join(app.config.dataDirPath, domain, user)
+	userHomeDirPath := filepath.Join(app.config().dataDirPath, domain, user)
 
 	w.Header().Set("Content-Type", "text/plain")
 
+	// Blocked readers see an empty list
+	blocked, err := app.callerIsBlocked(r, userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if blocked {
+		return
+	}
+
 	messageIDs, err := storage.FilterMessagesIndex(userHomeDirPath, link, publicKeyFingerprint, stream)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	for _, messageID := range messageIDs {
 		_, err = fmt.Fprintln(w, messageID)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 	}
@@ -196,7 +206,7 @@ func (app *application) getLinkMessage(w http.ResponseWriter, r *http.Request) {
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -204,25 +214,31 @@ func (app *application) getLinkMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	blocked, err := app.callerIsBlocked(r, userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	_, messageExists, err := storage.MessageExists(userHomeDirPath, messageID)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
-	if !messageExists {
+	// Blocked readers are told there is no such message
+	if !messageExists || blocked {
 		app.notFound(w)
 		return
 	}
 
 	envelopeFileContents, err := ioutil.ReadFile(storage.MessageEnvelopePath(userHomeDirPath, messageID))
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	authorized, err := message.LinkFingerprintExistsInAccessList(link, publicKeyFingerprint, &envelopeFileContents)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -233,19 +249,19 @@ func (app *application) getLinkMessage(w http.ResponseWriter, r *http.Request) {
 
 	err = writeEnvelopeAsResponseHeaders(&envelopeFileContents, w)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	payloadFile, err := os.Open(storage.MessagePayloadPath(userHomeDirPath, messageID))
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	defer payloadFile.Close()
 	payloadFileStat, err := payloadFile.Stat()
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -258,7 +274,7 @@ func (app *application) getLinkMessage(w http.ResponseWriter, r *http.Request) {
 	if userpkg.SelfLink(user, domain) != link {
 		err = storage.LogMessageAccess(userHomeDirPath, messageID, link)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 	}
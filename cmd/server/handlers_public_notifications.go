@@ -6,8 +6,12 @@ import (
 	"email.mercata.com/internal/email/notification"
 	profilePkg "email.mercata.com/internal/email/profile"
 	"email.mercata.com/internal/email/storage"
+	"email.mercata.com/internal/stamp"
 	"email.mercata.com/internal/utils"
+	"email.mercata.com/internal/webhook"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -15,7 +19,8 @@ import (
 // between local and remote user. If a notification caller is also a local user, the
 // system SHOULD NOT be aware of it, otherwise the caller's email address is revealed.
 func (app *application) writeNotification(w http.ResponseWriter, r *http.Request) {
-	notifierAttrs := utils.ParseHeadersAttributes(r.Header.Get(consts.NOTIFICATION_ORIGIN_HEADER))
+	notifierHeader := r.Header.Get(consts.NOTIFICATION_ORIGIN_HEADER)
+	notifierAttrs := utils.ParseHeadersAttributes(notifierHeader)
 
 	algorithm, ok := notifierAttrs["algorithm"]
 	if !ok {
@@ -51,7 +56,7 @@ func (app *application) writeNotification(w http.ResponseWriter, r *http.Request
 
 	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -61,13 +66,13 @@ func (app *application) writeNotification(w http.ResponseWriter, r *http.Request
 
 	profile, err := profilePkg.GetLocalProfile(userHomeDirPath, domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	linkExists, err := storage.UserHasLink(userHomeDirPath, link)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -92,16 +97,65 @@ func (app *application) writeNotification(w http.ResponseWriter, r *http.Request
 
 	notifierKeyFingerprint, ok := r.Context().Value(signingFingerprintContextKey).(string)
 	if !ok {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
-	err = notification.Store(userHomeDirPath, link, string(originEncryptedEmailAddress), notifierKeyFingerprint, profile.User.PublicEncryptionKeyFingerprint)
+	// Strangers pay with work, so that fresh key pairs do not make spam free
+	if !linkExists {
+		requiredBits := app.notificationStampBits(profile)
+		if requiredBits > 0 {
+			s, err := stamp.FromHeader(r.Header.Get(consts.NOTIFICATION_STAMP_HEADER))
+			if err == nil {
+				err = s.Verify(link, notifierKeyFingerprint, requiredBits)
+			}
+			if err != nil {
+				w.Header().Set(consts.NOTIFICATION_STAMP_BITS_HEADER, strconv.Itoa(requiredBits))
+				app.clientError(w, http.StatusPreconditionRequired)
+				return
+			}
+			err = s.Spend(userHomeDirPath, link, notifierKeyFingerprint)
+			if err != nil {
+				if errors.Is(err, stamp.ErrorStampSpent) {
+					w.Header().Set(consts.NOTIFICATION_STAMP_BITS_HEADER, strconv.Itoa(requiredBits))
+					app.clientError(w, http.StatusPreconditionRequired)
+					return
+				}
+				app.serverError(w, r, err)
+				return
+			}
+		}
+	}
+
+	blocked, err := app.callerIsBlocked(r, userHomeDirPath)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
+	// Blocked notifiers are answered as usual, only nothing gets stored
+	if !blocked {
+		n, err := notification.Store(userHomeDirPath, link, string(originEncryptedEmailAddress), notifierKeyFingerprint, profile.User.PublicEncryptionKeyFingerprint)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.metrics.notificationsWritten.Inc(domain)
+		app.notificationHub.Publish(accountKey(domain, user), n)
+		app.webhooks.Deliver(userHomeDirPath, &webhook.Payload{
+			ID:          n.ID,
+			Link:        n.Link,
+			Sequence:    n.Sequence,
+			Received:    n.Received,
+			Notifier:    n.Notifier,
+			NotifierKey: n.NotifierKey,
+			ReaderKey:   n.ReaderKey,
+		})
+		if app.fetcher != nil {
+			app.fetcher.Trigger(userHomeDirPath, link)
+		}
+	}
+
 	if profile.IsAway {
 		// The StatusAccepted should indicate the Away status to clients if they have not checked previously.
 		w.WriteHeader(http.StatusAccepted)
@@ -110,3 +164,15 @@ func (app *application) writeNotification(w http.ResponseWriter, r *http.Request
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// The server demands the least work, accounts may ask for more
+func (app *application) notificationStampBits(profile *profilePkg.Profile) int {
+	bits := app.config().notificationStampBits
+	if profile.NotificationStampBits > bits {
+		bits = profile.NotificationStampBits
+	}
+	if bits > stamp.MAX_STAMP_BITS {
+		bits = stamp.MAX_STAMP_BITS
+	}
+	return bits
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/address"
 	"email.mercata.com/internal/email/profile"
 	"email.mercata.com/internal/utils"
 	"errors"
@@ -31,7 +31,7 @@ func (app *application) getProfile(w http.ResponseWriter, r *http.Request) {
 
 	userHomeDir, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -39,6 +39,7 @@ func (app *application) getProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.setKeyLogHeaders(w, userHomeDir, domain, user)
 	app.serveProfileFile(w, r, profile.GetLocalProfileDataPath(userHomeDir), "text/plain; charset=utf-8")
 }
 
@@ -49,7 +50,7 @@ func (app *application) getProfileImage(w http.ResponseWriter, r *http.Request)
 
 	userHomeDir, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !homeDirExists {
@@ -60,7 +61,7 @@ func (app *application) getProfileImage(w http.ResponseWriter, r *http.Request)
 	imagePath := profile.GetLocalProfileImagePath(userHomeDir)
 	imageExists, err := utils.FilePathExists(imagePath)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !imageExists {
@@ -70,11 +71,11 @@ func (app *application) getProfileImage(w http.ResponseWriter, r *http.Request)
 
 	mimeType, imateTypePermitted, err := profile.ImagePathFileTypeIsPermitted(imagePath)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if !imateTypePermitted {
-		app.infoLog.Printf("Not serving unpermitted profile image type '%s' for %s@%s", *mimeType, user, domain)
+		app.requestLog(r).Info("Not serving unpermitted profile image type", "mimetype", *mimeType, "image_of", address.JoinAddress(domain, user))
 		app.notFound(w)
 		return
 	}
@@ -89,7 +90,7 @@ func (app *application) serveProfileFile(w http.ResponseWriter, r *http.Request,
 			app.notFound(w)
 			return
 		}
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -100,18 +101,19 @@ func (app *application) serveProfileFile(w http.ResponseWriter, r *http.Request,
 
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", contentTypeOverride)
 	w.Header().Set("Last-Modified", fstat.ModTime().UTC().Format(http.TimeFormat))
-	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", consts.MAX_CACHE_DURATION))
-	w.Header().Set("Expires", time.Now().Add(consts.MAX_CACHE_DURATION*time.Second).UTC().Format(http.TimeFormat))
+	cacheDuration := app.config().limits.cacheDuration
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cacheDuration.Seconds())))
+	w.Header().Set("Expires", time.Now().Add(cacheDuration).UTC().Format(http.TimeFormat))
 
 	_, err = w.Write(data)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 }
@@ -2,29 +2,37 @@ package main
 
 import (
 	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/audit"
 	"email.mercata.com/internal/email/profile"
 	"email.mercata.com/internal/nonce"
+	"email.mercata.com/internal/provisioning"
 	"email.mercata.com/internal/utils"
+	"errors"
 	"github.com/julienschmidt/httprouter"
 	"io/ioutil"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-// TODO Limit to number of request per IP to one per hour?
+// Requests per IP are limited by the provisioning rate limiter, one per hour by default
 
-// create directory and store minimal profile with public encryption and public signing key given in request
+// create directory and store minimal profile with public encryption and public signing key given in request,
+// if the provisioning policy of the domain admits the local part
 
 func (app *application) provisionUser(w http.ResponseWriter, r *http.Request) {
 	n, err := nonce.FromHeader(r.Header.Get(consts.AUTHORIZATION_HEADER_NONCE))
 	if err != nil {
-		app.errorLog.Printf("Could not extract nonce: %s", err)
+		app.requestLog(r).Info("Could not extract nonce", "error", err)
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 	err = nonce.VerifySignature(n)
 	if err != nil {
-		app.errorLog.Printf("Could not verify nonce: %s", err)
+		app.requestLog(r).Info("Could not verify nonce", "error", err)
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
@@ -38,14 +46,21 @@ func (app *application) provisionUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !utils.ListContains(app.config.provisioning.domains, domain) {
+	// Domains are open to provisioning by the command line, or by a policy of their own
+	domainPath := filepath.Join(app.config().dataDirPath, domain)
+	policy, open, err := app.domainPolicy(domain)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !open {
 		app.forbidden(w)
 		return
 	}
 
 	userHomeDir, homeDirExists, err := app.userHomePath(domain, user)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	if homeDirExists {
@@ -53,11 +68,21 @@ func (app *application) provisionUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Provision account now
+	// Names of deleted accounts are not handed out again for a while
+	buriedUntil, err := account.BuriedUntil(domainPath, user, time.Duration(app.config().provisioning.tombstoneDays)*24*time.Hour)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !buriedUntil.IsZero() {
+		app.requestLog(r).Info("Refused provisioning of deleted account", "address", address.JoinAddress(domain, user), "deleted_until", utils.ToRFC3339String(buriedUntil))
+		app.clientError(w, http.StatusConflict)
+		return
+	}
 
 	profData, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -74,11 +99,41 @@ func (app *application) provisionUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The policy of the domain goes last, as it may use up an invite
+	redeemedInvite, err := policy.Admit(domainPath, user, strings.TrimSpace(r.Header.Get(consts.PROVISIONING_INVITE_HEADER)))
+	if err != nil {
+		switch {
+		case errors.Is(err, provisioning.ErrorBadLocalPart):
+			app.clientError(w, http.StatusBadRequest)
+		case errors.Is(err, provisioning.ErrorInviteRequired), errors.Is(err, provisioning.ErrorBadInvite):
+			app.clientError(w, http.StatusUnauthorized)
+		case errors.Is(err, provisioning.ErrorProvisioningClosed), errors.Is(err, provisioning.ErrorNameForbidden), errors.Is(err, provisioning.ErrorDomainFull):
+			app.requestLog(r).Info("Refused provisioning", "address", address.JoinAddress(domain, user), "error", err)
+			app.forbidden(w)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Provision account now
+
 	err = profile.SetLocalProfile(userHomeDir, &profData)
 	if err != nil {
-		app.serverError(w, err)
+		// The invite was used for nothing
+		if redeemedInvite != "" {
+			releaseErr := provisioning.ReleaseInvite(domainPath, redeemedInvite)
+			if releaseErr != nil {
+				app.requestLog(r).Error("Could not release invite", "domain", domain, "error", releaseErr)
+			}
+		}
+		app.serverError(w, r, err)
 		return
 	}
+	p.User.Address = address.JoinAddress(domain, user)
+	app.logProfileKeys(&p)
+	requestInfoFromContext(r).fingerprint = n.SigningKeyFingerprint
+	app.audit(r, userHomeDir, audit.ACTION_PROVISIONED, p.User.Address)
 
 	w.WriteHeader(http.StatusOK)
 }
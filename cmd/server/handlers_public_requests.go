@@ -0,0 +1,90 @@
+package main
+
+import (
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/requests"
+	"email.mercata.com/internal/email/storage"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// A stranger asks to become a contact. Unlike notifications, this is
+// accepted also by closed profiles, as the link is not known yet.
+func (app *application) writeContactRequest(w http.ResponseWriter, r *http.Request) {
+	domain, ok := r.Context().Value(domainContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	user, ok := r.Context().Value(userContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	link, ok := r.Context().Value(linkContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	requesterKeyFingerprint, ok := r.Context().Value(signingFingerprintContextKey).(string)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userHomeDirPath, homeDirExists, err := app.userHomePath(domain, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !homeDirExists {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, consts.MAX_CONTACT_REQUEST_SIZE+1))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if len(body) > consts.MAX_CONTACT_REQUEST_SIZE {
+		app.clientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	introduction := strings.TrimSpace(string(body))
+	if introduction == "" || strings.ContainsAny(introduction, "\r\n") {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Blocked requesters are answered as usual, only nothing gets stored
+	blocked, err := app.callerIsBlocked(r, userHomeDirPath)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if blocked {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	linkExists, err := storage.UserHasLink(userHomeDirPath, link)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if linkExists {
+		app.clientError(w, http.StatusConflict)
+		return
+	}
+
+	// Asking again replaces the earlier request of the same key only
+	err = requests.Store(userHomeDirPath, link, requesterKeyFingerprint, introduction)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -2,20 +2,24 @@ package main
 
 import (
 	"bytes"
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/logging"
 	"email.mercata.com/internal/utils"
 	"errors"
 	"fmt"
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"math"
 	"net/http"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"time"
 )
 
-func (app *application) serverError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.errorLog.Output(2, trace)
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	app.requestLog(r).Log(2, logging.ERROR, err.Error(), "stack", string(debug.Stack()))
 
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
@@ -32,11 +36,32 @@ func (app *application) forbidden(w http.ResponseWriter) {
 	app.clientError(w, http.StatusForbidden)
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+// accountSuspended answers that the account is gone for now, with the time
+// of the suspension in a header and the reason in the body.
+func (app *application) accountSuspended(w http.ResponseWriter, suspension *account.Suspension) {
+	w.Header().Set(consts.ACCOUNT_SUSPENDED_HEADER, suspension.Since)
+	http.Error(w, suspension.Reason, http.StatusGone)
+}
+
+func (app *application) tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	app.clientError(w, http.StatusTooManyRequests)
+}
+
+func (app *application) serviceUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(SERVICE_UNAVAILABLE_RETRY_AFTER))
+	app.clientError(w, http.StatusServiceUnavailable)
+}
+
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -44,7 +69,7 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 
 	err := ts.ExecuteTemplate(buf, "base", data)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -86,11 +111,11 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 }
 
 func (app *application) domainHomePathExists(domain string) (bool, error) {
-	return utils.FilePathExists(filepath.Join(app.config.dataDirPath, domain))
+	return utils.FilePathExists(filepath.Join(app.config().dataDirPath, domain))
 }
 
 func (app *application) userHomePath(domain, user string) (string, bool, error) {
-	homePath := filepath.Join(app.config.dataDirPath, domain, user)
+	homePath := filepath.Join(app.config().dataDirPath, domain, user)
 	exists, err := utils.FilePathExists(homePath)
 	return homePath, exists, err
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/email/audit"
+	"email.mercata.com/internal/logging"
+	"email.mercata.com/internal/utils"
+)
+
+/* Every request is logged with an ID, echoed in the X-Request-Id header.
+ * One given by a proxy in front is kept, if it looks like one. Entries
+ * logged for the request carry the ID, and once authenticated the account,
+ * link and admin too, as does the entry logging the request as answered.
+ ***************************************************************************/
+
+const REQUEST_ID_LENGTH = 16
+
+var REQUEST_ID_PATTERN = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// What is known of the request, filled in by the middleware as it goes
+type requestInfo struct {
+	id      string
+	account string
+	link    string
+	admin   string
+	// Signing key fingerprint of the authenticated client
+	fingerprint string
+}
+
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(consts.REQUEST_ID_HEADER); REQUEST_ID_PATTERN.MatchString(id) {
+		return id
+	}
+	id, err := crypto.GenerateRandomString(REQUEST_ID_LENGTH)
+	if err != nil {
+		return "-"
+	}
+	return id
+}
+
+func requestInfoFromContext(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+	return info
+}
+
+// requestLog returns the logger of the request, adding what is known of it
+// to the entries
+func (app *application) requestLog(r *http.Request) *logging.Logger {
+	info := requestInfoFromContext(r)
+	var fields []interface{}
+	for _, f := range []struct {
+		key   string
+		value string
+	}{
+		{"request_id", info.id},
+		{"account", info.account},
+		{"link", info.link},
+		{"admin", info.admin},
+	} {
+		if f.value != "" {
+			fields = append(fields, f.key, f.value)
+		}
+	}
+	return app.log.With(fields...)
+}
+
+// audit appends the action to the trail of the account. The action took
+// place already, so failing to record it is logged only.
+func (app *application) audit(r *http.Request, userHomeDirPath, action, subject string) {
+	info := requestInfoFromContext(r)
+	actor := info.fingerprint
+	if info.admin != "" {
+		actor = "admin " + info.admin
+	}
+	err := audit.Append(userHomeDirPath, &audit.Entry{
+		Time:      utils.TimestampNow(),
+		Action:    action,
+		Subject:   subject,
+		RequestID: info.id,
+		Remote:    clientIP(r),
+		Actor:     actor,
+	})
+	if err != nil {
+		app.requestLog(r).Error("Could not append to audit trail", "action", action, "error", err)
+	}
+}
@@ -1,32 +1,77 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"expvar"
 	"flag"
-	"fmt"
 	"github.com/go-playground/form/v4"
 	"github.com/julienschmidt/httprouter"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"email.mercata.com/internal/admin"
 	"email.mercata.com/internal/crypto"
-	"email.mercata.com/internal/utils"
+	"email.mercata.com/internal/domains"
+	"email.mercata.com/internal/email/inbox"
+	"email.mercata.com/internal/email/notification"
+	"email.mercata.com/internal/logging"
+	"email.mercata.com/internal/ratelimit"
+	"email.mercata.com/internal/transparency"
+	"email.mercata.com/internal/webhook"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port              int
+	listenAddress string
+	port          int
+	// Listener of its own for the metrics, if any
+	metricsAddress string
+	// Requests in flight on shutdown are given this long to finish
+	shutdownTimeout   time.Duration
 	dataDirPath       string
 	mailAgentHostname string
+	// Entries below the level are not logged
+	logLevel logging.Level
+
+	// Directory holding the settings of hosted domains, one directory each
+	domainsDirPath string
 
 	provisioning struct {
-		enabled bool
 		domains []string
+		// Mode of domains without a policy of their own
+		mode string
+		// Days the names of deleted accounts are held back from provisioning
+		tombstoneDays int
+	}
+
+	// Least proof of work demanded from notifiers without a link, accounts may demand more
+	notificationStampBits int
+
+	// Days accounts deleted by their owners are kept, during which they may cancel
+	deletionGraceDays int
+
+	// Public signing keys allowed to use the admin API
+	adminKeysPath string
+
+	// Let webhooks reach loopback and private networks, for local setups
+	webhooksAllowPrivate bool
+
+	// Fetch messages of delegated links into the inboxes of accounts
+	inboxFetcher struct {
+		enabled      bool
+		allowPrivate bool
 	}
 
 	tls struct {
@@ -34,49 +79,111 @@ type config struct {
 		certPath string
 		keyPath  string
 	}
+
+	// Certificates of the served hosts by ACME, see acme.go
+	acme struct {
+		enabled      bool
+		directoryURL string
+		email        string
+		httpAddress  string
+		caRootsPath  string
+	}
+
+	// Defaults of domains without limits of their own
+	limits struct {
+		maxProfileSize int64
+		maxMessageSize int64
+		maxAccountSize int64
+		// Acknowledged notifications are kept this long
+		notificationTime time.Duration
+		// Public profiles and images may be cached this long
+		cacheDuration time.Duration
+		// Less free space than this on the data directory is unhealthy
+		minFreeSpace int64
+	}
+
+	// Days messages and notifications are kept, 0 for as long as accounts want
+	retention struct {
+		messageDays      int
+		notificationDays int
+	}
+
+	rateLimits struct {
+		ip             ratelimit.Rate
+		link           ratelimit.Rate
+		fingerprint    ratelimit.Rate
+		provision      ratelimit.Rate
+		contactRequest ratelimit.Rate
+	}
 }
 
 type application struct {
 	eTag          string
 	randomNonce   string
 	router        *httprouter.Router
-	config        config
+	log           *logging.Logger
 	errorLog      *log.Logger
 	infoLog       *log.Logger
 	templateCache map[string]*template.Template
 	formDecoder   *form.Decoder
-}
+	keyLog        *transparency.Log
+	adminKeys     map[string]string
 
-func main() {
-	var cfg config
+	// Swapped whole on SIGHUP, see config.go
+	cfg             atomic.Pointer[config]
+	domainSet       atomic.Pointer[domains.Set]
+	certificate     atomic.Pointer[tls.Certificate]
+	certManager     *autocert.Manager
+	configPath      string
+	configOverrides map[string]string
+
+	// Done once the server shuts down, see shutdown.go
+	stopping   context.Context
+	stop       context.CancelFunc
+	drainMutex sync.Mutex
+	draining   bool
+	transfers  sync.WaitGroup
+	janitors   sync.WaitGroup
 
-	flag.IntVar(&cfg.port, "port", 4000, "Server port")
-	flag.StringVar(&cfg.mailAgentHostname, "agent-hostname", "", "Public agent hostname")
-	flag.StringVar(&cfg.dataDirPath, "data-dir", "/tmp", "User data directory path")
+	notificationHub *notification.Hub
+	webhooks        *webhook.Dispatcher
+	fetcher         *inbox.Fetcher
 
-	var provisioningDomainsStr string
-	flag.StringVar(&provisioningDomainsStr, "provision", "", "Enable provisioning on listed comma separated domains")
+	metrics *serverMetrics
+
+	limiters struct {
+		ip             *ratelimit.Limiter
+		link           *ratelimit.Limiter
+		fingerprint    *ratelimit.Limiter
+		provision      *ratelimit.Limiter
+		contactRequest *ratelimit.Limiter
+	}
+}
 
-	flag.BoolVar(&cfg.tls.enabled, "tls", false, "Enable TLS")
-	flag.StringVar(&cfg.tls.certPath, "tls-cert", "./tls/cert.pem", "TLS Certificate path")
-	flag.StringVar(&cfg.tls.keyPath, "tls-key", "./tls/key.pem", "TLS Key path")
+func main() {
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "TOML configuration file, reloaded on SIGHUP, flags given override it")
+	registerFlags(flag.CommandLine, &config{}, &rawSettings{})
 	flag.Parse()
 
-	if provisioningDomainsStr != "" {
-		domainsStr := strings.Split(provisioningDomainsStr, ",")
-		for _, domainHostname := range domainsStr {
-			domainHostname = strings.ToLower(strings.TrimSpace(domainHostname))
-			if utils.IsValidHostname(domainHostname) {
-				cfg.provisioning.domains = append(cfg.provisioning.domains, domainHostname)
-			}
-			if len(cfg.provisioning.domains) > 0 {
-				cfg.provisioning.enabled = true
-			}
+	// Flags given on the command line are applied again over every reload
+	overrides := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			overrides[f.Name] = f.Value.String()
 		}
-	}
+	})
+
+	// Entries go out as JSON, also those of the standard loggers handed to packages
+	logger := logging.New(os.Stderr, logging.INFO)
+	infoLog := logger.Std(logging.INFO)
+	errorLog := logger.Std(logging.ERROR)
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	cfg, err := loadConfig(configPath, overrides)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	logger.SetLevel(cfg.logLevel)
 
 	templateCache, err := newTemplateCache()
 	if err != nil {
@@ -85,14 +192,65 @@ func main() {
 
 	formDecoder := form.NewDecoder()
 
+	keyLog, err := transparency.Open(cfg.dataDirPath)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	domainSet, err := domains.Load(cfg.domainsDirPath)
+	if err != nil {
+		errorLog.Fatalf("-domains-dir: %s", err)
+	}
+
 	app := &application{
-		router:        httprouter.New(),
-		config:        cfg,
-		errorLog:      errorLog,
-		infoLog:       infoLog,
-		templateCache: templateCache,
-		formDecoder:   formDecoder,
+		router:          httprouter.New(),
+		log:             logger,
+		errorLog:        errorLog,
+		infoLog:         infoLog,
+		templateCache:   templateCache,
+		formDecoder:     formDecoder,
+		keyLog:          keyLog,
+		configPath:      configPath,
+		configOverrides: overrides,
+
+		notificationHub: notification.NewHub(),
+		webhooks:        webhook.NewDispatcher(cfg.webhooksAllowPrivate, errorLog),
+	}
+	app.stopping, app.stop = context.WithCancel(context.Background())
+	app.metrics = app.newMetrics()
+	app.cfg.Store(cfg)
+	app.domainSet.Store(domainSet)
+	notification.SetMaxAcknowledgedAge(cfg.limits.notificationTime)
+	notification.SetErrorLog(errorLog)
+	if cfg.adminKeysPath != "" {
+		app.adminKeys, err = admin.LoadAuthorizedKeys(cfg.adminKeysPath)
+		if err != nil {
+			errorLog.Fatalf("-admin-keys: %s", err)
+		}
+	}
+	if cfg.inboxFetcher.enabled {
+		app.fetcher = inbox.NewFetcher(webhook.NewPublicClient(cfg.inboxFetcher.allowPrivate), infoLog, errorLog)
+		app.fetcher.MaxSize = func(homeDirPath string) int64 {
+			return app.maxMessageSize(filepath.Base(filepath.Dir(homeDirPath)))
+		}
+		// As for messages stored by the account itself
+		app.fetcher.MaxAccountSize = func(homeDirPath string) (int64, error) {
+			return app.accountSizeLimit(homeDirPath, filepath.Base(filepath.Dir(homeDirPath)))
+		}
 	}
+	app.limiters.ip = ratelimit.New("ip", cfg.rateLimits.ip)
+	app.limiters.link = ratelimit.New("link", cfg.rateLimits.link)
+	app.limiters.fingerprint = ratelimit.New("fingerprint", cfg.rateLimits.fingerprint)
+	app.limiters.provision = ratelimit.New("provision", cfg.rateLimits.provision)
+	app.limiters.contactRequest = ratelimit.New("contact-request", cfg.rateLimits.contactRequest)
+
+	app.logHostedKeys()
+
+	// Both run whatever the settings, which may change on reload
+	app.janitors.Add(2)
+	go app.eraseDueAccounts()
+	go app.expireDomainData()
+	go app.reloadOnHangup()
 
 	etag, err := crypto.GenerateRandomString(6)
 	if err != nil {
@@ -101,15 +259,36 @@ func main() {
 	app.eTag = etag
 
 	var tlsConfig *tls.Config
-	if cfg.tls.enabled {
+	if cfg.tls.enabled || cfg.acme.enabled {
+		// Domains with certificates of their own get them by SNI, the rest one by ACME or the one given with -tls-cert
 		tlsConfig = &tls.Config{
 			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 			MinVersion:       tls.VersionTLS12,
+			GetCertificate:   app.getCertificate,
+		}
+	}
+	if cfg.tls.enabled {
+		cert, err := tls.LoadX509KeyPair(cfg.tls.certPath, cfg.tls.keyPath)
+		if err != nil {
+			errorLog.Fatalf("-tls-cert: %s", err)
+		}
+		app.certificate.Store(&cert)
+	}
+	if cfg.acme.enabled {
+		app.certManager, err = app.newCertManager(cfg)
+		if err != nil {
+			errorLog.Fatalf("-acme-ca-roots: %s", err)
+		}
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		if cfg.acme.httpAddress != "" {
+			go func() {
+				errorLog.Fatalf("-acme-http-address: %s", http.ListenAndServe(cfg.acme.httpAddress, app.certManager.HTTPHandler(nil)))
+			}()
 		}
 	}
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.port),
+		Addr:         net.JoinHostPort(cfg.listenAddress, strconv.Itoa(cfg.port)),
 		ErrorLog:     app.errorLog,
 		Handler:      app.routes(),
 		TLSConfig:    tlsConfig,
@@ -118,11 +297,19 @@ func main() {
 		WriteTimeout: 900 * time.Second,
 	}
 
-	app.infoLog.Printf("Starting server on %d", cfg.port)
-	if cfg.tls.enabled {
-		err = srv.ListenAndServeTLS(cfg.tls.certPath, cfg.tls.keyPath)
-	} else {
-		err = srv.ListenAndServe()
+	if cfg.metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.metrics.registry.Handler())
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.HandleFunc("/healthz", app.getHealth)
+		mux.HandleFunc("/readyz", app.getReadiness)
+		go app.serveMetrics(cfg.metricsAddress, mux)
+	}
+
+	app.log.Info("Starting server", "address", srv.Addr)
+	err = app.serve(srv, tlsConfig != nil)
+	if err != nil {
+		app.errorLog.Fatal(err)
 	}
-	app.errorLog.Fatal(err)
+	app.log.Info("Server stopped")
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"email.mercata.com/internal/admin"
+	"email.mercata.com/internal/metrics"
+)
+
+// Per-domain storage is summed up at most this often, walking the data directory
+const DOMAIN_STORAGE_REFRESH_INTERVAL = 5 * time.Minute
+
+// Reasons of authentication failures
+const AUTH_FAILURE_BAD_HEADER = "bad_header"
+const AUTH_FAILURE_BAD_SIGNATURE = "bad_signature"
+const AUTH_FAILURE_REPLAY = "replay"
+const AUTH_FAILURE_FINGERPRINT_MISMATCH = "fingerprint_mismatch"
+const AUTH_FAILURE_UNKNOWN_ACCOUNT = "unknown_account"
+
+// Causes of message deletions
+const MESSAGE_DELETED_BY_OWNER = "owner"
+const MESSAGE_DELETED_EXPIRED = "expired"
+
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	authFailures    *metrics.Counter
+	bytesUploaded   *metrics.Counter
+	bytesServed     *metrics.Counter
+
+	messagesStored       *metrics.Counter
+	messagesDeleted      *metrics.Counter
+	notificationsWritten *metrics.Counter
+	activeUploads        *metrics.Gauge
+
+	domainsMutex     sync.Mutex
+	domains          []*admin.Domain
+	domainsRefreshed time.Time
+}
+
+func (app *application) newMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+
+		requests:        r.NewCounter("openemail_http_requests_total", "HTTP requests by route, method and status code", "route", "method", "code"),
+		requestDuration: r.NewHistogram("openemail_http_request_duration_seconds", "Time taken to answer HTTP requests by route", metrics.DEFAULT_BUCKETS, "route"),
+		authFailures:    r.NewCounter("openemail_auth_failures_total", "Requests refused authentication by reason", "reason"),
+		bytesUploaded:   r.NewCounter("openemail_uploaded_bytes_total", "Bytes read from request bodies"),
+		bytesServed:     r.NewCounter("openemail_served_bytes_total", "Bytes written in response bodies"),
+
+		messagesStored:       r.NewCounter("openemail_messages_stored_total", "Messages stored by accounts", "domain"),
+		messagesDeleted:      r.NewCounter("openemail_messages_deleted_total", "Messages deleted by their authors or expired", "domain", "cause"),
+		notificationsWritten: r.NewCounter("openemail_notifications_written_total", "Notifications stored for accounts", "domain"),
+		activeUploads:        r.NewGauge("openemail_active_uploads", "Messages being uploaded"),
+	}
+	r.NewGaugeFunc("openemail_domain_storage_bytes", "Bytes stored by the accounts of the domain", func() []metrics.Sample {
+		var samples []metrics.Sample
+		for _, d := range app.domainUsage() {
+			samples = append(samples, metrics.Sample{LabelValues: []string{d.Name}, Value: float64(d.Size)})
+		}
+		return samples
+	}, "domain")
+	r.NewGaugeFunc("openemail_domain_accounts", "Accounts of the domain", func() []metrics.Sample {
+		var samples []metrics.Sample
+		for _, d := range app.domainUsage() {
+			samples = append(samples, metrics.Sample{LabelValues: []string{d.Name}, Value: float64(d.Accounts)})
+		}
+		return samples
+	}, "domain")
+	return m
+}
+
+// domainUsage returns the usage of the domains as last summed up
+func (app *application) domainUsage() []*admin.Domain {
+	m := app.metrics
+	m.domainsMutex.Lock()
+	defer m.domainsMutex.Unlock()
+	if time.Since(m.domainsRefreshed) < DOMAIN_STORAGE_REFRESH_INTERVAL {
+		return m.domains
+	}
+	domains, err := admin.ListDomains(app.config().dataDirPath)
+	if err != nil {
+		app.log.Error("Could not sum up domain storage", "error", err)
+		return m.domains
+	}
+	m.domains, m.domainsRefreshed = domains, time.Now()
+	return m.domains
+}
+
+func (app *application) authFailed(reason string) {
+	app.metrics.authFailures.Inc(reason)
+}
+
+// handle registers the handler of the route, counting its requests
+func (app *application) handle(method, path string, handler http.Handler) {
+	app.router.Handler(method, path, app.instrument(path, handler))
+}
+
+func (app *application) instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		mw := &countingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		// Panics are answered with 500 by recoverPanic further out
+		defer func() {
+			if err := recover(); err != nil {
+				mw.status = http.StatusInternalServerError
+				app.countRequest(route, r.Method, start, body, mw)
+				panic(err)
+			}
+		}()
+		next.ServeHTTP(mw, r)
+		app.countRequest(route, r.Method, start, body, mw)
+	})
+}
+
+func (app *application) countRequest(route, method string, start time.Time, body *countingReader, mw *countingResponseWriter) {
+	app.metrics.requests.Inc(route, method, strconv.Itoa(mw.status))
+	app.metrics.requestDuration.Observe(time.Since(start).Seconds(), route)
+	app.metrics.bytesUploaded.Add(float64(body.read))
+	app.metrics.bytesServed.Add(float64(mw.written))
+}
+
+type countingReader struct {
+	io.ReadCloser
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Flush keeps notification streams working
+func (w *countingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveMetrics serves the metrics and expvar counters on their own
+// listener, for as long as the server runs
+func (app *application) serveMetrics(address string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:        address,
+		ErrorLog:    app.errorLog,
+		Handler:     handler,
+		IdleTimeout: time.Minute,
+	}
+	app.log.Info("Serving metrics", "address", address)
+	app.errorLog.Fatalf("-metrics-address: %s", srv.ListenAndServe())
+}
@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	"email.mercata.com/internal/admin"
 	"email.mercata.com/internal/consts"
 	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/delegation"
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/email/address"
 	"email.mercata.com/internal/email/profile"
 	"email.mercata.com/internal/nonce"
+	"email.mercata.com/internal/ratelimit"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"github.com/justinas/alice"
 	"github.com/justinas/nosurf"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 func noSurf(next http.Handler) http.Handler {
@@ -29,7 +39,7 @@ func (app *application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		inlineRandomNonce, err := crypto.GenerateRandomString(6)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 
@@ -51,11 +61,20 @@ func (app *application) secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// logRequest logs the request once answered, see logging.go
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		start := time.Now()
+		info := &requestInfo{id: requestID(r)}
+		w.Header().Set(consts.REQUEST_ID_HEADER, info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info))
+		cw := &countingResponseWriter{ResponseWriter: w, status: http.StatusOK}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(cw, r)
+
+		app.requestLog(r).Info("Request",
+			"remote", r.RemoteAddr, "proto", r.Proto, "method", r.Method, "uri", r.URL.RequestURI(),
+			"status", cw.status, "bytes", cw.written, "seconds", time.Since(start))
 	})
 }
 
@@ -64,7 +83,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 
@@ -76,13 +95,15 @@ func (app *application) authenticatePrivate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		n, err := nonce.FromHeader(r.Header.Get(consts.AUTHORIZATION_HEADER_NONCE))
 		if err != nil {
-			app.errorLog.Printf("Could not extract nonce. %s", err)
+			app.requestLog(r).Info("Could not extract nonce", "error", err)
+			app.authFailed(AUTH_FAILURE_BAD_HEADER)
 			app.clientError(w, http.StatusBadRequest)
 			return
 		}
 		err = nonce.VerifySignature(n)
 		if err != nil {
-			app.errorLog.Printf("Could not verify nonce. %s", err)
+			app.requestLog(r).Info("Could not verify nonce", "error", err)
+			app.authFailed(AUTH_FAILURE_BAD_SIGNATURE)
 			app.clientError(w, http.StatusBadRequest)
 			return
 		}
@@ -98,45 +119,63 @@ func (app *application) authenticatePrivate(next http.Handler) http.Handler {
 
 		userHomeDir, homeDirExists, err := app.userHomePath(domain, user)
 		if err != nil {
-			app.errorLog.Printf("Could not determine user home path: %s", err)
-			app.serverError(w, err)
+			app.requestLog(r).Error("Could not determine user home path", "error", err)
+			app.serverError(w, r, err)
 			return
 		}
 		if !homeDirExists {
-			app.errorLog.Printf("No such user: %s/%s", domain, user)
+			app.requestLog(r).Info("No such account", "address", address.JoinAddress(domain, user))
+			app.authFailed(AUTH_FAILURE_UNKNOWN_ACCOUNT)
 			app.clientError(w, http.StatusUnauthorized)
 			return
 		}
 		err = nonce.IsUnique(userHomeDir, n)
 		if err != nil {
 			if errors.Is(err, nonce.ErrorNonceReplay) {
+				app.authFailed(AUTH_FAILURE_REPLAY)
 				app.clientError(w, http.StatusUnauthorized)
 				return
 			}
-			app.errorLog.Printf("Could not determine nonce uniqueness: %s", err)
-			app.serverError(w, err)
+			app.requestLog(r).Error("Could not determine nonce uniqueness", "error", err)
+			app.serverError(w, r, err)
 			return
 		}
 		err = nonce.Record(userHomeDir, n)
 		if err != nil {
-			app.errorLog.Printf("Could not record nonce: %s", err)
-			app.serverError(w, err)
+			app.requestLog(r).Error("Could not record nonce", "error", err)
+			app.serverError(w, r, err)
 			return
 		}
 
 		localProfile, err := profile.GetLocalProfile(userHomeDir, domain, user)
 		if err != nil {
-			app.errorLog.Printf("Could not load local profile: %s", err)
+			app.requestLog(r).Error("Could not load local profile", "error", err)
 			app.notFound(w)
 			return
 		}
 
 		if n.SigningKeyFingerprint != localProfile.User.PublicSigningKeyFingerprint &&
 			((localProfile.User.PublicSigningKeyFingerprint != "") && n.SigningKeyFingerprint != localProfile.LastSigningKeyFingerprint) {
+			app.authFailed(AUTH_FAILURE_FINGERPRINT_MISMATCH)
 			app.clientError(w, http.StatusUnauthorized)
 			return
 		}
 
+		// Owners of suspended accounts are told, but let in no further
+		suspension, err := account.Suspended(userHomeDir)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if suspension != nil {
+			app.forbidden(w)
+			return
+		}
+
+		info := requestInfoFromContext(r)
+		info.account = address.JoinAddress(domain, user)
+		info.fingerprint = n.SigningKeyFingerprint
+
 		ctx := context.WithValue(r.Context(), domainContextKey, domain)
 		ctx = context.WithValue(ctx, userContextKey, user)
 		r = r.WithContext(ctx)
@@ -145,17 +184,48 @@ func (app *application) authenticatePrivate(next http.Handler) http.Handler {
 	})
 }
 
+// refuseSuspended answers for suspended accounts with 410 Gone and the
+// reason of the suspension. Unknown accounts are left to the handlers.
+func (app *application) refuseSuspended(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		domain := strings.ToLower(params.ByName("domain"))
+		user := strings.ToLower(params.ByName("user"))
+
+		userHomeDir, homeDirExists, err := app.userHomePath(domain, user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if homeDirExists {
+			suspension, err := account.Suspended(userHomeDir)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			if suspension != nil {
+				app.accountSuspended(w, suspension)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticatePublic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		n, err := nonce.FromHeader(r.Header.Get(consts.AUTHORIZATION_HEADER_NONCE))
 		if err != nil {
-			app.errorLog.Printf("Could not extract nonce: %s", err)
+			app.requestLog(r).Info("Could not extract nonce", "error", err)
+			app.authFailed(AUTH_FAILURE_BAD_HEADER)
 			app.clientError(w, http.StatusBadRequest)
 			return
 		}
 		err = nonce.VerifySignature(n)
 		if err != nil {
-			app.errorLog.Printf("Could not verify nonce: %s", err)
+			app.requestLog(r).Info("Could not verify nonce", "error", err)
+			app.authFailed(AUTH_FAILURE_BAD_SIGNATURE)
 			app.clientError(w, http.StatusBadRequest)
 			return
 		}
@@ -170,7 +240,7 @@ func (app *application) authenticatePublic(next http.Handler) http.Handler {
 		}
 		userHomeDir, homeDirExists, err := app.userHomePath(domain, user)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 		if !homeDirExists {
@@ -181,20 +251,48 @@ func (app *application) authenticatePublic(next http.Handler) http.Handler {
 		err = nonce.IsUnique(userHomeDir, n)
 		if err != nil {
 			if errors.Is(err, nonce.ErrorNonceReplay) {
+				app.authFailed(AUTH_FAILURE_REPLAY)
 				app.clientError(w, http.StatusUnauthorized)
 				return
 			}
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 		err = nonce.Record(userHomeDir, n)
 		if err != nil {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 			return
 		}
 
 		// The fingerprint serves as proof of identity
-		ctx := context.WithValue(r.Context(), signingFingerprintContextKey, n.SigningKeyFingerprint)
+		signingFingerprint := n.SigningKeyFingerprint
+
+		// A fetch key delegated by the reader stands in for the reader, for reading only
+		if delegationHeader := r.Header.Get(consts.FETCH_DELEGATION_HEADER); delegationHeader != "" {
+			if r.Method != http.MethodGet {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+			d, err := delegation.FromHeader(delegationHeader)
+			if err != nil {
+				app.clientError(w, http.StatusBadRequest)
+				return
+			}
+			signingFingerprint, err = d.Verify(link, n.SigningKeyFingerprint)
+			if err != nil {
+				app.requestLog(r).Info("Rejected fetch delegation", "error", err)
+				app.authFailed(AUTH_FAILURE_FINGERPRINT_MISMATCH)
+				app.clientError(w, http.StatusUnauthorized)
+				return
+			}
+		}
+
+		info := requestInfoFromContext(r)
+		info.account = address.JoinAddress(domain, user)
+		info.link = link
+		info.fingerprint = signingFingerprint
+
+		ctx := context.WithValue(r.Context(), signingFingerprintContextKey, signingFingerprint)
 		ctx = context.WithValue(ctx, domainContextKey, domain)
 		ctx = context.WithValue(ctx, userContextKey, user)
 		ctx = context.WithValue(ctx, linkContextKey, link)
@@ -203,3 +301,197 @@ func (app *application) authenticatePublic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticateAdmin admits requests signed with one of the admin keys. The
+// nonces are recorded in the data directory like those of accounts.
+func (app *application) authenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, ok := app.adminNonce(w, r)
+		if !ok {
+			return
+		}
+		name, ok := app.adminKeys[n.SigningKeyFingerprint]
+		if !ok {
+			app.requestLog(r).Info("Refused admin request", "fingerprint", n.SigningKeyFingerprint)
+			app.authFailed(AUTH_FAILURE_FINGERPRINT_MISMATCH)
+			app.clientError(w, http.StatusUnauthorized)
+			return
+		}
+		if !app.recordAdminNonce(w, r, n, filepath.Join(app.config().dataDirPath, admin.ADMIN_DIRECTORY)) {
+			return
+		}
+
+		requestInfoFromContext(r).admin = name
+		ctx := context.WithValue(r.Context(), adminContextKey, name)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateDomainAdmin admits requests signed with one of the admin keys
+// of the domain in the path, or with one of the server's.
+func (app *application) authenticateDomainAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, ok := app.adminNonce(w, r)
+		if !ok {
+			return
+		}
+		if name, ok := app.adminKeys[n.SigningKeyFingerprint]; ok {
+			if !app.recordAdminNonce(w, r, n, filepath.Join(app.config().dataDirPath, admin.ADMIN_DIRECTORY)) {
+				return
+			}
+			requestInfoFromContext(r).admin = name
+			ctx := context.WithValue(r.Context(), adminContextKey, name)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		params := httprouter.ParamsFromContext(r.Context())
+		domain := strings.ToLower(params.ByName("domain"))
+		domainPath, err := admin.DomainPath(app.config().dataDirPath, domain)
+		if err != nil {
+			// Unknown domains have no admins to authenticate
+			if errors.Is(err, admin.ErrorBadName) || errors.Is(err, admin.ErrorNoDomain) {
+				app.clientError(w, http.StatusUnauthorized)
+				return
+			}
+			app.serverError(w, r, err)
+			return
+		}
+		domainKeys, err := admin.LoadDomainKeys(domainPath)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		name, ok := domainKeys[n.SigningKeyFingerprint]
+		if !ok {
+			app.requestLog(r).Info("Refused admin request", "fingerprint", n.SigningKeyFingerprint, "domain", domain)
+			app.authFailed(AUTH_FAILURE_FINGERPRINT_MISMATCH)
+			app.clientError(w, http.StatusUnauthorized)
+			return
+		}
+		if !app.recordAdminNonce(w, r, n, filepath.Join(domainPath, admin.ADMIN_DIRECTORY)) {
+			return
+		}
+
+		requestInfoFromContext(r).admin = name + " of " + domain
+		ctx := context.WithValue(r.Context(), adminContextKey, name+" of "+domain)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// adminNonce returns the verified nonce of an admin request, answering
+// the request itself if there is none.
+func (app *application) adminNonce(w http.ResponseWriter, r *http.Request) (*nonce.Nonce, bool) {
+	n, err := nonce.FromHeader(r.Header.Get(consts.AUTHORIZATION_HEADER_NONCE))
+	if err != nil {
+		app.authFailed(AUTH_FAILURE_BAD_HEADER)
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+	err = nonce.VerifySignature(n)
+	if err != nil {
+		app.authFailed(AUTH_FAILURE_BAD_SIGNATURE)
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+	return n, true
+}
+
+// recordAdminNonce refuses replayed nonces of admin requests and records
+// the others in the directory.
+func (app *application) recordAdminNonce(w http.ResponseWriter, r *http.Request, n *nonce.Nonce, dirPath string) bool {
+	err := os.MkdirAll(dirPath, 0700)
+	if err != nil {
+		app.serverError(w, r, err)
+		return false
+	}
+	err = nonce.IsUnique(dirPath, n)
+	if err != nil {
+		if errors.Is(err, nonce.ErrorNonceReplay) {
+			app.authFailed(AUTH_FAILURE_REPLAY)
+			app.clientError(w, http.StatusUnauthorized)
+			return false
+		}
+		app.serverError(w, r, err)
+		return false
+	}
+	err = nonce.Record(dirPath, n)
+	if err != nil {
+		app.serverError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// rateLimit rejects requests over the rate of the limiter, counting them
+// per key. Requests without a key are let through.
+func (app *application) rateLimit(limiter *ratelimit.Limiter, key func(r *http.Request) string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k != "" {
+				allowed, retryAfter := limiter.Allow(k)
+				if !allowed {
+					app.requestLog(r).Info("Rate limited", "path", r.URL.Path, "limiter", limiter.Name(), "key", k)
+					app.tooManyRequests(w, retryAfter)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Uploads are refused once the server drains for shutdown, and those
+// running are waited for to clean up after themselves
+func (app *application) transfer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.drainMutex.Lock()
+		if app.draining {
+			app.drainMutex.Unlock()
+			app.serviceUnavailable(w)
+			return
+		}
+		app.transfers.Add(1)
+		app.drainMutex.Unlock()
+		defer app.transfers.Done()
+		app.metrics.activeUploads.Add(1)
+		defer app.metrics.activeUploads.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Only requests from the loopback interface, for operator endpoints
+func (app *application) localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(clientIP(r))
+		if ip == nil || !ip.IsLoopback() {
+			app.forbidden(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func linkKey(r *http.Request) string {
+	link, ok := r.Context().Value(linkContextKey).(string)
+	if !ok {
+		return ""
+	}
+	domain, _ := r.Context().Value(domainContextKey).(string)
+	user, _ := r.Context().Value(userContextKey).(string)
+	return domain + "/" + user + "/" + link
+}
+
+func fingerprintKey(r *http.Request) string {
+	fingerprint, _ := r.Context().Value(signingFingerprintContextKey).(string)
+	return fingerprint
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"email.mercata.com/internal/consts"
 	"email.mercata.com/ui"
+	"expvar"
 	"fmt"
 	"github.com/justinas/alice"
 	"net/http"
@@ -10,9 +11,9 @@ import (
 )
 
 func (app *application) routes() http.Handler {
-	app.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	app.router.NotFound = app.instrument("unmatched", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.notFound(w)
-	})
+	}))
 
 	app.router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.clientError(w, http.StatusMethodNotAllowed)
@@ -20,7 +21,7 @@ func (app *application) routes() http.Handler {
 
 	fileServer := http.FileServer(http.FS(ui.Files))
 
-	app.router.Handler(http.MethodGet, "/static/*filepath",
+	app.handle(http.MethodGet, "/static/*filepath",
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			eTagVal := `"` + app.eTag + `"`
 			w.Header().Set("Etag", eTagVal)
@@ -37,68 +38,150 @@ func (app *application) routes() http.Handler {
 		}))
 
 	naked := alice.New()
-	publiclyAuthenticated := naked.Append(app.authenticatePublic)
+	// Public requests are throttled per client IP, and once authenticated also per link and signing key
+	public := naked.Append(app.rateLimit(app.limiters.ip, clientIP))
+	// Suspended accounts are gone to the public
+	publicAccount := public.Append(app.refuseSuspended)
+	publiclyAuthenticated := publicAccount.Append(app.authenticatePublic, app.rateLimit(app.limiters.link, linkKey), app.rateLimit(app.limiters.fingerprint, fingerprintKey))
 	privatelyAuthenticated := naked.Append(app.authenticatePrivate)
 	// dynamic := naked.Append(noSurf)
 
 	// [COMPLETE] Well-known file for those making a CNAME to this server
-	// The TLS certificate for the CNAMEing domains must be provisioned separately.
-	app.router.Handler(http.MethodGet, "/.well-known/mail.txt", naked.ThenFunc(app.getWellKnownFile))
+	// The TLS certificates for the CNAMEing domains come by ACME with -acme, or from the domains directory.
+	app.handle(http.MethodGet, "/.well-known/mail.txt", public.ThenFunc(app.getWellKnownFile))
 
 	// [COMPLETE] Check if mail agent recognized the domain
-	app.router.Handler(http.MethodHead, fmt.Sprintf("/%s/:domain", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.checkDomainDelegation))
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.checkDomainDelegation))
-	app.router.Handler(http.MethodHead, fmt.Sprintf("/%s/:domain/:user", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.checkUserDelegation))
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.checkUserDelegation))
+	app.handle(http.MethodHead, fmt.Sprintf("/%s/:domain", consts.PUBLIC_API_PATH_PREFIX), public.ThenFunc(app.checkDomainDelegation))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain", consts.PUBLIC_API_PATH_PREFIX), public.ThenFunc(app.checkDomainDelegation))
+	app.handle(http.MethodHead, fmt.Sprintf("/%s/:domain/:user", consts.PUBLIC_API_PATH_PREFIX), public.ThenFunc(app.checkUserDelegation))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user", consts.PUBLIC_API_PATH_PREFIX), public.ThenFunc(app.checkUserDelegation))
 
 	// [COMPLETE] Fetching information about contacts
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/profile", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.getProfile))
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/image", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.getProfileImage))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/profile", consts.PUBLIC_API_PATH_PREFIX), publicAccount.ThenFunc(app.getProfile))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/image", consts.PUBLIC_API_PATH_PREFIX), publicAccount.ThenFunc(app.getProfileImage))
+
+	// Key transparency log of all hosted accounts
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/head", consts.KEY_LOG_PATH_PREFIX), public.ThenFunc(app.getKeyLogHead))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/consistency", consts.KEY_LOG_PATH_PREFIX), public.ThenFunc(app.getKeyLogConsistency))
 
 	// TODO: public messages indexing, how to support it best? Mentions? Can the messages be served as HTML? Is there need?
 
 	// [COMPLETE] Fetching remote broadcast messages
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/messages", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.listBroadcastMessages))
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/streams/:stream/messages", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.listBroadcastMessages))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/messages", consts.PUBLIC_API_PATH_PREFIX), publicAccount.ThenFunc(app.listBroadcastMessages))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/streams/:stream/messages", consts.PUBLIC_API_PATH_PREFIX), publicAccount.ThenFunc(app.listBroadcastMessages))
 	// Individual broadcast message
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/messages/:messageid", consts.PUBLIC_API_PATH_PREFIX), naked.ThenFunc(app.getBroadcastMessage))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/messages/:messageid", consts.PUBLIC_API_PATH_PREFIX), publicAccount.ThenFunc(app.getBroadcastMessage))
 
 	// [COMPLETE] Fetching remote private messages
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/link/:link/messages", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.listLinkMessages))
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/link/:link/streams/:stream/messages", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.listLinkMessages))
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/link/:link/messages/:messageid", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.getLinkMessage))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/link/:link/messages", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.listLinkMessages))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/link/:link/streams/:stream/messages", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.listLinkMessages))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/link/:link/messages/:messageid", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.getLinkMessage))
 
 	// [COMPLETE] Storing a remote notification
-	app.router.Handler(http.MethodHead, fmt.Sprintf("/%s/:domain/:user/link/:link/notifications", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.writeNotification))
+	app.handle(http.MethodHead, fmt.Sprintf("/%s/:domain/:user/link/:link/notifications", consts.PUBLIC_API_PATH_PREFIX), publiclyAuthenticated.ThenFunc(app.writeNotification))
+
+	// Contact requests from unknown links, throttled harder as they reach closed profiles too
+	contactRequest := publicAccount.Append(app.rateLimit(app.limiters.contactRequest, clientIP), app.authenticatePublic, app.rateLimit(app.limiters.fingerprint, fingerprintKey))
+	app.handle(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/link/:link/requests", consts.PUBLIC_API_PATH_PREFIX), contactRequest.ThenFunc(app.writeContactRequest))
 
 	// Private API, authenticated ----
 
-	app.router.Handler(http.MethodHead, fmt.Sprintf("/%s/:domain/:user", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.queryAccessToProfile))
+	app.handle(http.MethodHead, fmt.Sprintf("/%s/:domain/:user", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.queryAccessToProfile))
+
+	// Deleting the own account, with a grace period to cancel in if the server has one
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteAccount))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/deletion", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.getAccountDeletion))
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/deletion", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.cancelAccountDeletion))
 
 	// [COMPLETE] Fetching notifications for own account
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/notifications", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.getNotifications))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/notifications", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.getNotifications))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/notifications/stream", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.streamNotifications))
+	app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/notifications/acknowledge", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.acknowledgeNotifications))
+	app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/notifications/delete", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteNotifications))
 
 	// [COMPLETE] Managing own profile
-	app.router.Handler(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/profile", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.setProfile))
-	app.router.Handler(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/image", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.setProfileImage))
+	app.handle(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/profile", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.setProfile))
+	app.handle(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/image", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.setProfileImage))
 
 	// [COMPLETE] Links
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/links", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listLinks))
-	app.router.Handler(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/links/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.storeLink))
-	app.router.Handler(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/links/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteLink))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/links", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listLinks))
+	app.handle(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/links/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.storeLink))
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/links/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteLink))
+
+	// Trail of profile, link, message and account changes
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/audit", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listAudit))
+
+	// Blocklist of links and signing keys
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/blocks", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listBlocks))
+	app.handle(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/blocks/:kind/:value", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.storeBlock))
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/blocks/:kind/:value", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteBlock))
+
+	// Pending contact requests
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/requests", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listContactRequests))
+	app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/requests/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.acceptContactRequest))
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/requests/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.rejectContactRequest))
+
+	// Webhooks posted on new notifications
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/webhooks", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listWebhooks))
+	app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/webhooks", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.storeWebhook))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/webhooks/dead", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listWebhookDeadLetters))
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/webhooks/:id", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteWebhook))
+
+	if app.fetcher != nil {
+		// Inbox of messages fetched from authors of delegated links
+		app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/fetcher/key", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.getFetchKey))
+		app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/fetcher/key", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.createFetchKey))
+		app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/fetcher/delegations", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listFetchDelegations))
+		app.handle(http.MethodPut, fmt.Sprintf("/%s/:domain/:user/fetcher/delegations/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.storeFetchDelegation))
+		app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/fetcher/delegations/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteFetchDelegation))
+		app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/fetcher/fetch/:link", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.fetchDelegatedLink))
+		app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/inbox", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.listInbox))
+		app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/inbox/:link/:messageid", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.getInboxMessage))
+		app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/inbox/:link/:messageid", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteInboxMessage))
+	}
 
 	// [COMPLETE] Managing messages
-	app.router.Handler(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/messages", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.getMessagesStatus))
-	app.router.Handler(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/messages", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.storeMessage))
-	app.router.Handler(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/messages/:mid", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteMessage))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/:domain/:user/messages", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.getMessagesStatus))
+	app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user/messages", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.Append(app.transfer).ThenFunc(app.storeMessage))
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/:domain/:user/messages/:mid", consts.PRIVATE_API_PATH_PREFIX), privatelyAuthenticated.ThenFunc(app.deleteMessage))
+
+	// Provisioning API, public on domains listed with -provision or carrying a provisioning policy
+	app.handle(http.MethodPost, fmt.Sprintf("/%s/:domain/:user", consts.PRIVATE_PROVISION_PATH_PREFIX), naked.Append(app.rateLimit(app.limiters.provision, clientIP)).ThenFunc(app.provisionUser))
+
+	if app.adminKeys != nil {
+		// Admin API, signed with the keys listed with -admin-keys
+		adminAuthenticated := naked.Append(app.authenticateAdmin)
+		app.handle(http.MethodGet, fmt.Sprintf("/%s/stats", consts.ADMIN_API_PATH_PREFIX), adminAuthenticated.ThenFunc(app.getAdminStats))
+		app.handle(http.MethodGet, fmt.Sprintf("/%s/domains", consts.ADMIN_API_PATH_PREFIX), adminAuthenticated.ThenFunc(app.listAdminDomains))
+		app.handle(http.MethodPut, fmt.Sprintf("/%s/domains/:domain", consts.ADMIN_API_PATH_PREFIX), adminAuthenticated.ThenFunc(app.storeAdminDomain))
+		app.handle(http.MethodDelete, fmt.Sprintf("/%s/domains/:domain/accounts/:user", consts.ADMIN_API_PATH_PREFIX), adminAuthenticated.ThenFunc(app.deleteAdminAccount))
+		app.handle(http.MethodPost, fmt.Sprintf("/%s/domains/:domain/accounts/:user/index", consts.ADMIN_API_PATH_PREFIX), adminAuthenticated.ThenFunc(app.rebuildAdminIndex))
+		app.handle(http.MethodDelete, fmt.Sprintf("/%s/domains/:domain/accounts/:user/notifications", consts.ADMIN_API_PATH_PREFIX), adminAuthenticated.ThenFunc(app.purgeAdminNotifications))
+	}
 
-	if app.config.provisioning.enabled {
-		// Provisioning API, public (if enabled)
-		app.router.Handler(http.MethodPost, fmt.Sprintf("/%s/:domain/:user", consts.PRIVATE_PROVISION_PATH_PREFIX), naked.ThenFunc(app.provisionUser))
+	// Admin API of a domain, signed with the admin keys of the domain or of the server
+	domainAdminAuthenticated := naked.Append(app.authenticateDomainAdmin)
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/domains/:domain", consts.ADMIN_API_PATH_PREFIX), domainAdminAuthenticated.ThenFunc(app.getAdminDomainUsage))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/domains/:domain/accounts", consts.ADMIN_API_PATH_PREFIX), domainAdminAuthenticated.ThenFunc(app.listAdminAccounts))
+	app.handle(http.MethodPost, fmt.Sprintf("/%s/domains/:domain/accounts/:user", consts.ADMIN_API_PATH_PREFIX), domainAdminAuthenticated.ThenFunc(app.provisionAdminAccount))
+	app.handle(http.MethodGet, fmt.Sprintf("/%s/domains/:domain/accounts/:user/profile", consts.ADMIN_API_PATH_PREFIX), domainAdminAuthenticated.ThenFunc(app.getAdminProfile))
+	app.handle(http.MethodPut, fmt.Sprintf("/%s/domains/:domain/accounts/:user/quota", consts.ADMIN_API_PATH_PREFIX), domainAdminAuthenticated.ThenFunc(app.setAdminQuota))
+	app.handle(http.MethodPut, fmt.Sprintf("/%s/domains/:domain/accounts/:user/suspension", consts.ADMIN_API_PATH_PREFIX), domainAdminAuthenticated.ThenFunc(app.suspendAdminAccount))
+	app.handle(http.MethodDelete, fmt.Sprintf("/%s/domains/:domain/accounts/:user/suspension", consts.ADMIN_API_PATH_PREFIX), domainAdminAuthenticated.ThenFunc(app.unsuspendAdminAccount))
+
+	// Rate limiting counters, metrics and health, to the operator only, unless
+	// on a listener of their own. Health checks write to the data directory
+	// and tell its free space, which is not for anyone to see or trigger.
+	if app.config().metricsAddress == "" {
+		app.handle(http.MethodGet, "/debug/vars", naked.Append(app.localOnly).Then(expvar.Handler()))
+		app.handle(http.MethodGet, "/metrics", naked.Append(app.localOnly).Then(app.metrics.registry.Handler()))
+		app.handle(http.MethodGet, "/healthz", naked.Append(app.localOnly).ThenFunc(app.getHealth))
+		app.handle(http.MethodGet, "/readyz", naked.Append(app.localOnly).ThenFunc(app.getReadiness))
 	}
 
 	//app.secureHeaders
-	standard := alice.New(app.recoverPanic, app.logRequest)
+	// Panics are recovered inside the request log, so that it has the answer
+	standard := alice.New(app.logRequest, app.recoverPanic)
 
 	return standard.Then(app.router)
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"email.mercata.com/internal/nonce"
+)
+
+// Seconds clients are told to wait before trying a draining server again
+const SERVICE_UNAVAILABLE_RETRY_AFTER = 30
+
+// How long uploads cut off at the shutdown deadline get to remove what they wrote
+const TRANSFER_CLEANUP_TIMEOUT = 30 * time.Second
+
+// serve runs the server until SIGINT or SIGTERM, then drains it: uploads
+// and readiness are refused, notification streams end, and requests in
+// flight get until the shutdown timeout before their connections are
+// closed. The janitors finish their current round before serve returns.
+// A second signal stops the server at once.
+func (app *application) serve(srv *http.Server, useTLS bool) error {
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		app.shutdown(srv, sig)
+		close(stopped)
+	}()
+
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+func (app *application) shutdown(srv *http.Server, sig os.Signal) {
+	timeout := app.config().shutdownTimeout
+	app.log.Info("Shutting down, draining requests", "signal", sig, "timeout_seconds", timeout)
+
+	app.drainMutex.Lock()
+	app.draining = true
+	app.drainMutex.Unlock()
+	app.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		app.log.Warn("Requests still running, closing their connections", "timeout_seconds", timeout)
+		srv.Close()
+	}
+	if !waitTimeout(app.transfers.Wait, TRANSFER_CLEANUP_TIMEOUT) {
+		app.log.Warn("Uploads still running after their connections were closed")
+	}
+
+	app.janitors.Wait()
+	nonce.WaitCleanups()
+}
+
+// waitTimeout tells whether wait returned within the timeout
+func waitTimeout(wait func(), timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
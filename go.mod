@@ -4,17 +4,18 @@ go 1.19
 
 require (
 	fyne.io/fyne/v2 v2.4.0
+	github.com/BurntSushi/toml v1.3.2
 	github.com/go-playground/form/v4 v4.2.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/justinas/alice v1.2.0
 	github.com/justinas/nosurf v1.1.1
 	golang.org/x/crypto v0.12.0
+	golang.org/x/term v0.11.0
 )
 
 require (
 	fyne.io/fyne v1.4.3 // indirect
 	fyne.io/systray v1.10.1-0.20230722100817-88df1e0ffa9a // indirect
-	github.com/BurntSushi/toml v1.3.2 // indirect
 	github.com/Rhymen/go-whatsapp v0.1.1 // indirect
 	github.com/akavel/rsrc v0.10.2 // indirect
 	github.com/andydotxyz/fybro v0.0.0-20230116211640-1755d034a72f // indirect
@@ -0,0 +1,422 @@
+package admin
+
+import (
+	"email.mercata.com/internal/email/account"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/message"
+	"email.mercata.com/internal/email/notification"
+	"email.mercata.com/internal/email/profile"
+	"email.mercata.com/internal/email/storage"
+	"email.mercata.com/internal/provisioning"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Administration of the data directory, shared by cmd/control working on
+ * it directly and by the admin API of a running server, so that both give
+ * the same results. Listings are rendered as lines:
+ *
+ *	domain,accounts,suspended,bytes,provisioning mode
+ *	address,bytes,messages,links,notifications,status,quota bytes
+ *
+ * Names starting with a dot are the server's own and never taken for
+ * domains or accounts.
+ *************************************************************************/
+
+const COLUMN_SEPARATOR = ","
+
+const STATUS_ACTIVE = "active"
+const STATUS_SUSPENDED = "suspended"
+
+var ErrorBadName = errors.New("bad domain or account name")
+var ErrorNoDomain = errors.New("no such domain")
+var ErrorNoAccount = errors.New("no such account")
+var ErrorAccountExists = errors.New("account exists")
+var ErrorNameBuried = errors.New("name belonged to a deleted account")
+var ErrorBadProfile = errors.New("bad profile")
+
+type Domain struct {
+	Name         string
+	Accounts     int
+	Suspended    int
+	Size         int64
+	Provisioning string
+}
+
+type Account struct {
+	Address       string
+	Size          int64
+	Messages      int
+	Links         int
+	Notifications int
+	Suspended     bool
+	Quota         int64
+}
+
+type Stats struct {
+	Domains       int
+	Accounts      int
+	Suspended     int
+	Messages      int
+	Notifications int
+	Size          int64
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "/\\"+COLUMN_SEPARATOR)
+}
+
+// DomainPath returns the directory of a hosted domain.
+func DomainPath(dataDirPath, domain string) (string, error) {
+	domain = strings.ToLower(domain)
+	if !validName(domain) {
+		return "", ErrorBadName
+	}
+	path := filepath.Join(dataDirPath, domain)
+	exists, err := utils.FilePathExists(path)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrorNoDomain
+	}
+	return path, nil
+}
+
+// AccountPath returns the home directory of a hosted account.
+func AccountPath(dataDirPath, domain, user string) (string, error) {
+	user = strings.ToLower(user)
+	if !validName(user) {
+		return "", ErrorBadName
+	}
+	domainPath, err := DomainPath(dataDirPath, domain)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(domainPath, user)
+	exists, err := utils.FilePathExists(path)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrorNoAccount
+	}
+	return path, nil
+}
+
+func listNames(path string) ([]string, error) {
+	dirs, err := utils.ListDirectories(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, dir := range dirs {
+		if validName(dir) {
+			names = append(names, dir)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// countDirectories counts the messages or links kept in the directory
+func countDirectories(path string) int {
+	dirs, err := utils.ListDirectories(path)
+	if err != nil {
+		return 0
+	}
+	return len(dirs)
+}
+
+func ListDomains(dataDirPath string) ([]*Domain, error) {
+	names, err := listNames(dataDirPath)
+	if err != nil {
+		return nil, err
+	}
+	var domains []*Domain
+	for _, name := range names {
+		d, err := DomainUsage(dataDirPath, name)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// DomainUsage sums up the accounts of the domain.
+func DomainUsage(dataDirPath, domain string) (*Domain, error) {
+	accounts, err := ListAccounts(dataDirPath, domain)
+	if err != nil {
+		return nil, err
+	}
+	d := &Domain{Name: strings.ToLower(domain)}
+	for _, a := range accounts {
+		d.Accounts++
+		d.Size += a.Size
+		if a.Suspended {
+			d.Suspended++
+		}
+	}
+	domainPath := filepath.Join(dataDirPath, d.Name)
+	if provisioning.HasPolicy(domainPath) {
+		policy, err := provisioning.LoadPolicy(domainPath, "")
+		if err == nil {
+			d.Provisioning = policy.Mode
+		}
+	}
+	return d, nil
+}
+
+func ListAccounts(dataDirPath, domain string) ([]*Account, error) {
+	domainPath, err := DomainPath(dataDirPath, domain)
+	if err != nil {
+		return nil, err
+	}
+	names, err := listNames(domainPath)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	for _, name := range names {
+		a, err := accountUsage(filepath.Join(domainPath, name))
+		if err != nil {
+			return nil, err
+		}
+		a.Address = address.JoinAddress(strings.ToLower(domain), name)
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func accountUsage(homeDirPath string) (*Account, error) {
+	size, err := utils.DirectorySize(homeDirPath)
+	if err != nil {
+		return nil, err
+	}
+	notifications, err := notification.List(homeDirPath, notification.Filter{})
+	if err != nil {
+		return nil, err
+	}
+	suspension, err := account.Suspended(homeDirPath)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := account.Quota(homeDirPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		Size:          size,
+		Messages:      countDirectories(storage.MessagesPath(homeDirPath)),
+		Links:         countDirectories(storage.LinksPath(homeDirPath)),
+		Notifications: len(notifications),
+		Suspended:     suspension != nil,
+		Quota:         quota,
+	}, nil
+}
+
+func Profile(dataDirPath, domain, user string) ([]byte, error) {
+	homeDirPath, err := AccountPath(dataDirPath, domain, user)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(profile.GetLocalProfileDataPath(homeDirPath))
+}
+
+// Provision creates the account with the profile, bypassing the mode and
+// invites of the provisioning policy but not its other limits, nor the
+// tombstones of deleted accounts. Without a policy given, the one in the
+// domain directory applies.
+func Provision(dataDirPath, domain, user string, profileData []byte, policy *provisioning.Policy, tombstonePeriod time.Duration) (*profile.Profile, error) {
+	user = strings.ToLower(user)
+	if !validName(user) {
+		return nil, ErrorBadName
+	}
+	domainPath, err := DomainPath(dataDirPath, domain)
+	if err != nil {
+		return nil, err
+	}
+	homeDirPath := filepath.Join(domainPath, user)
+	exists, err := utils.FilePathExists(homeDirPath)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrorAccountExists
+	}
+	buriedUntil, err := account.BuriedUntil(domainPath, user, tombstonePeriod)
+	if err != nil {
+		return nil, err
+	}
+	if !buriedUntil.IsZero() {
+		return nil, ErrorNameBuried
+	}
+	if policy == nil {
+		policy, err = provisioning.LoadPolicy(domainPath, provisioning.MODE_CLOSED)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = policy.Check(domainPath, user)
+	if err != nil {
+		return nil, err
+	}
+
+	p := profile.Profile{}
+	err = profile.ParseProfile(&p, profileData)
+	if err != nil || !profile.IsFunctionalProfile(&p) {
+		return nil, ErrorBadProfile
+	}
+	err = profile.SetLocalProfile(homeDirPath, &profileData)
+	if err != nil {
+		return nil, err
+	}
+	p.User.Address = address.JoinAddress(filepath.Base(domainPath), user)
+	return &p, nil
+}
+
+// SetQuota holds the account to the bytes, zero for the limit of the server.
+// The quota may not exceed the limit, that of the domain when it has one.
+func SetQuota(dataDirPath, domain, user string, maxSize, limit int64) error {
+	homeDirPath, err := AccountPath(dataDirPath, domain, user)
+	if err != nil {
+		return err
+	}
+	return account.SetQuota(homeDirPath, maxSize, limit)
+}
+
+func Suspend(dataDirPath, domain, user, reason string) error {
+	homeDirPath, err := AccountPath(dataDirPath, domain, user)
+	if err != nil {
+		return err
+	}
+	return account.Suspend(homeDirPath, reason)
+}
+
+func Unsuspend(dataDirPath, domain, user string) error {
+	homeDirPath, err := AccountPath(dataDirPath, domain, user)
+	if err != nil {
+		return err
+	}
+	return account.Unsuspend(homeDirPath)
+}
+
+// Delete erases the account with all its data, leaving a tombstone so that
+// the name is not provisioned again right away.
+func Delete(dataDirPath, domain, user string) error {
+	homeDirPath, err := AccountPath(dataDirPath, domain, user)
+	if err != nil {
+		return err
+	}
+	return account.Erase(homeDirPath)
+}
+
+// RebuildIndex recreates the messages index of the account from the
+// envelopes of the stored messages and returns the number of entries.
+func RebuildIndex(dataDirPath, domain, user string) (int, error) {
+	homeDirPath, err := AccountPath(dataDirPath, domain, user)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(storage.MessagesPath(homeDirPath))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	var lines []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !utils.ValidMessageID(entry.Name()) {
+			continue
+		}
+		msg, err := message.ParseEnvelopeFile(storage.MessagePath(homeDirPath, entry.Name()))
+		if err != nil {
+			return 0, fmt.Errorf("message %s: %w", entry.Name(), err)
+		}
+		for _, reader := range msg.Readers {
+			lines = append(lines, storage.MessageIndexLine(reader.Link, reader.User.PublicSigningKeyFingerprint, msg.StreamID, msg.ID))
+		}
+	}
+	return len(lines), storage.RewriteMessagesIndex(homeDirPath, lines)
+}
+
+// PurgeNotifications deletes all notifications of the account and returns
+// how many there were.
+func PurgeNotifications(dataDirPath, domain, user string) (int, error) {
+	homeDirPath, err := AccountPath(dataDirPath, domain, user)
+	if err != nil {
+		return 0, err
+	}
+	return notification.Delete(homeDirPath, []string{notification.ALL_NOTIFICATIONS})
+}
+
+// AddProvisioningDomain hosts the domain, open to provisioning in the given
+// mode. The policy of a domain having one is updated to the mode.
+func AddProvisioningDomain(dataDirPath, domain, mode string) error {
+	domain = strings.ToLower(domain)
+	if !validName(domain) {
+		return ErrorBadName
+	}
+	if !provisioning.ValidMode(mode) {
+		return provisioning.ErrorBadPolicy
+	}
+	domainPath := filepath.Join(dataDirPath, domain)
+	err := os.MkdirAll(domainPath, 0755)
+	if err != nil {
+		return err
+	}
+	return provisioning.SetMode(domainPath, mode)
+}
+
+func ServerStats(dataDirPath string) (*Stats, error) {
+	domains, err := listNames(dataDirPath)
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{Domains: len(domains)}
+	for _, domain := range domains {
+		accounts, err := ListAccounts(dataDirPath, domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accounts {
+			stats.Accounts++
+			stats.Messages += a.Messages
+			stats.Notifications += a.Notifications
+			stats.Size += a.Size
+			if a.Suspended {
+				stats.Suspended++
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (d *Domain) ToLine() string {
+	return strings.Join([]string{d.Name, strconv.Itoa(d.Accounts), strconv.Itoa(d.Suspended), strconv.FormatInt(d.Size, 10), d.Provisioning}, COLUMN_SEPARATOR)
+}
+
+func (a *Account) ToLine() string {
+	status := STATUS_ACTIVE
+	if a.Suspended {
+		status = STATUS_SUSPENDED
+	}
+	return strings.Join([]string{a.Address, strconv.FormatInt(a.Size, 10), strconv.Itoa(a.Messages), strconv.Itoa(a.Links), strconv.Itoa(a.Notifications), status, strconv.FormatInt(a.Quota, 10)}, COLUMN_SEPARATOR)
+}
+
+func (s *Stats) ToLines() []string {
+	return []string{
+		"Domains: " + strconv.Itoa(s.Domains),
+		"Accounts: " + strconv.Itoa(s.Accounts),
+		"Suspended: " + strconv.Itoa(s.Suspended),
+		"Messages: " + strconv.Itoa(s.Messages),
+		"Notifications: " + strconv.Itoa(s.Notifications),
+		"Size: " + utils.ByteCountSI(s.Size),
+	}
+}
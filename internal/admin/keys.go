@@ -0,0 +1,215 @@
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/crypto"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* The admin API takes requests signed with the keys listed in the file
+ * given to the server with -admin-keys, one base64 public signing key per
+ * line, optionally followed by a name:
+ *
+ *	<base64 public signing key> operator@example.com
+ *
+ * Keys listed the same way in <data-dir>/<domain>/.admin-keys administer
+ * the accounts of that domain only, their nonces kept in <domain>/.admin.
+ *
+ * Operators keep the key pair in a file of their own, made by cmd/control:
+ *
+ *	Public-Key: <base64>
+ *	Private-Key: <base64>
+ *************************************************************************/
+
+const KEY_FIELD_PUBLIC = "Public-Key"
+const KEY_FIELD_PRIVATE = "Private-Key"
+
+// Directory in the data directory holding the nonces of admin requests
+const ADMIN_DIRECTORY = ".admin"
+
+const DOMAIN_ADMIN_KEYS_FILENAME = ".admin-keys"
+
+var ErrorBadKeyFile = errors.New("bad admin key file")
+var ErrorBadKey = errors.New("bad admin key")
+var ErrorNoKey = errors.New("no such admin key")
+
+type KeyPair struct {
+	PublicKeyBase64 string
+	PublicKey       [32]byte
+	PrivateKey      [64]byte
+}
+
+// LoadAuthorizedKeys returns the names of the listed keys by their
+// fingerprints, the key itself where no name is given.
+func LoadAuthorizedKeys(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key, err := crypto.DecodeBase64Key32(fields[0])
+		if err != nil {
+			return nil, ErrorBadKeyFile
+		}
+		name := fields[0]
+		if len(fields) > 1 {
+			name = strings.Join(fields[1:], " ")
+		}
+		keys[crypto.Fingerprint(key[:])] = name
+	}
+	return keys, scanner.Err()
+}
+
+func domainKeysPath(domainPath string) string {
+	return filepath.Join(domainPath, DOMAIN_ADMIN_KEYS_FILENAME)
+}
+
+// LoadDomainKeys returns the admin keys of the domain like
+// LoadAuthorizedKeys, none if the domain has no admins.
+func LoadDomainKeys(domainPath string) (map[string]string, error) {
+	keys, err := LoadAuthorizedKeys(domainKeysPath(domainPath))
+	if err != nil && os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	return keys, err
+}
+
+// ListDomainKeys returns the lines of the domain admin keys file.
+func ListDomainKeys(dataDirPath, domain string) ([]string, error) {
+	domainPath, err := DomainPath(dataDirPath, domain)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(domainKeysPath(domainPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// AddDomainKey lets the key administer the accounts of the domain.
+func AddDomainKey(dataDirPath, domain, keyBase64, name string) error {
+	if _, err := crypto.DecodeBase64Key32(keyBase64); err != nil {
+		return ErrorBadKey
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	lines, err := ListDomainKeys(dataDirPath, domain)
+	if err != nil {
+		return err
+	}
+	line := keyBase64
+	if name != "" {
+		line += " " + name
+	}
+	var kept []string
+	for _, l := range lines {
+		if strings.Fields(l)[0] != keyBase64 {
+			kept = append(kept, l)
+		}
+	}
+	return saveDomainKeys(dataDirPath, domain, append(kept, line))
+}
+
+func RemoveDomainKey(dataDirPath, domain, keyBase64 string) error {
+	lines, err := ListDomainKeys(dataDirPath, domain)
+	if err != nil {
+		return err
+	}
+	var kept []string
+	for _, l := range lines {
+		if strings.Fields(l)[0] != keyBase64 {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == len(lines) {
+		return ErrorNoKey
+	}
+	return saveDomainKeys(dataDirPath, domain, kept)
+}
+
+func saveDomainKeys(dataDirPath, domain string, lines []string) error {
+	domainPath, err := DomainPath(dataDirPath, domain)
+	if err != nil {
+		return err
+	}
+	path := domainKeysPath(domainPath)
+	data := ""
+	for _, line := range lines {
+		data += line + "\n"
+	}
+	err = os.WriteFile(path+".tmp", []byte(data), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func GenerateKeyPair(path string) (*KeyPair, error) {
+	privateKey, publicKey := crypto.GenerateSigningKeys()
+	lines := []string{
+		KEY_FIELD_PUBLIC + ": " + publicKey,
+		KEY_FIELD_PRIVATE + ": " + privateKey,
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = file.WriteString(strings.Join(lines, "\n") + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return LoadKeyPair(path)
+}
+
+func LoadKeyPair(path string) (*KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var publicKey, privateKey string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case KEY_FIELD_PUBLIC:
+			publicKey = strings.TrimSpace(parts[1])
+		case KEY_FIELD_PRIVATE:
+			privateKey = strings.TrimSpace(parts[1])
+		}
+	}
+	pair := &KeyPair{PublicKeyBase64: publicKey}
+	pair.PublicKey, err = crypto.DecodeBase64Key32(publicKey)
+	if err != nil {
+		return nil, ErrorBadKeyFile
+	}
+	pair.PrivateKey, err = crypto.DecodeBase64Key64(privateKey)
+	if err != nil {
+		return nil, ErrorBadKeyFile
+	}
+	return pair, nil
+}
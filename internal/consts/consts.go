@@ -7,11 +7,20 @@ import (
 const MAX_PROFILE_SIZE = 65536
 const MAX_PROFILE_IMAGE_SIZE = 524288
 const MAX_NOTIFICATION_SIZE = 1024
+const MAX_CONTACT_REQUEST_SIZE = 4096
 
 const AUTHORIZATION_HEADER_NONCE = "Authorization"
 const NOTIFICATION_ORIGIN_HEADER = "Notifier-Encrypted"
+const NOTIFICATION_STAMP_HEADER = "Notification-Stamp"
+const NOTIFICATION_STAMP_BITS_HEADER = "Notification-Stamp-Bits"
+const FETCH_DELEGATION_HEADER = "Fetch-Delegation"
+const PROVISIONING_INVITE_HEADER = "Provisioning-Invite"
+const ACCOUNT_SUSPENDED_HEADER = "Account-Suspended"
+const ACCOUNT_DELETION_HEADER = "Account-Deletion"
+const REQUEST_ID_HEADER = "X-Request-Id"
 
 const MAX_NOTIFICATION_TIME = time.Hour * 24 * 14
+const MAX_CONTACT_REQUEST_TIME = time.Hour * 24 * 30
 
 const MAX_CACHE_DURATION = 600
 
@@ -25,3 +34,5 @@ const MESSAGE_DIR_PAYLOAD_FILE_NAME = "payload"
 const PRIVATE_API_PATH_PREFIX = "home"
 const PUBLIC_API_PATH_PREFIX = "mail"
 const PRIVATE_PROVISION_PATH_PREFIX = "account"
+const ADMIN_API_PATH_PREFIX = "admin"
+const KEY_LOG_PATH_PREFIX = "keylog"
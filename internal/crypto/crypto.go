@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -9,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/nacl/box"
 	"golang.org/x/crypto/nacl/sign"
 	"io"
@@ -154,6 +156,22 @@ func GenerateSigningKeys() (privKeyEncodedStr string, pubKeyEncodedStr string) {
 	return
 }
 
+// SigningKeysFromSeed rebuilds a signing key pair from the first half of its private key
+func SigningKeysFromSeed(seed []byte) (privateKey [64]byte, publicKey [32]byte, err error) {
+	if len(seed) != ed25519.SeedSize {
+		return privateKey, publicKey, errors.New("bad signing key seed length")
+	}
+	copy(privateKey[:], ed25519.NewKeyFromSeed(seed))
+	copy(publicKey[:], privateKey[32:])
+	return privateKey, publicKey, nil
+}
+
+func EncryptionPublicKey(privateKey [32]byte) [32]byte {
+	var publicKey [32]byte
+	curve25519.ScalarBaseMult(&publicKey, &privateKey)
+	return publicKey
+}
+
 func SignData(publicKey [32]byte, privateKey [64]byte, data []byte) string {
 	signature := sign.Sign(nil, data, &privateKey)
 	return base64.StdEncoding.EncodeToString(signature)
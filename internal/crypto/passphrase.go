@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strconv"
+	"strings"
+)
+
+const PASSPHRASE_KDF_ALGORITHM = "argon2id"
+const PASSPHRASE_KDF_TIME = 3
+const PASSPHRASE_KDF_MEMORY = 64 * 1024 // KiB
+const PASSPHRASE_KDF_THREADS = 4
+const PASSPHRASE_KDF_SALT_LENGTH = 16
+const PASSPHRASE_KEY_LENGTH = 32
+
+// Upper bounds protect against key files that request absurd resources
+const MAX_PASSPHRASE_KDF_TIME = 64
+const MAX_PASSPHRASE_KDF_MEMORY = 4 * 1024 * 1024
+
+const KDF_TIME_ATTRIBUTE = "time"
+const KDF_MEMORY_ATTRIBUTE = "memory"
+const KDF_THREADS_ATTRIBUTE = "threads"
+const KDF_SALT_ATTRIBUTE = "salt"
+
+type KDFInfo struct {
+	Algorithm string
+	Time      uint32
+	Memory    uint32
+	Threads   uint8
+	Salt      []byte
+}
+
+// NewKDFInfo returns the default memory-hard key derivation
+// parameters with a freshly generated salt.
+func NewKDFInfo() (*KDFInfo, error) {
+	salt, err := GenerateRandomBytes(PASSPHRASE_KDF_SALT_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	return &KDFInfo{
+		Algorithm: PASSPHRASE_KDF_ALGORITHM,
+		Time:      PASSPHRASE_KDF_TIME,
+		Memory:    PASSPHRASE_KDF_MEMORY,
+		Threads:   PASSPHRASE_KDF_THREADS,
+		Salt:      salt,
+	}, nil
+}
+
+func (ki *KDFInfo) DeriveKey(passphrase []byte) []byte {
+	return argon2.IDKey(passphrase, ki.Salt, ki.Time, ki.Memory, ki.Threads, PASSPHRASE_KEY_LENGTH)
+}
+
+func (ki *KDFInfo) ToHeader() string {
+	return fmt.Sprintf("%s=%s; %s=%d; %s=%d; %s=%d; %s=%s",
+		ALGORITHM_ATTRIBUTE, ki.Algorithm,
+		KDF_TIME_ATTRIBUTE, ki.Time,
+		KDF_MEMORY_ATTRIBUTE, ki.Memory,
+		KDF_THREADS_ATTRIBUTE, ki.Threads,
+		KDF_SALT_ATTRIBUTE, base64.StdEncoding.EncodeToString(ki.Salt))
+}
+
+func KDFInfoFromHeader(headerValue string) (*KDFInfo, error) {
+	ki := KDFInfo{}
+
+	for _, pair := range strings.Split(headerValue, ";") {
+		kvs := strings.SplitN(pair, "=", 2)
+		if len(kvs) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kvs[1])
+		switch strings.ToLower(strings.TrimSpace(kvs[0])) {
+		case ALGORITHM_ATTRIBUTE:
+			algorithm := strings.ToLower(value)
+			if algorithm != PASSPHRASE_KDF_ALGORITHM {
+				return nil, errors.New("unsupported key derivation algorithm: " + algorithm)
+			}
+			ki.Algorithm = algorithm
+
+		case KDF_TIME_ATTRIBUTE:
+			t, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			ki.Time = uint32(t)
+
+		case KDF_MEMORY_ATTRIBUTE:
+			m, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			ki.Memory = uint32(m)
+
+		case KDF_THREADS_ATTRIBUTE:
+			t, err := strconv.ParseUint(value, 10, 8)
+			if err != nil {
+				return nil, err
+			}
+			ki.Threads = uint8(t)
+
+		case KDF_SALT_ATTRIBUTE:
+			salt, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, err
+			}
+			ki.Salt = salt
+		}
+	}
+
+	if ki.Algorithm == "" || len(ki.Salt) == 0 || ki.Threads == 0 {
+		return nil, errors.New("incomplete key derivation parameters")
+	}
+	if ki.Time == 0 || ki.Time > MAX_PASSPHRASE_KDF_TIME || ki.Memory == 0 || ki.Memory > MAX_PASSPHRASE_KDF_MEMORY {
+		return nil, errors.New("unacceptable key derivation cost")
+	}
+	return &ki, nil
+}
@@ -0,0 +1,124 @@
+package crypto
+
+import (
+	"errors"
+)
+
+/* Shamir secret sharing over GF(2^8), with the AES reduction polynomial.
+ * Every byte of the secret is shared with its own random polynomial of
+ * degree threshold-1. A share is its x coordinate followed by the y value
+ * of each byte polynomial.
+ **************************************************************************/
+
+const SHAMIR_MAX_SHARES = 255
+
+var ErrorShamirParameters = errors.New("invalid number of shares or threshold")
+var ErrorShamirShares = errors.New("inconsistent or duplicate shares")
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	var x byte = 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		// Multiply by the generator 3
+		x ^= gfMulNoTable(x, 2)
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMulNoTable(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// ShamirSplit returns the shares of secret, any threshold of which rebuild it.
+// The first byte of each share is its x coordinate.
+func ShamirSplit(secret []byte, shares, threshold int) ([][]byte, error) {
+	if threshold < 2 || shares < threshold || shares > SHAMIR_MAX_SHARES || len(secret) == 0 {
+		return nil, ErrorShamirParameters
+	}
+
+	result := make([][]byte, shares)
+	for i := range result {
+		result[i] = make([]byte, len(secret)+1)
+		result[i][0] = byte(i + 1)
+	}
+
+	for b, secretByte := range secret {
+		coefficients, err := GenerateRandomBytes(threshold - 1)
+		if err != nil {
+			return nil, err
+		}
+		for i := range result {
+			x := result[i][0]
+			// Horner's method, highest degree first
+			var y byte
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			result[i][b+1] = gfMul(y, x) ^ secretByte
+		}
+	}
+	return result, nil
+}
+
+// ShamirCombine interpolates the secret at x=0 from the given shares. It
+// cannot tell whether enough shares were given, callers verify the result.
+func ShamirCombine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrorShamirParameters
+	}
+	length := len(shares[0])
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != length || length < 2 || share[0] == 0 || seen[share[0]] {
+			return nil, ErrorShamirShares
+		}
+		seen[share[0]] = true
+	}
+
+	secret := make([]byte, length-1)
+	for i, share := range shares {
+		// Lagrange basis polynomial of this share, evaluated at zero
+		var basis byte = 1
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(other[0], other[0]^share[0]))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(share[b+1], basis)
+		}
+	}
+	return secret, nil
+}
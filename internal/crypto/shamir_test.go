@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShamirRoundTrip(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	for _, tt := range []struct {
+		shares    int
+		threshold int
+	}{
+		{2, 2},
+		{3, 2},
+		{5, 3},
+		{10, 7},
+		{SHAMIR_MAX_SHARES, 16},
+	} {
+		shares, err := ShamirSplit(secret, tt.shares, tt.threshold)
+		if err != nil {
+			t.Fatalf("split %d of %d: %v", tt.threshold, tt.shares, err)
+		}
+		if len(shares) != tt.shares {
+			t.Fatalf("split %d of %d: got %d shares", tt.threshold, tt.shares, len(shares))
+		}
+
+		// Any threshold of the shares rebuild the secret, in any order
+		for _, subset := range [][][]byte{
+			shares[:tt.threshold],
+			shares[len(shares)-tt.threshold:],
+			reversed(shares)[:tt.threshold],
+		} {
+			combined, err := ShamirCombine(subset)
+			if err != nil {
+				t.Fatalf("combine %d of %d: %v", tt.threshold, tt.shares, err)
+			}
+			if !bytes.Equal(combined, secret) {
+				t.Errorf("combine %d of %d: got %x, want %x", tt.threshold, tt.shares, combined, secret)
+			}
+		}
+
+		// Fewer shares give something else
+		combined, err := ShamirCombine(shares[:tt.threshold-1])
+		if err == nil && bytes.Equal(combined, secret) {
+			t.Errorf("combine %d of %d: rebuilt from %d shares", tt.threshold, tt.shares, tt.threshold-1)
+		}
+	}
+}
+
+func TestShamirBadParameters(t *testing.T) {
+	for _, tt := range []struct {
+		secret    []byte
+		shares    int
+		threshold int
+	}{
+		{[]byte("s"), 3, 1},
+		{[]byte("s"), 2, 3},
+		{[]byte("s"), SHAMIR_MAX_SHARES + 1, 2},
+		{nil, 3, 2},
+	} {
+		if _, err := ShamirSplit(tt.secret, tt.shares, tt.threshold); err != ErrorShamirParameters {
+			t.Errorf("split %d of %d of %q: got %v, want %v", tt.threshold, tt.shares, tt.secret, err, ErrorShamirParameters)
+		}
+	}
+}
+
+func TestShamirBadShares(t *testing.T) {
+	shares, err := ShamirSplit([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, subset := range map[string][][]byte{
+		"duplicate":  {shares[0], shares[0]},
+		"length":     {shares[0], shares[1][:3]},
+		"zero x":     {append([]byte{0}, shares[0][1:]...), shares[1]},
+		"too little": {shares[0]},
+	} {
+		if _, err := ShamirCombine(subset); err == nil {
+			t.Errorf("%s: combined", name)
+		}
+	}
+}
+
+func reversed(shares [][]byte) [][]byte {
+	result := make([][]byte, len(shares))
+	for i, share := range shares {
+		result[len(shares)-1-i] = share
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+package delegation
+
+import (
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/email/user"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"strings"
+	"time"
+)
+
+/* A fetch delegation lets another key fetch the messages of one link on
+ * behalf of the reader, typically the fetch key of the reader's own server:
+ *
+ *	Fetch-Delegation: link=<link>; fetch-key=<base64 public signing key>;
+ *		expires=<RFC3339>; key=<base64 reader public signing key>; signature=<base64>
+ *
+ * The reader signs the statement below with its signing key. The author's
+ * server accepts it only for reading messages of the link, and only from
+ * requests signed by the fetch key, until the delegation expires:
+ *
+ *	openemail-fetch-v1:<link>:<fetch key fingerprint>:<expires>
+ ************************************************************************/
+
+const DELEGATION_STATEMENT_PREFIX = "openemail-fetch-v1"
+const DELEGATION_STATEMENT_SEPARATOR = ":"
+
+const ATTRIBUTE_LINK = "link"
+const ATTRIBUTE_FETCH_KEY = "fetch-key"
+const ATTRIBUTE_EXPIRES = "expires"
+const ATTRIBUTE_KEY = "key"
+const ATTRIBUTE_SIGNATURE = "signature"
+
+// Readers renew delegations, the longest one lasts a year
+const MAX_DELEGATION_TIME = 365 * 24 * time.Hour
+
+var ErrorBadDelegation = errors.New("bad fetch delegation")
+var ErrorDelegationExpired = errors.New("fetch delegation expired")
+var ErrorDelegationScope = errors.New("fetch delegation is not for this link or key")
+
+type Delegation struct {
+	Link      string
+	FetchKey  string
+	Expires   string
+	ReaderKey string
+	Signature string
+}
+
+func statement(link, fetchKeyFingerprint, expires string) []byte {
+	return []byte(strings.Join([]string{
+		DELEGATION_STATEMENT_PREFIX,
+		strings.ToLower(link),
+		strings.ToLower(fetchKeyFingerprint),
+		expires,
+	}, DELEGATION_STATEMENT_SEPARATOR))
+}
+
+// Sign delegates fetching the messages of the link to the fetch key.
+func Sign(reader *user.User, link, fetchKeyBase64 string, expires time.Time) (*Delegation, error) {
+	fetchKey, err := crypto.DecodeBase64Key32(fetchKeyBase64)
+	if err != nil {
+		return nil, err
+	}
+	d := &Delegation{
+		Link:      strings.ToLower(link),
+		FetchKey:  fetchKeyBase64,
+		Expires:   utils.ToRFC3339String(expires.UTC()),
+		ReaderKey: reader.PublicSigningKeyBase64,
+	}
+	d.Signature, err = reader.SignData(statement(d.Link, crypto.Fingerprint(fetchKey[:]), d.Expires))
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Verify checks the delegation covers the link for the fetch key with the
+// given fingerprint and is signed by the reader. The fingerprint of the
+// reader signing key is returned, standing in for the fetch key.
+func (d *Delegation) Verify(link, fetchKeyFingerprint string) (string, error) {
+	fetchKey, err := crypto.DecodeBase64Key32(d.FetchKey)
+	if err != nil {
+		return "", ErrorBadDelegation
+	}
+	if !strings.EqualFold(d.Link, link) || crypto.Fingerprint(fetchKey[:]) != fetchKeyFingerprint {
+		return "", ErrorDelegationScope
+	}
+	expires, err := utils.ParseRFC3339Time(d.Expires)
+	if err != nil {
+		return "", ErrorBadDelegation
+	}
+	now := time.Now()
+	if !expires.After(now) {
+		return "", ErrorDelegationExpired
+	}
+	if expires.After(now.Add(MAX_DELEGATION_TIME)) {
+		return "", ErrorBadDelegation
+	}
+	readerKey, err := crypto.DecodeBase64Key32(d.ReaderKey)
+	if err != nil {
+		return "", ErrorBadDelegation
+	}
+	if !crypto.VerifySignature(readerKey, d.Signature, statement(d.Link, fetchKeyFingerprint, d.Expires)) {
+		return "", ErrorBadDelegation
+	}
+	return crypto.Fingerprint(readerKey[:]), nil
+}
+
+func (d *Delegation) ToHeader() string {
+	return strings.Join([]string{
+		ATTRIBUTE_LINK + "=" + d.Link,
+		ATTRIBUTE_FETCH_KEY + "=" + d.FetchKey,
+		ATTRIBUTE_EXPIRES + "=" + d.Expires,
+		ATTRIBUTE_KEY + "=" + d.ReaderKey,
+		ATTRIBUTE_SIGNATURE + "=" + d.Signature,
+	}, "; ")
+}
+
+func FromHeader(value string) (*Delegation, error) {
+	attrs := utils.ParseHeadersAttributes(value)
+	d := &Delegation{
+		Link:      attrs[ATTRIBUTE_LINK],
+		FetchKey:  attrs[ATTRIBUTE_FETCH_KEY],
+		Expires:   attrs[ATTRIBUTE_EXPIRES],
+		ReaderKey: attrs[ATTRIBUTE_KEY],
+		Signature: attrs[ATTRIBUTE_SIGNATURE],
+	}
+	if d.Link == "" || d.FetchKey == "" || d.Expires == "" || d.ReaderKey == "" || d.Signature == "" {
+		return nil, ErrorBadDelegation
+	}
+	return d, nil
+}
@@ -0,0 +1,223 @@
+package domains
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Domains hosted with settings of their own have a directory each in the
+ * configuration directory given to the server, named after the domain:
+ *
+ *	<domains-dir>/<domain>/domain.conf
+ *	<domains-dir>/<domain>/provisioning    policy, as in internal/provisioning
+ *	<domains-dir>/<domain>/cert.pem        certificate and key, if not named
+ *	<domains-dir>/<domain>/key.pem
+ *
+ * All fields of domain.conf are optional:
+ *
+ *	Hostnames: mail.example.com mail2.example.com
+ *	TLS-Cert: /etc/letsencrypt/live/example.com/fullchain.pem
+ *	TLS-Key: /etc/letsencrypt/live/example.com/privkey.pem
+ *	Max-Message-Size: 104857600
+ *	Max-Account-Size: 1073741824
+ *	Retention-Days: 365
+ *	Notification-TTL-Days: 30
+ *
+ * Hostnames are listed in the mail.txt of the domain, the server's own
+ * when none are given. Relative paths are taken from the directory of the
+ * domain. Zero sizes and days leave the server defaults in place.
+ *************************************************************************/
+
+const CONFIG_FILENAME = "domain.conf"
+const POLICY_FILENAME = "provisioning"
+const DEFAULT_TLS_CERT_FILENAME = "cert.pem"
+const DEFAULT_TLS_KEY_FILENAME = "key.pem"
+
+const FIELD_HOSTNAMES = "Hostnames"
+const FIELD_TLS_CERT = "TLS-Cert"
+const FIELD_TLS_KEY = "TLS-Key"
+const FIELD_MAX_MESSAGE_SIZE = "Max-Message-Size"
+const FIELD_MAX_ACCOUNT_SIZE = "Max-Account-Size"
+const FIELD_RETENTION_DAYS = "Retention-Days"
+const FIELD_NOTIFICATION_TTL_DAYS = "Notification-TTL-Days"
+
+var ErrorBadConfig = errors.New("bad domain configuration")
+
+type Config struct {
+	Name      string
+	Hostnames []string
+
+	// Provisioning policy of the domain, empty if it has none here
+	PolicyPath string
+
+	TLSCertPath string
+	TLSKeyPath  string
+	Certificate *tls.Certificate
+
+	MaxMessageSize      int64
+	MaxAccountSize      int64
+	RetentionDays       int
+	NotificationTTLDays int
+}
+
+// Set holds the configured domains by name.
+type Set struct {
+	domains map[string]*Config
+}
+
+// Load reads the configuration of every domain in the directory. An empty
+// path gives an empty set.
+func Load(dirPath string) (*Set, error) {
+	s := &Set{domains: make(map[string]*Config)}
+	if dirPath == "" {
+		return s, nil
+	}
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if !entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		c, err := loadConfig(filepath.Join(dirPath, entry.Name()), name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.domains[name] = c
+	}
+	return s, nil
+}
+
+func loadConfig(domainDirPath, name string) (*Config, error) {
+	c := &Config{Name: name}
+	data, err := os.ReadFile(filepath.Join(domainDirPath, CONFIG_FILENAME))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, ErrorBadConfig
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case FIELD_HOSTNAMES:
+			c.Hostnames = strings.Fields(strings.ToLower(value))
+		case FIELD_TLS_CERT:
+			c.TLSCertPath = value
+		case FIELD_TLS_KEY:
+			c.TLSKeyPath = value
+		case FIELD_MAX_MESSAGE_SIZE:
+			c.MaxMessageSize, err = strconv.ParseInt(value, 10, 64)
+		case FIELD_MAX_ACCOUNT_SIZE:
+			c.MaxAccountSize, err = strconv.ParseInt(value, 10, 64)
+		case FIELD_RETENTION_DAYS:
+			c.RetentionDays, err = strconv.Atoi(value)
+		case FIELD_NOTIFICATION_TTL_DAYS:
+			c.NotificationTTLDays, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return nil, ErrorBadConfig
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if c.MaxMessageSize < 0 || c.MaxAccountSize < 0 || c.RetentionDays < 0 || c.NotificationTTLDays < 0 {
+		return nil, ErrorBadConfig
+	}
+
+	policyPath := filepath.Join(domainDirPath, POLICY_FILENAME)
+	if exists, err := utils.FilePathExists(policyPath); err != nil {
+		return nil, err
+	} else if exists {
+		c.PolicyPath = policyPath
+	}
+
+	err = c.loadCertificate(domainDirPath)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadCertificate loads the named certificate, or the one in the domain
+// directory if there is one.
+func (c *Config) loadCertificate(domainDirPath string) error {
+	if c.TLSCertPath == "" && c.TLSKeyPath == "" {
+		certPath := filepath.Join(domainDirPath, DEFAULT_TLS_CERT_FILENAME)
+		keyPath := filepath.Join(domainDirPath, DEFAULT_TLS_KEY_FILENAME)
+		certExists, err := utils.FilePathExists(certPath)
+		if err != nil {
+			return err
+		}
+		keyExists, err := utils.FilePathExists(keyPath)
+		if err != nil {
+			return err
+		}
+		if !certExists || !keyExists {
+			return nil
+		}
+		c.TLSCertPath, c.TLSKeyPath = certPath, keyPath
+	}
+	if c.TLSCertPath == "" || c.TLSKeyPath == "" {
+		return ErrorBadConfig
+	}
+	if !filepath.IsAbs(c.TLSCertPath) {
+		c.TLSCertPath = filepath.Join(domainDirPath, c.TLSCertPath)
+	}
+	if !filepath.IsAbs(c.TLSKeyPath) {
+		c.TLSKeyPath = filepath.Join(domainDirPath, c.TLSKeyPath)
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertPath, c.TLSKeyPath)
+	if err != nil {
+		return err
+	}
+	c.Certificate = &cert
+	return nil
+}
+
+// Get returns the configuration of the domain, nil if it has none.
+func (s *Set) Get(domain string) *Config {
+	return s.domains[strings.ToLower(domain)]
+}
+
+// ForHost returns the configuration of the domain named by the host, or of
+// the domain listing the host among its hostnames.
+func (s *Set) ForHost(host string) *Config {
+	host = strings.ToLower(host)
+	if c, ok := s.domains[host]; ok {
+		return c
+	}
+	for _, c := range s.domains {
+		if utils.ListContains(c.Hostnames, host) {
+			return c
+		}
+	}
+	return nil
+}
+
+// GetCertificate picks the certificate of the domain the client asks for,
+// leaving others to the default certificates of the server.
+func (s *Set) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c := s.ForHost(hello.ServerName); c != nil && c.Certificate != nil {
+		return c.Certificate, nil
+	}
+	return nil, nil
+}
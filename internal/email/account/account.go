@@ -0,0 +1,92 @@
+package account
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* A suspended account keeps its data but is served to nobody. The
+ * suspension is kept in <home>/.suspended:
+ *
+ *	Suspended: <RFC3339 timestamp>
+ *	Reason: <free text shown to callers>
+ *********************************************************************/
+
+const SUSPENSION_FILENAME = ".suspended"
+
+const FIELD_SUSPENDED = "Suspended"
+const FIELD_REASON = "Reason"
+
+const MAX_REASON_LENGTH = 256
+
+var ErrorNotSuspended = errors.New("account is not suspended")
+
+type Suspension struct {
+	Since  string
+	Reason string
+}
+
+func suspensionPath(homeDirPath string) string {
+	return filepath.Join(homeDirPath, SUSPENSION_FILENAME)
+}
+
+// Suspend suspends the account, or updates the reason of a suspended one.
+func Suspend(homeDirPath, reason string) error {
+	reason = strings.Join(strings.Fields(reason), " ")
+	if len(reason) > MAX_REASON_LENGTH {
+		reason = reason[:MAX_REASON_LENGTH]
+	}
+	since := utils.ToRFC3339String(utils.TimestampNow())
+	if s, err := Suspended(homeDirPath); err == nil && s != nil {
+		since = s.Since
+	}
+	lines := []string{
+		FIELD_SUSPENDED + ": " + since,
+		FIELD_REASON + ": " + reason,
+	}
+	path := suspensionPath(homeDirPath)
+	err := os.WriteFile(path+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func Unsuspend(homeDirPath string) error {
+	err := os.Remove(suspensionPath(homeDirPath))
+	if err != nil && os.IsNotExist(err) {
+		return ErrorNotSuspended
+	}
+	return err
+}
+
+// Suspended returns the suspension of the account, nil if it is active.
+func Suspended(homeDirPath string) (*Suspension, error) {
+	data, err := os.ReadFile(suspensionPath(homeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	s := Suspension{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case FIELD_SUSPENDED:
+			s.Since = strings.TrimSpace(parts[1])
+		case FIELD_REASON:
+			s.Reason = strings.TrimSpace(parts[1])
+		}
+	}
+	return &s, scanner.Err()
+}
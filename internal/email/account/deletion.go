@@ -0,0 +1,249 @@
+package account
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/user"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Owners delete their own account with a confirmation signed over the
+ * address and the time of the request:
+ *
+ *	Account-Deletion: address=<address>; timestamp=<unix seconds>;
+ *		signature=<base64 signature>
+ *
+ * of the statement
+ *
+ *	openemail-delete-v1:<address>:<timestamp>
+ *
+ * Servers with a grace period keep the account until it has passed, in
+ * <home>/.deletion, and the owner may cancel in the meantime:
+ *
+ *	Requested: <RFC3339 timestamp>
+ *	Erase-After: <RFC3339 timestamp>
+ *********************************************************************/
+
+const DELETION_STATEMENT_PREFIX = "openemail-delete-v1"
+const DELETION_STATEMENT_SEPARATOR = ":"
+
+const ATTRIBUTE_ADDRESS = "address"
+const ATTRIBUTE_TIMESTAMP = "timestamp"
+const ATTRIBUTE_SIGNATURE = "signature"
+
+const DELETION_FILENAME = ".deletion"
+
+const FIELD_REQUESTED = "Requested"
+const FIELD_ERASE_AFTER = "Erase-After"
+
+// Confirmations are good for this long either side of the server's clock
+const MAX_CONFIRMATION_SKEW = 10 * time.Minute
+
+var ErrorBadConfirmation = errors.New("bad deletion confirmation")
+var ErrorStaleConfirmation = errors.New("deletion confirmation is too old or too new")
+var ErrorNoDeletion = errors.New("no deletion is pending")
+
+type Confirmation struct {
+	Address   string
+	Timestamp string
+	Signature string
+}
+
+type Deletion struct {
+	Requested  string
+	EraseAfter string
+}
+
+func deletionStatement(accountAddress, timestamp string) []byte {
+	return []byte(strings.Join([]string{
+		DELETION_STATEMENT_PREFIX,
+		strings.ToLower(accountAddress),
+		timestamp,
+	}, DELETION_STATEMENT_SEPARATOR))
+}
+
+// Confirm signs the deletion of the owner's account now.
+func Confirm(owner *user.User) (*Confirmation, error) {
+	c := &Confirmation{
+		Address:   strings.ToLower(owner.Address),
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	var err error
+	c.Signature, err = owner.SignData(deletionStatement(c.Address, c.Timestamp))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Verify checks the confirmation is recent and signed with the key for the
+// account with the address.
+func (c *Confirmation) Verify(accountAddress string, signingKey [32]byte) error {
+	if !strings.EqualFold(c.Address, accountAddress) {
+		return ErrorBadConfirmation
+	}
+	seconds, err := strconv.ParseInt(c.Timestamp, 10, 64)
+	if err != nil {
+		return ErrorBadConfirmation
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > MAX_CONFIRMATION_SKEW || skew < -MAX_CONFIRMATION_SKEW {
+		return ErrorStaleConfirmation
+	}
+	if !crypto.VerifySignature(signingKey, c.Signature, deletionStatement(c.Address, c.Timestamp)) {
+		return ErrorBadConfirmation
+	}
+	return nil
+}
+
+func (c *Confirmation) ToHeader() string {
+	return strings.Join([]string{
+		ATTRIBUTE_ADDRESS + "=" + c.Address,
+		ATTRIBUTE_TIMESTAMP + "=" + c.Timestamp,
+		ATTRIBUTE_SIGNATURE + "=" + c.Signature,
+	}, "; ")
+}
+
+func ConfirmationFromHeader(value string) (*Confirmation, error) {
+	attrs := utils.ParseHeadersAttributes(value)
+	c := &Confirmation{
+		Address:   attrs[ATTRIBUTE_ADDRESS],
+		Timestamp: attrs[ATTRIBUTE_TIMESTAMP],
+		Signature: attrs[ATTRIBUTE_SIGNATURE],
+	}
+	if c.Address == "" || c.Timestamp == "" || c.Signature == "" {
+		return nil, ErrorBadConfirmation
+	}
+	return c, nil
+}
+
+func deletionPath(homeDirPath string) string {
+	return filepath.Join(homeDirPath, DELETION_FILENAME)
+}
+
+// ScheduleDeletion marks the account for erasure once the grace period has
+// passed. A pending deletion keeps its original schedule.
+func ScheduleDeletion(homeDirPath string, grace time.Duration) (*Deletion, error) {
+	d, err := PendingDeletion(homeDirPath)
+	if err != nil || d != nil {
+		return d, err
+	}
+	now := utils.TimestampNow()
+	d = &Deletion{
+		Requested:  utils.ToRFC3339String(now),
+		EraseAfter: utils.ToRFC3339String(now.Add(grace)),
+	}
+	path := deletionPath(homeDirPath)
+	err = os.WriteFile(path+".tmp", []byte(strings.Join(d.ToLines(), "\n")+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return d, os.Rename(path+".tmp", path)
+}
+
+func CancelDeletion(homeDirPath string) error {
+	err := os.Remove(deletionPath(homeDirPath))
+	if err != nil && os.IsNotExist(err) {
+		return ErrorNoDeletion
+	}
+	return err
+}
+
+// PendingDeletion returns the scheduled deletion of the account, nil if
+// there is none.
+func PendingDeletion(homeDirPath string) (*Deletion, error) {
+	data, err := os.ReadFile(deletionPath(homeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	d := Deletion{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case FIELD_REQUESTED:
+			d.Requested = strings.TrimSpace(parts[1])
+		case FIELD_ERASE_AFTER:
+			d.EraseAfter = strings.TrimSpace(parts[1])
+		}
+	}
+	return &d, scanner.Err()
+}
+
+func (d *Deletion) ToLines() []string {
+	return []string{
+		FIELD_REQUESTED + ": " + d.Requested,
+		FIELD_ERASE_AFTER + ": " + d.EraseAfter,
+	}
+}
+
+// Due tells whether the grace period of the deletion has passed.
+func (d *Deletion) Due(now time.Time) bool {
+	eraseAfter, err := utils.ParseRFC3339Time(d.EraseAfter)
+	return err == nil && !eraseAfter.After(now)
+}
+
+// Erase removes the home directory of the account with its messages,
+// links, notifications, nonces and profile, and leaves a tombstone.
+func Erase(homeDirPath string) error {
+	err := os.RemoveAll(homeDirPath)
+	if err != nil {
+		return err
+	}
+	return Bury(filepath.Dir(homeDirPath), filepath.Base(homeDirPath))
+}
+
+// EraseDue erases the accounts in the data directory whose deletion is due
+// and returns their addresses. An account that cannot be checked or erased
+// is passed to failed, with the domain for a domain that cannot be listed,
+// and the others are gone through still.
+func EraseDue(dataDirPath string, failed func(string, error)) ([]string, error) {
+	var erased []string
+	domains, err := utils.ListDirectories(dataDirPath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, ".") {
+			continue
+		}
+		users, err := utils.ListDirectories(filepath.Join(dataDirPath, domain))
+		if err != nil {
+			failed(domain, err)
+			continue
+		}
+		for _, localPart := range users {
+			homeDirPath := filepath.Join(dataDirPath, domain, localPart)
+			d, err := PendingDeletion(homeDirPath)
+			if err != nil {
+				failed(address.JoinAddress(domain, localPart), err)
+				continue
+			}
+			if d == nil || !d.Due(now) {
+				continue
+			}
+			err = Erase(homeDirPath)
+			if err != nil {
+				failed(address.JoinAddress(domain, localPart), err)
+				continue
+			}
+			erased = append(erased, address.JoinAddress(domain, localPart))
+		}
+	}
+	return erased, nil
+}
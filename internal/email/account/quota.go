@@ -0,0 +1,73 @@
+package account
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* Accounts may be held to less storage than the server allows by a quota
+ * in <home>/.quota:
+ *
+ *	Max-Size: <bytes>
+ *********************************************************************/
+
+const QUOTA_FILENAME = ".quota"
+
+const FIELD_MAX_SIZE = "Max-Size"
+
+var ErrorBadQuota = errors.New("bad quota")
+
+func quotaPath(homeDirPath string) string {
+	return filepath.Join(homeDirPath, QUOTA_FILENAME)
+}
+
+// Quota returns the most bytes the account may store, zero if it has no
+// quota of its own.
+func Quota(homeDirPath string) (int64, error) {
+	data, err := os.ReadFile(quotaPath(homeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != FIELD_MAX_SIZE {
+			continue
+		}
+		maxSize, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+		if err != nil || maxSize < 0 {
+			return 0, ErrorBadQuota
+		}
+		return maxSize, nil
+	}
+	return 0, scanner.Err()
+}
+
+// SetQuota holds the account to the bytes, up to the limit of the server.
+// Zero removes the quota.
+func SetQuota(homeDirPath string, maxSize, serverLimit int64) error {
+	if maxSize < 0 || maxSize > serverLimit {
+		return ErrorBadQuota
+	}
+	path := quotaPath(homeDirPath)
+	if maxSize == 0 {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	err := os.WriteFile(path+".tmp", []byte(FIELD_MAX_SIZE+": "+strconv.FormatInt(maxSize, 10)+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,71 @@
+package account
+
+import (
+	"email.mercata.com/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Deleted accounts leave a tombstone in <data-dir>/<domain>/.tombstones,
+ * one per line:
+ *
+ *	local-part,deleted
+ *
+ * so that their names are not provisioned to someone else while contacts
+ * may still be reaching for them. How long a tombstone holds is up to the
+ * server, the file only records when the account went away.
+ *********************************************************************/
+
+const TOMBSTONES_FILENAME = ".tombstones"
+const TOMBSTONES_COLUMN_SEPARATOR = ","
+
+var tombstonesMutex sync.Mutex
+
+func tombstonesPath(domainPath string) string {
+	return filepath.Join(domainPath, TOMBSTONES_FILENAME)
+}
+
+// Bury records the deletion of the account with the local part now.
+func Bury(domainPath, localPart string) error {
+	tombstonesMutex.Lock()
+	defer tombstonesMutex.Unlock()
+
+	line := localPart + TOMBSTONES_COLUMN_SEPARATOR + utils.ToRFC3339String(utils.TimestampNow())
+	return utils.AppendStringToFile(line+"\n", tombstonesPath(domainPath))
+}
+
+// BuriedUntil returns until when the local part may not be provisioned,
+// the zero time if it is free. The latest deletion counts.
+func BuriedUntil(domainPath, localPart string, period time.Duration) (time.Time, error) {
+	tombstonesMutex.Lock()
+	defer tombstonesMutex.Unlock()
+
+	var until time.Time
+	data, err := os.ReadFile(tombstonesPath(domainPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return until, nil
+		}
+		return until, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), TOMBSTONES_COLUMN_SEPARATOR)
+		if len(parts) != 2 || parts[0] != localPart {
+			continue
+		}
+		deleted, err := utils.ParseRFC3339Time(parts[1])
+		if err != nil {
+			continue
+		}
+		if end := deleted.Add(period); end.After(until) {
+			until = end
+		}
+	}
+	if !until.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return until, nil
+}
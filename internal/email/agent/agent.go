@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"email.mercata.com/internal/email/storage"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+/* The key agent keeps unlocked private keys in memory and performs the
+ * private key operations on behalf of commands, over a Unix socket. Raw
+ * private keys never leave the agent process.
+ *
+ * The protocol is one JSON request and one JSON response per line.
+ ************************************************************************/
+
+const AGENT_SOCKET_FILENAME = "agent.sock"
+const AGENT_SOCKET_ENVIRONMENT_VARIABLE = "EMAIL2_AGENT_SOCK"
+
+const OPERATION_LIST = "list"
+const OPERATION_SIGN = "sign"
+const OPERATION_DECRYPT_ANONYMOUS = "decrypt-anonymous"
+
+var ErrorAgentUnavailable = errors.New("key agent unavailable")
+var ErrorAccountLocked = errors.New("account not unlocked in key agent")
+var ErrorUnknownOperation = errors.New("unknown key agent operation")
+
+type request struct {
+	Operation  string `json:"operation"`
+	Account    string `json:"account,omitempty"`
+	Data       []byte `json:"data,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type response struct {
+	Error     string   `json:"error,omitempty"`
+	Accounts  []string `json:"accounts,omitempty"`
+	Signature string   `json:"signature,omitempty"`
+	Plaintext []byte   `json:"plaintext,omitempty"`
+}
+
+func SocketPath() (string, error) {
+	if socketPath := os.Getenv(AGENT_SOCKET_ENVIRONMENT_VARIABLE); socketPath != "" {
+		return socketPath, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, storage.LOCAL_EMAIL_DIRECTORY, AGENT_SOCKET_FILENAME), nil
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const AGENT_DIAL_TIMEOUT = 2 * time.Second
+
+type Client struct {
+	mutex   sync.Mutex
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+// Dial connects to the running key agent, if any.
+func Dial() (*Client, error) {
+	socketPath, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", socketPath, AGENT_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, ErrorAgentUnavailable
+	}
+	return &Client{conn: conn, scanner: bufio.NewScanner(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) Accounts() ([]string, error) {
+	res, err := c.call(&request{Operation: OPERATION_LIST})
+	if err != nil {
+		return nil, err
+	}
+	return res.Accounts, nil
+}
+
+func (c *Client) HasAccount(emailAddress string) bool {
+	accounts, err := c.Accounts()
+	if err != nil {
+		return false
+	}
+	for _, account := range accounts {
+		if account == emailAddress {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) SignData(emailAddress string, data []byte) (string, error) {
+	res, err := c.call(&request{Operation: OPERATION_SIGN, Account: emailAddress, Data: data})
+	if err != nil {
+		return "", err
+	}
+	return res.Signature, nil
+}
+
+func (c *Client) DecryptAnonymous(emailAddress, ciphertext string) ([]byte, error) {
+	res, err := c.call(&request{Operation: OPERATION_DECRYPT_ANONYMOUS, Account: emailAddress, Ciphertext: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	return res.Plaintext, nil
+}
+
+func (c *Client) call(req *request) (*response, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrorAgentUnavailable
+	}
+
+	var res response
+	if err := json.Unmarshal(c.scanner.Bytes(), &res); err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	return &res, nil
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"bufio"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/keys"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+)
+
+type identity struct {
+	PublicEncryptionKey  [32]byte
+	PrivateEncryptionKey [32]byte
+	PublicSigningKey     [32]byte
+	PrivateSigningKey    [64]byte
+}
+
+type Agent struct {
+	mutex      sync.RWMutex
+	identities map[string]*identity
+}
+
+func New() *Agent {
+	return &Agent{identities: make(map[string]*identity)}
+}
+
+// Unlock loads the private keys of the account from disk, asking for the
+// passphrase through keys.PassphraseProvider when they are protected.
+func (a *Agent) Unlock(emailAddress string) error {
+	if !address.ValidEmailAddress(emailAddress) {
+		return errors.New("malformed email address")
+	}
+	safeAddress, _, _ := address.ParseEmailAddress(emailAddress)
+
+	var id identity
+	var err error
+	_, id.PrivateEncryptionKey, err = keys.GetLocalEncryptionPrivateKey(safeAddress)
+	if err != nil {
+		return err
+	}
+	_, id.PublicEncryptionKey, err = keys.GetLocalEncryptionPublicKey(safeAddress)
+	if err != nil {
+		return err
+	}
+	_, id.PrivateSigningKey, err = keys.GetLocalSigningPrivateKey(safeAddress)
+	if err != nil {
+		return err
+	}
+	_, id.PublicSigningKey, err = keys.GetLocalSigningPublicKey(safeAddress)
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	a.identities[safeAddress] = &id
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *Agent) Accounts() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var accounts []string
+	for account := range a.identities {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// Listen creates the agent socket, accessible to the owner only.
+func Listen(socketPath string) (net.Listener, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		// A stale socket is left behind by an agent that did not exit cleanly
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, errors.New("key agent already running at " + socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (a *Agent) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.handleConnection(conn)
+	}
+}
+
+func (a *Agent) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req request
+		var res response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			res.Error = err.Error()
+		} else {
+			res = a.handle(&req)
+		}
+		if err := encoder.Encode(&res); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) handle(req *request) response {
+	if req.Operation == OPERATION_LIST {
+		return response{Accounts: a.Accounts()}
+	}
+
+	a.mutex.RLock()
+	id, unlocked := a.identities[req.Account]
+	a.mutex.RUnlock()
+	if !unlocked {
+		return response{Error: ErrorAccountLocked.Error()}
+	}
+
+	switch req.Operation {
+	case OPERATION_SIGN:
+		return response{Signature: crypto.SignData(id.PublicSigningKey, id.PrivateSigningKey, req.Data)}
+
+	case OPERATION_DECRYPT_ANONYMOUS:
+		plaintext, err := crypto.DecryptAnonymous(id.PrivateEncryptionKey, id.PublicEncryptionKey, req.Ciphertext)
+		if err != nil {
+			return response{Error: err.Error()}
+		}
+		return response{Plaintext: plaintext}
+	}
+	return response{Error: ErrorUnknownOperation.Error()}
+}
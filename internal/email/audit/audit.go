@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"email.mercata.com/internal/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Security-relevant actions on an account, appended one per line to
+ * <home>/audit, which is never rewritten:
+ *
+ *	time,action,subject,request id,remote address,actor
+ *
+ * The subject is what the action was on, such as the link or message ID.
+ * The actor is the signing key fingerprint of the owner, or the admin by
+ * name. The request ID matches the entries of the server log.
+ ***************************************************************************/
+
+const AUDIT_FILENAME = "audit"
+const AUDIT_COLUMN_SEPARATOR = ","
+const AUDIT_COLUMNS = 6
+
+const ACTION_PROVISIONED = "provisioned"
+const ACTION_PROFILE_CHANGED = "profile_changed"
+const ACTION_PROFILE_IMAGE_CHANGED = "profile_image_changed"
+const ACTION_LINK_STORED = "link_stored"
+const ACTION_LINK_DELETED = "link_deleted"
+const ACTION_BLOCK_ADDED = "block_added"
+const ACTION_BLOCK_REMOVED = "block_removed"
+const ACTION_MESSAGE_STORED = "message_stored"
+const ACTION_MESSAGE_DELETED = "message_deleted"
+const ACTION_DELETION_REQUESTED = "deletion_requested"
+const ACTION_DELETION_CANCELLED = "deletion_cancelled"
+const ACTION_FETCH_KEY_CREATED = "fetch_key_created"
+const ACTION_FETCH_DELEGATION_STORED = "fetch_delegation_stored"
+const ACTION_FETCH_DELEGATION_DELETED = "fetch_delegation_deleted"
+const ACTION_WEBHOOK_CREATED = "webhook_created"
+const ACTION_WEBHOOK_DELETED = "webhook_deleted"
+const ACTION_QUOTA_SET = "quota_set"
+const ACTION_SUSPENDED = "suspended"
+const ACTION_UNSUSPENDED = "unsuspended"
+const ACTION_NOTIFICATIONS_PURGED = "notifications_purged"
+
+var ErrorBadEntry = errors.New("bad audit entry")
+
+var auditMutex sync.Mutex
+
+type Entry struct {
+	Time      time.Time
+	Action    string
+	Subject   string
+	RequestID string
+	Remote    string
+	Actor     string
+}
+
+func AuditPath(userHomeDirPath string) string {
+	return filepath.Join(userHomeDirPath, AUDIT_FILENAME)
+}
+
+func (e *Entry) ToLine() string {
+	// Separators and newlines would break the columns
+	clean := strings.NewReplacer(AUDIT_COLUMN_SEPARATOR, " ", "\n", " ", "\r", " ")
+	return strings.Join([]string{
+		utils.ToRFC3339String(e.Time),
+		clean.Replace(e.Action),
+		clean.Replace(e.Subject),
+		clean.Replace(e.RequestID),
+		clean.Replace(e.Remote),
+		clean.Replace(e.Actor),
+	}, AUDIT_COLUMN_SEPARATOR)
+}
+
+func ParseLine(line string) (*Entry, error) {
+	columns := strings.Split(line, AUDIT_COLUMN_SEPARATOR)
+	if len(columns) != AUDIT_COLUMNS {
+		return nil, ErrorBadEntry
+	}
+	t, err := utils.ParseRFC3339Time(columns[0])
+	if err != nil {
+		return nil, ErrorBadEntry
+	}
+	return &Entry{
+		Time:      *t,
+		Action:    columns[1],
+		Subject:   columns[2],
+		RequestID: columns[3],
+		Remote:    columns[4],
+		Actor:     columns[5],
+	}, nil
+}
+
+// Append adds the entry to the end of the trail of the account
+func Append(userHomeDirPath string, e *Entry) error {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	file, err := os.OpenFile(AuditPath(userHomeDirPath), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(e.ToLine() + "\n")
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Entries returns the entries of the trail since the time, of the action
+// if one is given, oldest first. Lines not understood are left out.
+func Entries(userHomeDirPath string, since time.Time, action string) ([]*Entry, error) {
+	var entries []*Entry
+	data, err := os.ReadFile(AuditPath(userHomeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		e, err := ParseLine(line)
+		if err != nil {
+			continue
+		}
+		if e.Time.Before(since) || (action != "" && e.Action != action) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
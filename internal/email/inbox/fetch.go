@@ -0,0 +1,294 @@
+package inbox
+
+import (
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/mca"
+	"email.mercata.com/internal/email/message"
+	"email.mercata.com/internal/nonce"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fetching a large payload takes longer than other outgoing requests
+const FETCH_TIMEOUT = 10 * time.Minute
+
+// Most messages listed by the author taken in one fetch, the rest wait for
+// the next notification
+const MAX_MESSAGES_PER_FETCH = 100
+
+const MAX_LIST_SIZE = 1024 * 1024
+
+var ErrorStaleDelegation = errors.New("fetch delegation is not for the current fetch key")
+var ErrorNoHosts = errors.New("no hosts of the author to fetch from")
+var ErrorFetchRunning = errors.New("fetch of the link is running already")
+var ErrorAccountFull = errors.New("message would take the account over its size limit")
+
+type Fetcher struct {
+	Client   *http.Client
+	ErrorLog *log.Logger
+	InfoLog  *log.Logger
+
+	// Largest payload stored in the home directory, DEFAULT_MAX_CONTENT_SIZE if not set
+	MaxSize func(homeDirPath string) int64
+	// Most bytes the home directory may hold, no limit if not set. Messages
+	// which would go over it are skipped.
+	MaxAccountSize func(homeDirPath string) (int64, error)
+
+	mutex   sync.Mutex
+	running map[string]bool
+}
+
+func NewFetcher(client *http.Client, infoLog, errorLog *log.Logger) *Fetcher {
+	client.Timeout = FETCH_TIMEOUT
+	return &Fetcher{
+		Client:   client,
+		InfoLog:  infoLog,
+		ErrorLog: errorLog,
+		running:  make(map[string]bool),
+	}
+}
+
+// Trigger fetches new messages of the link in the background, if the
+// account delegated fetching them.
+func (f *Fetcher) Trigger(homeDirPath, link string) {
+	if _, err := LoadDelegation(homeDirPath, link); err != nil {
+		return
+	}
+	go func() {
+		count, err := f.Fetch(homeDirPath, link)
+		if err != nil {
+			if !errors.Is(err, ErrorFetchRunning) {
+				f.ErrorLog.Printf("Inbox fetch of link %s for %s: %s", link, homeDirPath, err)
+			}
+			return
+		}
+		if count > 0 {
+			f.InfoLog.Printf("Inbox fetched %d messages of link %s for %s", count, link, homeDirPath)
+		}
+	}()
+}
+
+// Fetch stores the messages of the link not in the inbox yet and returns
+// how many were fetched. One fetch per link runs at a time.
+func (f *Fetcher) Fetch(homeDirPath, link string) (int, error) {
+	key := homeDirPath + "/" + link
+	f.mutex.Lock()
+	if f.running[key] {
+		f.mutex.Unlock()
+		return 0, ErrorFetchRunning
+	}
+	f.running[key] = true
+	f.mutex.Unlock()
+	defer func() {
+		f.mutex.Lock()
+		delete(f.running, key)
+		f.mutex.Unlock()
+	}()
+
+	record, err := LoadDelegation(homeDirPath, link)
+	if err != nil {
+		return 0, err
+	}
+	fetchKey, err := LoadFetchKey(homeDirPath)
+	if err != nil {
+		return 0, err
+	}
+	if record.Delegation.FetchKey != fetchKey.PublicKeyBase64 {
+		return 0, ErrorStaleDelegation
+	}
+	// The author would refuse it anyway
+	if _, err = record.Delegation.Verify(link, fetchKey.Fingerprint); err != nil {
+		return 0, err
+	}
+
+	_, authorDomain, authorLocalPart := address.ParseEmailAddress(record.Author)
+	hosts := record.Hosts
+	if len(hosts) == 0 {
+		hosts, err = mca.LookupEmailHosts(authorDomain, authorLocalPart)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(hosts) == 0 {
+		return 0, ErrorNoHosts
+	}
+
+	// The first host answering the list is taken as the author's
+	for _, host := range hosts {
+		if !strings.HasPrefix(host, "http") {
+			host = "https://" + host
+		}
+		base := fmt.Sprintf("%s/%s/%s/%s/link/%s/messages", host, consts.PUBLIC_API_PATH_PREFIX, authorDomain, authorLocalPart, link)
+		var messageIDs []string
+		messageIDs, err = f.list(base, record, fetchKey)
+		if err != nil {
+			continue
+		}
+		// Room left in the home directory, negative without a limit
+		room, err := f.room(homeDirPath)
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for _, messageID := range messageIDs {
+			if count == MAX_MESSAGES_PER_FETCH {
+				break
+			}
+			if !utils.ValidMessageID(messageID) || MessageExists(homeDirPath, link, messageID) {
+				continue
+			}
+			if room == 0 {
+				return count, ErrorAccountFull
+			}
+			written, err := f.get(base+"/"+messageID, homeDirPath, link, messageID, room, record, fetchKey)
+			if err != nil {
+				if errors.Is(err, ErrorAccountFull) {
+					f.ErrorLog.Printf("Inbox fetch of link %s for %s skipped message %s: %s", link, homeDirPath, messageID, err)
+					continue
+				}
+				return count, err
+			}
+			if room > 0 {
+				room -= written
+			}
+			count++
+		}
+		return count, nil
+	}
+	return 0, err
+}
+
+// room returns the bytes the home directory may still take, -1 if it has
+// no limit
+func (f *Fetcher) room(homeDirPath string) (int64, error) {
+	if f.MaxAccountSize == nil {
+		return -1, nil
+	}
+	maxAccountSize, err := f.MaxAccountSize(homeDirPath)
+	if err != nil {
+		return 0, err
+	}
+	homeDirSize, err := utils.DirectorySize(homeDirPath)
+	if err != nil {
+		return 0, err
+	}
+	if homeDirSize >= maxAccountSize {
+		return 0, nil
+	}
+	return maxAccountSize - homeDirSize, nil
+}
+
+func (f *Fetcher) request(uri string, record *Record, fetchKey *FetchKey) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	n, err := nonce.New(fetchKey.PublicKey, fetchKey.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(consts.AUTHORIZATION_HEADER_NONCE, nonce.ToHeader(n))
+	req.Header.Set(consts.FETCH_DELEGATION_HEADER, record.Delegation.ToHeader())
+
+	res, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("response code %d from %s", res.StatusCode, uri)
+	}
+	return res, nil
+}
+
+func (f *Fetcher) list(uri string, record *Record, fetchKey *FetchKey) ([]string, error) {
+	res, err := f.request(uri, record, fetchKey)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, MAX_LIST_SIZE))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(body)), nil
+}
+
+// get stores the message aside first, so that an interrupted fetch leaves
+// no partial message in the inbox. The message may take up to room bytes,
+// any if room is negative. It returns the bytes written.
+func (f *Fetcher) get(uri, homeDirPath, link, messageID string, room int64, record *Record, fetchKey *FetchKey) (int64, error) {
+	res, err := f.request(uri, record, fetchKey)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	envelope, err := message.MessageFromHeadersData(res.Header)
+	if err != nil {
+		return 0, err
+	}
+	if envelope.ID != messageID {
+		return 0, fmt.Errorf("fetched message %s instead of %s", envelope.ID, messageID)
+	}
+	envelopeData := []byte(strings.Join(envelope.EnvelopeHeadersList, "\n") + "\n")
+
+	maxSize := int64(consts.DEFAULT_MAX_CONTENT_SIZE)
+	if f.MaxSize != nil {
+		maxSize = f.MaxSize(homeDirPath)
+	}
+	overRoom := false
+	if room >= 0 && room-int64(len(envelopeData)) < maxSize {
+		maxSize = room - int64(len(envelopeData))
+		overRoom = true
+	}
+	if maxSize < 0 || (res.ContentLength > maxSize && overRoom) {
+		return 0, ErrorAccountFull
+	}
+
+	tmpPath := MessagePath(homeDirPath, link, "."+messageID)
+	err = os.RemoveAll(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	err = os.MkdirAll(tmpPath, 0700)
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	err = os.WriteFile(filepath.Join(tmpPath, consts.MESSAGE_DIR_ENVELOPE_FILE_NAME), envelopeData, 0600)
+	if err != nil {
+		return 0, err
+	}
+	payloadFile, err := os.OpenFile(filepath.Join(tmpPath, consts.MESSAGE_DIR_PAYLOAD_FILE_NAME), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(payloadFile, io.LimitReader(res.Body, maxSize+1))
+	payloadFile.Close()
+	if err != nil {
+		return 0, err
+	}
+	if written > maxSize {
+		if overRoom {
+			return 0, ErrorAccountFull
+		}
+		return 0, fmt.Errorf("payload of message %s is over %d bytes", messageID, maxSize)
+	}
+	err = os.Rename(tmpPath, MessagePath(homeDirPath, link, messageID))
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(envelopeData)) + written, nil
+}
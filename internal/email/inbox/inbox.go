@@ -0,0 +1,300 @@
+package inbox
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/delegation"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* The inbox keeps messages fetched by the server on behalf of the account,
+ * as the ciphertext served by the author, in <home>/inbox:
+ *
+ *	inbox/<link>/<message id>/envelope
+ *	inbox/<link>/<message id>/payload
+ *
+ * Fetching is done with a fetch key of the account, kept in
+ * inbox/.fetch_key, which the account owner delegates access to per link.
+ * Delegations are kept with the author address, which the owner discloses
+ * to the server for it to know where to fetch, in inbox/.delegations/<link>:
+ *
+ *	Author: author@example.com
+ *	Hosts: mail.example.com
+ *	Delegation: <Fetch-Delegation header value>
+ ***************************************************************************/
+
+const INBOX_DIR = "inbox"
+const INBOX_FETCH_KEY_FILENAME = ".fetch_key"
+const INBOX_DELEGATIONS_DIR = ".delegations"
+const INBOX_COLUMN_SEPARATOR = ","
+
+const FIELD_AUTHOR = "Author"
+const FIELD_HOSTS = "Hosts"
+const FIELD_DELEGATION = "Delegation"
+
+const FETCH_KEY_FIELD_PUBLIC = "Public-Key"
+const FETCH_KEY_FIELD_PRIVATE = "Private-Key"
+
+var ErrorNoFetchKey = errors.New("no fetch key")
+var ErrorNoDelegation = errors.New("no such fetch delegation")
+var ErrorNoInboxMessage = errors.New("no such inbox message")
+
+type FetchKey struct {
+	PublicKeyBase64 string
+	PublicKey       [32]byte
+	PrivateKey      [64]byte
+	Fingerprint     string
+}
+
+type Record struct {
+	Link       string
+	Author     string
+	Hosts      []string
+	Delegation *delegation.Delegation
+}
+
+type Message struct {
+	Link string
+	ID   string
+}
+
+func inboxPath(homeDirPath string) string {
+	return filepath.Join(homeDirPath, INBOX_DIR)
+}
+
+func MessagePath(homeDirPath, link, messageID string) string {
+	return filepath.Join(inboxPath(homeDirPath), link, messageID)
+}
+
+func EnvelopePath(homeDirPath, link, messageID string) string {
+	return filepath.Join(MessagePath(homeDirPath, link, messageID), consts.MESSAGE_DIR_ENVELOPE_FILE_NAME)
+}
+
+func PayloadPath(homeDirPath, link, messageID string) string {
+	return filepath.Join(MessagePath(homeDirPath, link, messageID), consts.MESSAGE_DIR_PAYLOAD_FILE_NAME)
+}
+
+func readFields(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFields(data)
+}
+
+func parseFields(data []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return fields, scanner.Err()
+}
+
+func writeFields(path string, lines []string) error {
+	err := os.WriteFile(path+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func LoadFetchKey(homeDirPath string) (*FetchKey, error) {
+	fields, err := readFields(filepath.Join(inboxPath(homeDirPath), INBOX_FETCH_KEY_FILENAME))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrorNoFetchKey
+		}
+		return nil, err
+	}
+	publicKey, err := crypto.DecodeBase64Key32(fields[FETCH_KEY_FIELD_PUBLIC])
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := crypto.DecodeBase64Key64(fields[FETCH_KEY_FIELD_PRIVATE])
+	if err != nil {
+		return nil, err
+	}
+	return &FetchKey{
+		PublicKeyBase64: fields[FETCH_KEY_FIELD_PUBLIC],
+		PublicKey:       publicKey,
+		PrivateKey:      privateKey,
+		Fingerprint:     crypto.Fingerprint(publicKey[:]),
+	}, nil
+}
+
+// CreateFetchKey makes a new fetch key, which voids all delegations to the
+// previous one.
+func CreateFetchKey(homeDirPath string) (*FetchKey, error) {
+	err := os.MkdirAll(inboxPath(homeDirPath), 0700)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, publicKey := crypto.GenerateSigningKeys()
+	err = writeFields(filepath.Join(inboxPath(homeDirPath), INBOX_FETCH_KEY_FILENAME), []string{
+		FETCH_KEY_FIELD_PUBLIC + ": " + publicKey,
+		FETCH_KEY_FIELD_PRIVATE + ": " + privateKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return LoadFetchKey(homeDirPath)
+}
+
+func delegationPath(homeDirPath, link string) string {
+	return filepath.Join(inboxPath(homeDirPath), INBOX_DELEGATIONS_DIR, link)
+}
+
+func StoreDelegation(homeDirPath string, record *Record) error {
+	err := os.MkdirAll(filepath.Join(inboxPath(homeDirPath), INBOX_DELEGATIONS_DIR), 0700)
+	if err != nil {
+		return err
+	}
+	return writeFields(delegationPath(homeDirPath, record.Link), []string{
+		FIELD_AUTHOR + ": " + record.Author,
+		FIELD_HOSTS + ": " + strings.Join(record.Hosts, " "),
+		FIELD_DELEGATION + ": " + record.Delegation.ToHeader(),
+	})
+}
+
+func LoadDelegation(homeDirPath, link string) (*Record, error) {
+	fields, err := readFields(delegationPath(homeDirPath, link))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrorNoDelegation
+		}
+		return nil, err
+	}
+	return recordFromFields(link, fields)
+}
+
+// RecordFromData parses a delegation record as submitted by the owner.
+func RecordFromData(link string, data []byte) (*Record, error) {
+	fields, err := parseFields(data)
+	if err != nil {
+		return nil, err
+	}
+	return recordFromFields(link, fields)
+}
+
+func recordFromFields(link string, fields map[string]string) (*Record, error) {
+	d, err := delegation.FromHeader(fields[FIELD_DELEGATION])
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		Link:       link,
+		Author:     fields[FIELD_AUTHOR],
+		Hosts:      strings.Fields(fields[FIELD_HOSTS]),
+		Delegation: d,
+	}, nil
+}
+
+func ListDelegations(homeDirPath string) ([]*Record, error) {
+	var records []*Record
+	entries, err := os.ReadDir(filepath.Join(inboxPath(homeDirPath), INBOX_DELEGATIONS_DIR))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		record, err := LoadDelegation(homeDirPath, entry.Name())
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func DeleteDelegation(homeDirPath, link string) error {
+	err := os.Remove(delegationPath(homeDirPath, link))
+	if err != nil && os.IsNotExist(err) {
+		return ErrorNoDelegation
+	}
+	return err
+}
+
+// ToLine renders the delegation as listed to the owner:
+// link,author,expires
+func (r *Record) ToLine() string {
+	return strings.Join([]string{r.Link, r.Author, r.Delegation.Expires}, INBOX_COLUMN_SEPARATOR)
+}
+
+// ValidHost accepts a host name with an optional port, or the base URL of
+// a host, as the hosts of an author may be given.
+func ValidHost(host string) bool {
+	rawURL := host
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		rawURL = "https://" + host
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+	return u.Path == ""
+}
+
+func MessageExists(homeDirPath, link, messageID string) bool {
+	_, err := os.Stat(PayloadPath(homeDirPath, link, messageID))
+	return err == nil
+}
+
+// List returns the fetched messages of all links.
+func List(homeDirPath string) ([]*Message, error) {
+	var messages []*Message
+	links, err := os.ReadDir(inboxPath(homeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return messages, nil
+		}
+		return nil, err
+	}
+	for _, link := range links {
+		if !link.IsDir() || strings.HasPrefix(link.Name(), ".") {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(inboxPath(homeDirPath), link.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// Messages still being fetched are kept aside under a dot name
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && MessageExists(homeDirPath, link.Name(), entry.Name()) {
+				messages = append(messages, &Message{Link: link.Name(), ID: entry.Name()})
+			}
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Link < messages[j].Link
+	})
+	return messages, nil
+}
+
+func Delete(homeDirPath, link, messageID string) error {
+	if !MessageExists(homeDirPath, link, messageID) {
+		return ErrorNoInboxMessage
+	}
+	return os.RemoveAll(MessagePath(homeDirPath, link, messageID))
+}
+
+// ToLine renders the message as listed to the owner: link,message id
+func (m *Message) ToLine() string {
+	return m.Link + INBOX_COLUMN_SEPARATOR + m.ID
+}
@@ -0,0 +1,296 @@
+package keys
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/utils"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* Key backup shares
+ *
+ * The backed up secret is the signing key seed followed by the private
+ * encryption key and a short checksum of both, so that recovering from
+ * too few or mismatched shares is detected. Public keys are derived again
+ * upon recovery.
+ *
+ * Each share is written as words, one byte per word:
+ *
+ *	version | threshold | x | y... | checksum (2 bytes)
+ *
+ * and stored in a text file:
+ *
+ *	# Open Email key share
+ *	Account: alice@example.com
+ *	Share: 2 of 5, threshold 3
+ *	Words: ...
+ *
+ * Only the words are required for recovery, the remaining lines help
+ * whoever keeps the share. A share given to a contact is encrypted to
+ * their profile encryption key:
+ *
+ *	# Open Email encrypted key share
+ *	Account: alice@example.com
+ *	Recipient: bob@example.com
+ *	Encrypted-Share: algorithm=curve25519xsalsa20poly1305; value=BASE64
+ ************************************************************************/
+
+const KEY_SHARE_VERSION = 1
+const KEY_SHARE_COMMENT = "# Open Email key share"
+const KEY_SHARE_FIELD_ACCOUNT = "Account"
+const KEY_SHARE_FIELD_SHARE = "Share"
+const KEY_SHARE_FIELD_WORDS = "Words"
+const KEY_SHARE_ENCRYPTED_COMMENT = "# Open Email encrypted key share"
+const KEY_SHARE_FIELD_RECIPIENT = "Recipient"
+const KEY_SHARE_FIELD_ENCRYPTED_SHARE = "Encrypted-Share"
+
+const KEY_SHARE_SEED_LENGTH = 32
+const KEY_SHARE_ENCRYPTION_KEY_LENGTH = 32
+const KEY_SHARE_SECRET_CHECKSUM_LENGTH = 4
+const KEY_SHARE_CHECKSUM_LENGTH = 2
+
+var ErrorBadKeyShare = errors.New("bad key share")
+var ErrorKeyShareChecksum = errors.New("key share checksum mismatch, check the words")
+var ErrorKeyRecovery = errors.New("shares do not rebuild the keys, too few or from different backups")
+
+type KeyShare struct {
+	Account   string
+	Shares    int
+	Threshold byte
+	Value     []byte // x coordinate followed by the share bytes
+}
+
+type RecoveredKeys struct {
+	PrivateEncryptionKeyBase64 string
+	PublicEncryptionKeyBase64  string
+	PrivateSigningKeyBase64    string
+	PublicSigningKeyBase64     string
+}
+
+// SplitLocalKeys reads the private keys of the account and splits them.
+func SplitLocalKeys(emailAddress string, shares, threshold int) ([]*KeyShare, error) {
+	_, privateEncryptionKey, err := GetLocalEncryptionPrivateKey(emailAddress)
+	if err != nil {
+		return nil, err
+	}
+	_, privateSigningKey, err := GetLocalSigningPrivateKey(emailAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := append([]byte{}, privateSigningKey[:KEY_SHARE_SEED_LENGTH]...)
+	secret = append(secret, privateEncryptionKey[:]...)
+	_, sum := crypto.Sha256Hash(secret)
+	secret = append(secret, sum[:KEY_SHARE_SECRET_CHECKSUM_LENGTH]...)
+
+	values, err := crypto.ShamirSplit(secret, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+	var result []*KeyShare
+	for _, value := range values {
+		result = append(result, &KeyShare{
+			Account:   emailAddress,
+			Shares:    shares,
+			Threshold: byte(threshold),
+			Value:     value,
+		})
+	}
+	return result, nil
+}
+
+func RecoverKeys(shares []*KeyShare) (*RecoveredKeys, error) {
+	if len(shares) == 0 {
+		return nil, ErrorKeyRecovery
+	}
+	if len(shares) < int(shares[0].Threshold) {
+		return nil, fmt.Errorf("%d shares required, %d given", shares[0].Threshold, len(shares))
+	}
+	var values [][]byte
+	for _, share := range shares {
+		values = append(values, share.Value)
+	}
+	secret, err := crypto.ShamirCombine(values)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != KEY_SHARE_SEED_LENGTH+KEY_SHARE_ENCRYPTION_KEY_LENGTH+KEY_SHARE_SECRET_CHECKSUM_LENGTH {
+		return nil, ErrorKeyRecovery
+	}
+	keyData := secret[:KEY_SHARE_SEED_LENGTH+KEY_SHARE_ENCRYPTION_KEY_LENGTH]
+	_, sum := crypto.Sha256Hash(keyData)
+	if !bytes.Equal(sum[:KEY_SHARE_SECRET_CHECKSUM_LENGTH], secret[len(keyData):]) {
+		return nil, ErrorKeyRecovery
+	}
+
+	privateSigningKey, publicSigningKey, err := crypto.SigningKeysFromSeed(keyData[:KEY_SHARE_SEED_LENGTH])
+	if err != nil {
+		return nil, err
+	}
+	var privateEncryptionKey [32]byte
+	copy(privateEncryptionKey[:], keyData[KEY_SHARE_SEED_LENGTH:])
+	publicEncryptionKey := crypto.EncryptionPublicKey(privateEncryptionKey)
+
+	return &RecoveredKeys{
+		PrivateEncryptionKeyBase64: base64.StdEncoding.EncodeToString(privateEncryptionKey[:]),
+		PublicEncryptionKeyBase64:  base64.StdEncoding.EncodeToString(publicEncryptionKey[:]),
+		PrivateSigningKeyBase64:    base64.StdEncoding.EncodeToString(privateSigningKey[:]),
+		PublicSigningKeyBase64:     base64.StdEncoding.EncodeToString(publicSigningKey[:]),
+	}, nil
+}
+
+func (s *KeyShare) Index() int {
+	return int(s.Value[0])
+}
+
+func (s *KeyShare) Words() string {
+	data := []byte{KEY_SHARE_VERSION, s.Threshold}
+	data = append(data, s.Value...)
+	_, sum := crypto.Sha256Hash(data)
+	data = append(data, sum[:KEY_SHARE_CHECKSUM_LENGTH]...)
+
+	words := make([]string, len(data))
+	for i, b := range data {
+		words[i] = SHARE_WORDS[b]
+	}
+	return strings.Join(words, " ")
+}
+
+func (s *KeyShare) ToText() string {
+	lines := []string{
+		KEY_SHARE_COMMENT,
+		KEY_SHARE_FIELD_ACCOUNT + ": " + s.Account,
+		fmt.Sprintf("%s: %d of %d, threshold %d", KEY_SHARE_FIELD_SHARE, s.Index(), s.Shares, s.Threshold),
+		KEY_SHARE_FIELD_WORDS + ": " + s.Words(),
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func (s *KeyShare) EncryptFor(recipient string, publicKey [32]byte) (string, error) {
+	ciphertext, err := crypto.EncryptAnonymous(publicKey, []byte(s.ToText()))
+	if err != nil {
+		return "", err
+	}
+	lines := []string{
+		KEY_SHARE_ENCRYPTED_COMMENT,
+		KEY_SHARE_FIELD_ACCOUNT + ": " + s.Account,
+		KEY_SHARE_FIELD_RECIPIENT + ": " + recipient,
+		KEY_SHARE_FIELD_ENCRYPTED_SHARE + ": " + crypto.ALGORITHM_ATTRIBUTE + "=" + crypto.ANONYMOUS_ENCRYPTION_CIPHER + "; value=" + ciphertext,
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// EncryptedKeyShareFromText returns the recipient and the ciphertext of an
+// encrypted share, for the recipient to decrypt.
+func EncryptedKeyShareFromText(text string) (string, string, error) {
+	var recipient, encrypted string
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		parts := strings.SplitN(line, ":", 2)
+		if len(line) == 0 || line[0] == '#' || len(parts) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case KEY_SHARE_FIELD_RECIPIENT:
+			recipient = strings.TrimSpace(parts[1])
+		case KEY_SHARE_FIELD_ENCRYPTED_SHARE:
+			encrypted = strings.TrimSpace(parts[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if encrypted == "" {
+		return "", "", ErrorBadKeyShare
+	}
+
+	attrs := utils.ParseHeadersAttributes(encrypted)
+	if strings.ToLower(attrs[crypto.ALGORITHM_ATTRIBUTE]) != crypto.ANONYMOUS_ENCRYPTION_CIPHER || attrs["value"] == "" {
+		return "", "", errors.New("unsupported key share encryption")
+	}
+	return recipient, attrs["value"], nil
+}
+
+// KeyShareFromText accepts a share file, or the bare words of a share.
+func KeyShareFromText(text string) (*KeyShare, error) {
+	var share KeyShare
+	var words string
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			words += " " + line
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case KEY_SHARE_FIELD_ACCOUNT:
+			share.Account = value
+		case KEY_SHARE_FIELD_SHARE:
+			// "2 of 5, threshold 3", only the total is not part of the words
+			fields := strings.Fields(value)
+			if len(fields) >= 3 {
+				share.Shares, _ = strconv.Atoi(strings.TrimSuffix(fields[2], ","))
+			}
+		case KEY_SHARE_FIELD_WORDS:
+			words += " " + value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := decodeShareWords(words)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 3+2+KEY_SHARE_CHECKSUM_LENGTH {
+		return nil, ErrorBadKeyShare
+	}
+	payload := data[:len(data)-KEY_SHARE_CHECKSUM_LENGTH]
+	_, sum := crypto.Sha256Hash(payload)
+	if !bytes.Equal(sum[:KEY_SHARE_CHECKSUM_LENGTH], data[len(payload):]) {
+		return nil, ErrorKeyShareChecksum
+	}
+	if payload[0] != KEY_SHARE_VERSION {
+		return nil, errors.New("unsupported key share version")
+	}
+	share.Threshold = payload[1]
+	share.Value = payload[2:]
+	if share.Value[0] == 0 {
+		return nil, ErrorBadKeyShare
+	}
+	return &share, nil
+}
+
+func decodeShareWords(words string) ([]byte, error) {
+	var data []byte
+	for _, word := range strings.Fields(strings.ToLower(words)) {
+		b, found := shareWordIndex[word]
+		if !found {
+			return nil, fmt.Errorf("unknown share word '%s'", word)
+		}
+		data = append(data, b)
+	}
+	return data, nil
+}
+
+var shareWordIndex = func() map[string]byte {
+	index := make(map[string]byte, len(SHARE_WORDS))
+	for i, word := range SHARE_WORDS {
+		index[word] = byte(i)
+	}
+	return index
+}()
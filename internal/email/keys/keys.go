@@ -19,16 +19,29 @@ const PREVIOUS_KEY_SUFFIX = ".previous"
 func StoreLocalEncryptionPublicKey(emailAddress, data string, overwrite bool) (string, error) {
 	return StoreLocalKey(emailAddress, PUBLIC_ENCRYPTION_KEY_SUFFIX, data, overwrite)
 }
-func StoreLocalEncryptionPrivateKey(emailAddress, data string, overwrite bool) (string, error) {
-	return StoreLocalKey(emailAddress, PRIVATE_ENCRYPTION_KEY_SUFFIX, data, overwrite)
+func StoreLocalEncryptionPrivateKey(emailAddress, data string, passphrase []byte, overwrite bool) (string, error) {
+	return StoreLocalPrivateKey(emailAddress, PRIVATE_ENCRYPTION_KEY_SUFFIX, data, passphrase, overwrite)
 }
-func StoreLocalSigningPrivateKey(emailAddress, data string, overwrite bool) (string, error) {
-	return StoreLocalKey(emailAddress, PRIVATE_SIGNING_KEY_SUFFIX, data, overwrite)
+func StoreLocalSigningPrivateKey(emailAddress, data string, passphrase []byte, overwrite bool) (string, error) {
+	return StoreLocalPrivateKey(emailAddress, PRIVATE_SIGNING_KEY_SUFFIX, data, passphrase, overwrite)
 }
 func StoreLocalSigningPublicKey(emailAddress, data string, overwrite bool) (string, error) {
 	return StoreLocalKey(emailAddress, PUBLIC_SIGNING_KEY_SUFFIX, data, overwrite)
 }
 
+// StoreLocalPrivateKey protects the key with the passphrase, if one is given.
+// Without a passphrase the key is stored in plaintext, readable by owner only.
+func StoreLocalPrivateKey(emailAddress, suffix, data string, passphrase []byte, overwrite bool) (string, error) {
+	if len(passphrase) > 0 {
+		protected, err := ProtectKey(data, passphrase)
+		if err != nil {
+			return "", err
+		}
+		data = protected
+	}
+	return StoreLocalKey(emailAddress, suffix, data, overwrite)
+}
+
 func StoreLocalKey(emailAddress, suffix, data string, overwrite bool) (string, error) {
 	err := storage.CreateEmailHomePath(emailAddress)
 	if err != nil {
@@ -46,7 +59,12 @@ func StoreLocalKey(emailAddress, suffix, data string, overwrite bool) (string, e
 			return keyPath, errors.New("Fatal error: key exists already")
 		}
 	}
-	return keyPath, os.WriteFile(keyPath, []byte(data), 0644)
+	err = os.WriteFile(keyPath, []byte(data), privateKeyFileMode(suffix))
+	if err != nil {
+		return keyPath, err
+	}
+	// WriteFile keeps the mode of existing files
+	return keyPath, os.Chmod(keyPath, privateKeyFileMode(suffix))
 }
 
 func GetLocalEncryptionPublicKey(emailAddress string) (string, [32]byte, error) {
@@ -102,31 +120,52 @@ func GetLocalKey64(emailAddress, suffix string) (string, [64]byte, error) {
 	return base64Key, keyBytes, nil
 }
 
+// GetLocalKey returns the base64 encoded key, unlocking protected
+// private keys through the PassphraseProvider.
 func GetLocalKey(emailAddress, suffix string) (string, error) {
+	_, key, err := readLocalKeyFile(emailAddress, suffix)
+	if err != nil {
+		return "", err
+	}
+	if IsProtectedKey(key) {
+		return unlockKey(strings.ToLower(emailAddress), key)
+	}
+	return key, nil
+}
+
+func LocalKeyIsProtected(emailAddress, suffix string) (bool, error) {
+	_, key, err := readLocalKeyFile(emailAddress, suffix)
+	if err != nil {
+		return false, err
+	}
+	return IsProtectedKey(key), nil
+}
+
+func readLocalKeyFile(emailAddress, suffix string) (string, string, error) {
 	if !address.ValidEmailAddress(emailAddress) {
-		return "", errors.New("malformed email address")
+		return "", "", errors.New("malformed email address")
 	}
 
 	homePath, err := storage.LocalHomePath(emailAddress)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	keyFilename := strings.ToLower(emailAddress) + "." + suffix
 	keyPath := filepath.Join(homePath, keyFilename)
 
 	if _, err := os.Stat(filepath.Dir(keyPath)); os.IsNotExist(err) {
-		return "", err
+		return keyPath, "", err
 	}
 
 	_, err = os.Stat(keyPath)
 	if err != nil {
-		return "", err
+		return keyPath, "", err
 	}
 
 	key, err := os.ReadFile(keyPath)
 	if err != nil {
-		return "", err
+		return keyPath, "", err
 	}
 
-	return string(key), nil
+	return keyPath, string(key), nil
 }
@@ -0,0 +1,195 @@
+package keys
+
+import (
+	"bufio"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/utils"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+/* Protected private key file format (version 1):
+ *
+ *	# Open Email protected private key
+ *	Version: 1
+ *	Key-Derivation: algorithm=argon2id; time=3; memory=65536; threads=4; salt=BASE64
+ *	Key-Encryption: algorithm=xchacha20poly1305; value=BASE64(nonce|ciphertext)
+ *
+ * The ciphertext is the base64 encoded private key, exactly as stored in
+ * plaintext key files, so the two formats are interchangeable once unlocked.
+ ****************************************************************************/
+
+const PROTECTED_KEY_VERSION = "1"
+const PROTECTED_KEY_COMMENT = "# Open Email protected private key"
+const PROTECTED_KEY_FIELD_VERSION = "Version"
+const PROTECTED_KEY_FIELD_DERIVATION = "Key-Derivation"
+const PROTECTED_KEY_FIELD_ENCRYPTION = "Key-Encryption"
+
+var ErrorPassphraseRequired = errors.New("passphrase required to unlock private key")
+var ErrorBadPassphrase = errors.New("bad passphrase or corrupted key file")
+var ErrorUnsupportedKeyVersion = errors.New("unsupported protected key version")
+
+var PRIVATE_KEY_SUFFIXES = []string{
+	PRIVATE_ENCRYPTION_KEY_SUFFIX,
+	PRIVATE_SIGNING_KEY_SUFFIX,
+	PRIVATE_ENCRYPTION_KEY_SUFFIX + PREVIOUS_KEY_SUFFIX,
+	PRIVATE_SIGNING_KEY_SUFFIX + PREVIOUS_KEY_SUFFIX,
+}
+
+// PassphraseFunc supplies the passphrase unlocking the private keys of
+// the given account. Commands set PassphraseProvider to prompt the user
+// or to read the passphrase from the environment.
+type PassphraseFunc func(emailAddress string) ([]byte, error)
+
+var PassphraseProvider PassphraseFunc
+
+// Keys of one account share a passphrase, ask only once per process
+var passphraseCache = make(map[string][]byte)
+var passphraseCacheMutex sync.Mutex
+
+func IsPrivateKeySuffix(suffix string) bool {
+	for _, s := range PRIVATE_KEY_SUFFIXES {
+		if s == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+func IsProtectedKey(data string) bool {
+	return strings.Contains(data, PROTECTED_KEY_FIELD_VERSION+":")
+}
+
+func ProtectKey(base64Key string, passphrase []byte) (string, error) {
+	if len(passphrase) == 0 {
+		return "", ErrorPassphraseRequired
+	}
+	kdf, err := crypto.NewKDFInfo()
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := crypto.Xchacha20Poly1305Encrypt([]byte(strings.TrimSpace(base64Key)), kdf.DeriveKey(passphrase))
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{
+		PROTECTED_KEY_COMMENT,
+		PROTECTED_KEY_FIELD_VERSION + ": " + PROTECTED_KEY_VERSION,
+		PROTECTED_KEY_FIELD_DERIVATION + ": " + kdf.ToHeader(),
+		PROTECTED_KEY_FIELD_ENCRYPTION + ": " + crypto.ALGORITHM_ATTRIBUTE + "=" + crypto.SYMMETRIC_CIPHER + "; value=" + base64.StdEncoding.EncodeToString(ciphertext),
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func UnprotectKey(data string, passphrase []byte) (string, error) {
+	var version, derivation, encryption string
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case PROTECTED_KEY_FIELD_VERSION:
+			version = value
+		case PROTECTED_KEY_FIELD_DERIVATION:
+			derivation = value
+		case PROTECTED_KEY_FIELD_ENCRYPTION:
+			encryption = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if version != PROTECTED_KEY_VERSION {
+		return "", ErrorUnsupportedKeyVersion
+	}
+	if derivation == "" || encryption == "" {
+		return "", errors.New("incomplete protected key file")
+	}
+
+	kdf, err := crypto.KDFInfoFromHeader(derivation)
+	if err != nil {
+		return "", err
+	}
+
+	encryptionAttrs := utils.ParseHeadersAttributes(encryption)
+	if strings.ToLower(encryptionAttrs[crypto.ALGORITHM_ATTRIBUTE]) != crypto.SYMMETRIC_CIPHER {
+		return "", errors.New("unsupported key encryption algorithm")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encryptionAttrs["value"])
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := crypto.Xchacha20Poly1305Decrypt(ciphertext, kdf.DeriveKey(passphrase))
+	if err != nil || plaintext == nil {
+		return "", ErrorBadPassphrase
+	}
+	return string(plaintext), nil
+}
+
+// ProtectLocalKey encrypts an existing plaintext private key file in place.
+// Already protected keys are left untouched and reported as such.
+func ProtectLocalKey(emailAddress, suffix string, passphrase []byte) (string, bool, error) {
+	keyPath, data, err := readLocalKeyFile(emailAddress, suffix)
+	if err != nil {
+		return keyPath, false, err
+	}
+	if IsProtectedKey(data) {
+		return keyPath, false, nil
+	}
+	protected, err := ProtectKey(data, passphrase)
+	if err != nil {
+		return keyPath, false, err
+	}
+	_, err = StoreLocalKey(emailAddress, suffix, protected, true)
+	if err != nil {
+		return keyPath, false, err
+	}
+	return keyPath, true, nil
+}
+
+func unlockKey(emailAddress, data string) (string, error) {
+	if PassphraseProvider == nil {
+		return "", ErrorPassphraseRequired
+	}
+
+	passphraseCacheMutex.Lock()
+	defer passphraseCacheMutex.Unlock()
+
+	passphrase, cached := passphraseCache[emailAddress]
+	if !cached {
+		var err error
+		passphrase, err = PassphraseProvider(emailAddress)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	key, err := UnprotectKey(data, passphrase)
+	if err != nil {
+		delete(passphraseCache, emailAddress)
+		return "", err
+	}
+	passphraseCache[emailAddress] = passphrase
+	return key, nil
+}
+
+func privateKeyFileMode(suffix string) os.FileMode {
+	if IsPrivateKeySuffix(suffix) {
+		return 0600
+	}
+	return 0644
+}
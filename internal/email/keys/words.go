@@ -0,0 +1,38 @@
+package keys
+
+// SHARE_WORDS encodes one byte per word. The list is sorted, so a word's
+// position is its byte value.
+var SHARE_WORDS = [256]string{
+	"acid", "acorn", "actor", "agent", "alarm", "album", "alert", "alley",
+	"amber", "angle", "apple", "april", "apron", "arena", "arrow", "aspen",
+	"atlas", "audio", "autumn", "award", "bacon", "badge", "bagel", "baker",
+	"bamboo", "barn", "basil", "basin", "beach", "beard", "berry", "bird",
+	"bison", "blade", "blanket", "bloom", "board", "bonus", "book", "boss",
+	"bottle", "bread", "cabin", "cactus", "camel", "candle", "canoe", "canvas",
+	"cargo", "carpet", "castle", "cedar", "cello", "chalk", "chess", "chief",
+	"cider", "cinema", "circle", "clock", "cloud", "clover", "cobalt", "coffee",
+	"comet", "delta", "denim", "desert", "diamond", "diary", "dinner", "dock",
+	"dolphin", "domain", "donkey", "dragon", "dream", "drum", "duck", "dune",
+	"eagle", "echo", "elbow", "ember", "engine", "fabric", "falcon", "farm",
+	"fence", "ferry", "fiber", "field", "fig", "finch", "flute", "forest",
+	"fossil", "fox", "frost", "galaxy", "garden", "garlic", "gecko", "gem",
+	"ginger", "glacier", "globe", "gold", "grape", "gravel", "guitar", "harbor",
+	"hazel", "helmet", "hero", "honey", "hotel", "husky", "igloo", "index",
+	"indigo", "ink", "ivory", "jacket", "jaguar", "jasmine", "jelly", "jewel",
+	"juniper", "kayak", "kettle", "kiwi", "koala", "lagoon", "lake", "lemon",
+	"lentil", "lily", "lime", "lion", "lizard", "lobster", "lotus", "lunar",
+	"magnet", "maple", "marble", "meadow", "melon", "metal", "mint", "mirror",
+	"monkey", "moose", "mosaic", "motor", "muffin", "museum", "nectar", "needle",
+	"nest", "nickel", "north", "nutmeg", "oasis", "ocean", "olive", "opal",
+	"orange", "orbit", "orchid", "otter", "owl", "paddle", "palace", "panda",
+	"paper", "parrot", "peach", "pencil", "pepper", "piano", "pilot", "pine",
+	"planet", "pocket", "polar", "pony", "poppy", "potato", "pumpkin", "puzzle",
+	"quartz", "quill", "rabbit", "radar", "rain", "raven", "reef", "ribbon",
+	"river", "robin", "rose", "ruby", "saddle", "salmon", "sand", "scarf",
+	"shell", "silver", "sketch", "sleet", "snow", "solar", "spice", "spoon",
+	"spring", "squid", "stamp", "stone", "storm", "sugar", "summit", "sun",
+	"table", "tango", "tea", "tiger", "timber", "tomato", "tower", "tractor",
+	"trumpet", "tulip", "tundra", "turtle", "unicorn", "valley", "vanilla", "velvet",
+	"violet", "volcano", "wagon", "walnut", "walrus", "water", "whale", "willow",
+	"window", "winter", "wizard", "wolf", "yacht", "zebra", "zephyr", "zinc",
+}
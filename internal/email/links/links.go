@@ -15,3 +15,9 @@ func Make(emailAddress1, emailAddress2 string) string {
 	hash := sha256.Sum256([]byte(strings.Join(strs, "")))
 	return hex.EncodeToString(hash[:])
 }
+
+// Valid tells whether the link is shaped as made by Make.
+func Valid(link string) bool {
+	decoded, err := hex.DecodeString(link)
+	return err == nil && len(decoded) == sha256.Size && strings.ToLower(link) == link
+}
@@ -65,7 +65,6 @@ func LookupEmailHosts(domainName, localPart string) ([]string, error) {
 func tryWellKnownHost(hostname string) ([]byte, error) {
 	wellKnownURI := fmt.Sprintf("https://%s/%s", hostname, WELL_KNOWN_URI)
 	resp, err := http.Get(wellKnownURI)
-	defer resp.Body.Close()
 	if err != nil {
 		if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
 			fmt.Println("Timeout error:", err)
@@ -76,6 +75,7 @@ func tryWellKnownHost(hostname string) ([]byte, error) {
 		}
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, nil
@@ -152,7 +152,7 @@ func retrieveAccessKeyForReaderUser(message *Message, readerUser *user.Reader) (
 	link := linksPkg.Make(message.Author.Address, readerUser.Address)
 	for _, r := range message.Readers {
 		if r.Link == link && r.PublicEncryptionKeyFingerprint == readerUser.PublicEncryptionKeyFingerprint {
-			key, err := crypto.DecryptAnonymous(readerUser.PrivateEncryptionKey, readerUser.PublicEncryptionKey, r.SealedKey)
+			key, err := readerUser.DecryptAnonymous(r.SealedKey)
 			if err != nil {
 				return nil, err
 			}
@@ -251,7 +251,11 @@ func AssignMessageHeader(key, value string, message *Message) (bool, error) {
 		if checksumAttrMap["algorithm"] != crypto.CHECKSUM_ALGORITHM {
 			return false, errors.New("unsupported checksum algorithm")
 		}
-		message.EnvelopeHeadersChecksum = checksumAttrMap["sum"]
+		// Sealed as value, sum is the older name
+		message.EnvelopeHeadersChecksum = checksumAttrMap["value"]
+		if message.EnvelopeHeadersChecksum == "" {
+			message.EnvelopeHeadersChecksum = checksumAttrMap["sum"]
+		}
 		message.EnvelopeHeadersOrder = checksumAttrMap["order"]
 
 	case HEADER_MESSAGE_ENVELOPE_SIGNATURE:
@@ -261,7 +265,11 @@ func AssignMessageHeader(key, value string, message *Message) (bool, error) {
 		if signatureAttrMap["algorithm"] != crypto.SIGNING_ALGORITHM {
 			return false, errors.New("unsupported signing algorithm")
 		}
-		message.EnvelopeHeadersSignature = signatureAttrMap["data"]
+		// Sealed as value, data is the older name
+		message.EnvelopeHeadersSignature = signatureAttrMap["value"]
+		if message.EnvelopeHeadersSignature == "" {
+			message.EnvelopeHeadersSignature = signatureAttrMap["data"]
+		}
 
 	case HEADER_MESSAGE_ENCRYPTION:
 		ci, err := crypto.CipherInfoFromHeader(value)
@@ -292,8 +300,12 @@ func openEnvelopeFile(messageDirPath string, authorUser *user.User, readerUser *
 	contentHeadersBase64 := ""
 	for _, pair := range contentHeaderAttrs {
 		kvs := strings.SplitN(pair, "=", 2)
+		if len(kvs) != 2 {
+			continue
+		}
+		// Envelopes are sealed with algorithm and value, seal and data are older names
 		switch strings.ToLower(strings.TrimSpace(kvs[0])) {
-		case "seal":
+		case "algorithm", "seal":
 			algorithm := strings.ToLower(strings.TrimSpace(kvs[1]))
 			if algorithm == "none" {
 				continue
@@ -301,8 +313,8 @@ func openEnvelopeFile(messageDirPath string, authorUser *user.User, readerUser *
 			if algorithm != crypto.SYMMETRIC_CIPHER {
 				return nil, errors.New("Unsupported content headers cipher: " + algorithm)
 			}
-		case "data":
-			contentHeadersBase64 = kvs[1]
+		case "value", "data":
+			contentHeadersBase64 = strings.TrimSpace(kvs[1])
 		default:
 			continue
 		}
@@ -4,8 +4,10 @@ import (
 	"email.mercata.com/internal/crypto"
 	"email.mercata.com/internal/email/address"
 	linksPkg "email.mercata.com/internal/email/links"
+	"email.mercata.com/internal/email/pins"
 	"email.mercata.com/internal/email/user"
 	"email.mercata.com/internal/utils"
+	"fmt"
 	"strings"
 	"time"
 )
@@ -115,10 +117,17 @@ func (msg *Message) AddReader(readerEmailAddress string) error {
 	//TODO: The reader comes from a remote profile, not local keys.
 	// For development purposes, we however use local keys.
 
-	u, err := user.LocalUser(readerEmailAddress)
+	u, err := user.LocalPublicUser(readerEmailAddress)
 	if err != nil {
 		return err
 	}
+	// Own keys are not pinned
+	if u.Address != msg.Author.Address {
+		_, err = pins.CheckKeys(u.Address, u.PublicSigningKeyBase64, u.PublicEncryptionKeyBase64)
+		if err != nil {
+			return fmt.Errorf("keys of reader '%s': %w", u.Address, err)
+		}
+	}
 	r := user.AsReader(u)
 	r.Link = linksPkg.Make(msg.Author.Address, r.Address)
 
@@ -107,6 +107,12 @@ func Open(messageDirPath string, authorUser *user.User, readerUser *user.Reader)
 	return message, nil
 }
 
+// OpenEnvelope reads the content headers of the message for the reader,
+// leaving the payload sealed and nothing written to the message dir.
+func OpenEnvelope(messageDirPath string, authorUser *user.User, readerUser *user.Reader) (*Message, error) {
+	return openEnvelopeFile(messageDirPath, authorUser, readerUser)
+}
+
 func (msg *Message) VerifyEnvelopeAuthenticity() bool {
 	if (msg.EnvelopeHeadersOrder == "") || (msg.EnvelopeHeadersChecksum == "") || (msg.EnvelopeHeadersSignature == "") {
 		return false
@@ -160,14 +160,17 @@ func (msg *Message) SealEnvelope(envelopeDestinationPath string) error {
 
 	envelopeChecksum := []byte(strings.Join(envelopeChecksumValuesList, ""))
 	envelopeHexCheckSum, envelopeSumBytes := crypto.Checksum(envelopeChecksum)
-	envelopeSignature := crypto.SignData(msg.Author.PublicSigningKey, msg.Author.PrivateSigningKey, envelopeSumBytes)
+	envelopeSignature, err := msg.Author.SignData(envelopeSumBytes)
+	if err != nil {
+		return err
+	}
 
 	// The last two are the checksum and its signature, and not part of the sum calculation
 	envelopeDumpHeadersList = append(envelopeDumpHeadersList, HeaderLine(HEADER_MESSAGE_ENVELOPE_CHECKSUM, HeadersChecksumHeader(envelopeHexCheckSum, strings.Join(envelopeHeadersOrderList, ":"))))
 	envelopeDumpHeadersList = append(envelopeDumpHeadersList, HeaderLine(HEADER_MESSAGE_ENVELOPE_SIGNATURE, SignatureHeader(envelopeSignature)))
 	envelopeDumpStr := []byte(strings.Join(envelopeDumpHeadersList, "\n"))
 
-	err := ioutil.WriteFile(envelopeDestinationPath, append(envelopeDumpStr, '\n'), 0644)
+	err = ioutil.WriteFile(envelopeDestinationPath, append(envelopeDumpStr, '\n'), 0644)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,63 @@
+package notification
+
+import (
+	"sync"
+)
+
+// Notifications buffered per subscriber before it is dropped as too slow
+const HUB_SUBSCRIBER_BUFFER = 32
+
+// Hub fans out stored notifications to the sessions of an account that
+// are subscribed to them, within the server process.
+type Hub struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan *Notification]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[string]map[chan *Notification]struct{})}
+}
+
+// Subscribe returns a channel receiving notifications of the account and
+// a function ending the subscription. A subscriber falling behind has its
+// channel closed and is expected to resume from the last ID it saw.
+func (h *Hub) Subscribe(account string) (<-chan *Notification, func()) {
+	ch := make(chan *Notification, HUB_SUBSCRIBER_BUFFER)
+
+	h.mutex.Lock()
+	if h.subscribers[account] == nil {
+		h.subscribers[account] = make(map[chan *Notification]struct{})
+	}
+	h.subscribers[account][ch] = struct{}{}
+	h.mutex.Unlock()
+
+	return ch, func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		h.remove(account, ch)
+	}
+}
+
+func (h *Hub) Publish(account string, n *Notification) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for ch := range h.subscribers[account] {
+		select {
+		case ch <- n:
+		default:
+			h.remove(account, ch)
+		}
+	}
+}
+
+func (h *Hub) remove(account string, ch chan *Notification) {
+	if _, found := h.subscribers[account][ch]; !found {
+		return
+	}
+	delete(h.subscribers[account], ch)
+	close(ch)
+	if len(h.subscribers[account]) == 0 {
+		delete(h.subscribers, account)
+	}
+}
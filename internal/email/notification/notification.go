@@ -1,24 +1,108 @@
 package notification
 
 import (
+	"bufio"
+	"bytes"
 	"email.mercata.com/internal/consts"
 	"email.mercata.com/internal/crypto"
 	"email.mercata.com/internal/utils"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+/* Notifications are kept as a history, one file per link in notifications/:
+ *
+ *	# Count: 3
+ *	id,notifier,notifier-key,reader-key,sequence,received,acknowledged
+ *
+ * The count is the number of notifications ever received on the link and
+ * numbers them. Acknowledged is empty until the owner marks the notification
+ * as handled. Acknowledged notifications expire after MAX_NOTIFICATION_TIME,
+ * or the time the server sets with SetMaxAcknowledgedAge. Others stay until
+ * acknowledged, deleted or pushed out by newer ones.
+ *
+ * Files holding a single id,notifier,notifier-key,reader-key line predate
+ * the history and are read as one unacknowledged notification. The history
+ * columns come after those, so lines listed to the owner start with the
+ * link and the columns they always had.
+ ****************************************************************************/
+
 const NOTIFICATIONS_DIR = "notifications"
 const NOTIFICATIONS_COLUMN_SEPARATOR = ","
 const NOTIFICATIONS_ID_LENGTH = 32
+const NOTIFICATIONS_COUNT_FIELD = "# Count:"
+
+// Most notifications kept per link, the oldest go first
+const MAX_NOTIFICATIONS_PER_LINK = 100
+
+const notificationColumns = 7
+const legacyNotificationColumns = 4
+
+// Selects all notifications in Acknowledge and Delete
+const ALL_NOTIFICATIONS = "all"
+
+var ErrorBadNotification = errors.New("bad notification line")
+
+var notificationsMutex sync.Mutex
+
+// Nanoseconds acknowledged notifications are kept, MAX_NOTIFICATION_TIME if zero
+var maxAcknowledgedAge atomic.Int64
+
+// SetMaxAcknowledgedAge changes how long acknowledged notifications are
+// kept, zero restoring MAX_NOTIFICATION_TIME.
+func SetMaxAcknowledgedAge(age time.Duration) {
+	maxAcknowledgedAge.Store(int64(age))
+}
+
+// Where unreadable notification files are reported, the standard logger if nil
+var errorLog atomic.Pointer[log.Logger]
+
+// SetErrorLog changes where unreadable notification files are reported
+func SetErrorLog(l *log.Logger) {
+	errorLog.Store(l)
+}
+
+func logError(format string, v ...interface{}) {
+	l := errorLog.Load()
+	if l == nil {
+		l = log.Default()
+	}
+	l.Printf(format, v...)
+}
+
+type Notification struct {
+	Link         string
+	ID           string
+	Sequence     int
+	Received     string
+	Acknowledged string
+
+	// Notifier address, encrypted to the reader
+	Notifier    string
+	NotifierKey string
+	ReaderKey   string
+}
+
+type Filter struct {
+	Since          time.Time
+	Unacknowledged bool
+}
+
+func notificationPath(homeDirPath, link string) string {
+	return filepath.Join(homeDirPath, NOTIFICATIONS_DIR, link)
+}
 
 func Exists(homeDirPath, link string) (bool, error) {
-	notificationPath := filepath.Join(homeDirPath, NOTIFICATIONS_DIR, link)
-	linkExists, err := utils.FilePathExists(notificationPath)
+	linkExists, err := utils.FilePathExists(notificationPath(homeDirPath, link))
 	if err != nil {
 		return false, err
 	}
@@ -28,69 +112,349 @@ func Exists(homeDirPath, link string) (bool, error) {
 	return false, nil
 }
 
-func Store(homeDirPath, link, notifier, notifierSignKey, readerPubEncryptKey string) error {
+func Store(homeDirPath, link, notifier, notifierSignKey, readerPubEncryptKey string) (*Notification, error) {
 	err := createNotificationsDir(homeDirPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Generate the notification identifier
 	randomStr, err := crypto.GenerateRandomString(NOTIFICATIONS_ID_LENGTH)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	notificationLine := strings.Join([]string{randomStr, notifier, notifierSignKey, readerPubEncryptKey}, NOTIFICATIONS_COLUMN_SEPARATOR)
-	notificationPath := filepath.Join(homeDirPath, NOTIFICATIONS_DIR, link)
-	err = os.WriteFile(notificationPath, []byte(notificationLine), 0644)
+	notificationsMutex.Lock()
+	defer notificationsMutex.Unlock()
+
+	count, history, err := load(homeDirPath, link)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	go cleanupOldNotifications(homeDirPath)
+	count++
+	n := &Notification{
+		Link:        link,
+		ID:          randomStr,
+		Sequence:    count,
+		Received:    utils.ToRFC3339String(utils.TimestampNow()),
+		Notifier:    notifier,
+		NotifierKey: notifierSignKey,
+		ReaderKey:   readerPubEncryptKey,
+	}
+	history = append(history, n)
 
-	return nil
+	err = save(homeDirPath, link, count, prune(history))
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
 }
 
-func ListAll(homeDirPath string) ([]string, error) {
-	var result []string
-
-	notificationsPath := filepath.Join(homeDirPath, NOTIFICATIONS_DIR)
+// List returns the notifications of all links matching the filter, oldest first.
+func List(homeDirPath string, filter Filter) ([]*Notification, error) {
+	var result []*Notification
 
-	notificationsPathExists, err := utils.FilePathExists(notificationsPath)
+	links, err := listLinks(homeDirPath)
 	if err != nil {
 		return nil, err
 	}
-	if !notificationsPathExists {
-		return result, nil
+
+	notificationsMutex.Lock()
+	defer notificationsMutex.Unlock()
+
+	for _, link := range links {
+		_, history, err := load(homeDirPath, link)
+		if err != nil {
+			continue
+		}
+		for _, n := range history {
+			if filter.Unacknowledged && n.Acknowledged != "" {
+				continue
+			}
+			if !filter.Since.IsZero() {
+				received, err := utils.ParseRFC3339Time(n.Received)
+				if err != nil || !received.After(filter.Since) {
+					continue
+				}
+			}
+			result = append(result, n)
+		}
 	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Received < result[j].Received
+	})
+	return result, nil
+}
 
-	fileInfos, err := ioutil.ReadDir(notificationsPath)
+// Since returns the notifications received after the one with the given
+// ID, oldest first. When that one is gone, all unacknowledged are returned.
+func Since(homeDirPath, id string) ([]*Notification, error) {
+	all, err := List(homeDirPath, Filter{})
 	if err != nil {
 		return nil, err
 	}
+	for i, n := range all {
+		if n.ID == id {
+			return all[i+1:], nil
+		}
+	}
+	return List(homeDirPath, Filter{Unacknowledged: true})
+}
+
+// Acknowledge marks the notifications with the given IDs as handled and
+// returns how many were marked. ALL_NOTIFICATIONS marks all of them.
+func Acknowledge(homeDirPath string, ids []string) (int, error) {
+	acknowledged := utils.ToRFC3339String(utils.TimestampNow())
+	return update(homeDirPath, ids, func(history []*Notification, selected func(*Notification) bool) ([]*Notification, int) {
+		changed := 0
+		for _, n := range history {
+			if selected(n) && n.Acknowledged == "" {
+				n.Acknowledged = acknowledged
+				changed++
+			}
+		}
+		return history, changed
+	})
+}
+
+// Delete removes the notifications with the given IDs and returns how many
+// were removed. ALL_NOTIFICATIONS removes all of them.
+func Delete(homeDirPath string, ids []string) (int, error) {
+	return update(homeDirPath, ids, func(history []*Notification, selected func(*Notification) bool) ([]*Notification, int) {
+		var remaining []*Notification
+		for _, n := range history {
+			if !selected(n) {
+				remaining = append(remaining, n)
+			}
+		}
+		return remaining, len(history) - len(remaining)
+	})
+}
+
+// Expire removes the notifications received before the time, whether
+// acknowledged or not, and returns how many were removed.
+func Expire(homeDirPath string, before time.Time) (int, error) {
+	return update(homeDirPath, []string{ALL_NOTIFICATIONS}, func(history []*Notification, selected func(*Notification) bool) ([]*Notification, int) {
+		var remaining []*Notification
+		for _, n := range history {
+			received, err := utils.ParseRFC3339Time(n.Received)
+			if err == nil && received.Before(before) {
+				continue
+			}
+			remaining = append(remaining, n)
+		}
+		return remaining, len(history) - len(remaining)
+	})
+}
+
+// Sweep drops the acknowledged notifications older than the cutoff of
+// SetMaxAcknowledgedAge, MAX_NOTIFICATION_TIME by default, and returns how
+// many were dropped.
+func Sweep(homeDirPath string) (int, error) {
+	return update(homeDirPath, []string{ALL_NOTIFICATIONS}, func(history []*Notification, selected func(*Notification) bool) ([]*Notification, int) {
+		kept := prune(history)
+		return kept, len(history) - len(kept)
+	})
+}
+
+func update(homeDirPath string, ids []string, change func([]*Notification, func(*Notification) bool) ([]*Notification, int)) (int, error) {
+	all := false
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == ALL_NOTIFICATIONS {
+			all = true
+		} else if id != "" {
+			wanted[id] = true
+		}
+	}
+	selected := func(n *Notification) bool {
+		return all || wanted[n.ID]
+	}
+
+	links, err := listLinks(homeDirPath)
+	if err != nil {
+		return 0, err
+	}
+
+	notificationsMutex.Lock()
+	defer notificationsMutex.Unlock()
+
+	total := 0
+	for _, link := range links {
+		count, history, err := load(homeDirPath, link)
+		if err != nil {
+			// Skipped as List does, the other links still change
+			logError("Skipping unreadable notifications of link %s in %s: %s", link, homeDirPath, err)
+			continue
+		}
+		history, changed := change(history, selected)
+		if changed == 0 {
+			continue
+		}
+		err = save(homeDirPath, link, count, prune(history))
+		if err != nil {
+			return total, err
+		}
+		total += changed
+	}
+	return total, nil
+}
+
+// ToLine renders the notification as listed to the owner:
+// link,id,notifier,notifier-key,reader-key,sequence,received,acknowledged
+func (n *Notification) ToLine() string {
+	return n.Link + NOTIFICATIONS_COLUMN_SEPARATOR + n.toFileLine()
+}
+
+func FromLine(line string) (*Notification, error) {
+	parts := strings.SplitN(line, NOTIFICATIONS_COLUMN_SEPARATOR, 2)
+	if len(parts) != 2 {
+		return nil, ErrorBadNotification
+	}
+	return fromFileLine(parts[0], parts[1])
+}
+
+func (n *Notification) toFileLine() string {
+	return strings.Join([]string{
+		n.ID,
+		n.Notifier,
+		n.NotifierKey,
+		n.ReaderKey,
+		strconv.Itoa(n.Sequence),
+		n.Received,
+		n.Acknowledged,
+	}, NOTIFICATIONS_COLUMN_SEPARATOR)
+}
+
+func fromFileLine(link, line string) (*Notification, error) {
+	parts := strings.Split(line, NOTIFICATIONS_COLUMN_SEPARATOR)
+	if len(parts) != notificationColumns {
+		return nil, ErrorBadNotification
+	}
+	sequence, err := strconv.Atoi(parts[4])
+	if err != nil {
+		return nil, ErrorBadNotification
+	}
+	return &Notification{
+		Link:         link,
+		ID:           parts[0],
+		Notifier:     parts[1],
+		NotifierKey:  parts[2],
+		ReaderKey:    parts[3],
+		Sequence:     sequence,
+		Received:     parts[5],
+		Acknowledged: parts[6],
+	}, nil
+}
+
+func load(homeDirPath, link string) (int, []*Notification, error) {
+	var history []*Notification
+	path := notificationPath(homeDirPath, link)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, history, nil
+		}
+		return 0, nil, err
+	}
 
-	for _, fileInfo := range fileInfos {
-		if fileInfo.IsDir() {
-			continue // Skip directories
+	count := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, NOTIFICATIONS_COUNT_FIELD) {
+			count, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, NOTIFICATIONS_COUNT_FIELD)))
+			continue
 		}
-		filePath := filepath.Join(notificationsPath, fileInfo.Name())
-		fileContents, err := ioutil.ReadFile(filePath)
+		parts := strings.Split(line, NOTIFICATIONS_COLUMN_SEPARATOR)
+		if len(parts) == legacyNotificationColumns {
+			received := utils.TimestampNow()
+			if fileInfo, err := os.Stat(path); err == nil {
+				received = fileInfo.ModTime().UTC()
+			}
+			history = append(history, &Notification{
+				Link:        link,
+				ID:          parts[0],
+				Sequence:    1,
+				Received:    utils.ToRFC3339String(received),
+				Notifier:    parts[1],
+				NotifierKey: parts[2],
+				ReaderKey:   parts[3],
+			})
+			continue
+		}
+		n, err := fromFileLine(link, line)
 		if err != nil {
 			continue
 		}
-		result = append(result, fmt.Sprintf("%s%s%s", fileInfo.Name(), NOTIFICATIONS_COLUMN_SEPARATOR, fileContents))
+		history = append(history, n)
 	}
-	return result, nil
+	for _, n := range history {
+		if n.Sequence > count {
+			count = n.Sequence
+		}
+	}
+	return count, history, scanner.Err()
 }
 
-func cleanupOldNotifications(homeDirPath string) {
-	notificationsPath := filepath.Join(homeDirPath, NOTIFICATIONS_DIR)
-	maxNotificationTime := time.Now().Add(-1 * consts.MAX_NOTIFICATION_TIME)
-	err := utils.DeleteFilesOlderThan(notificationsPath, maxNotificationTime)
+func save(homeDirPath, link string, count int, history []*Notification) error {
+	lines := []string{fmt.Sprintf("%s %d", NOTIFICATIONS_COUNT_FIELD, count)}
+	for _, n := range history {
+		lines = append(lines, n.toFileLine())
+	}
+	path := notificationPath(homeDirPath, link)
+	err := os.WriteFile(path+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0644)
 	if err != nil {
-		fmt.Printf("Error cleaning up all notifications: %s\n", err)
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// prune drops acknowledged notifications past their maximum age and the
+// oldest ones beyond MAX_NOTIFICATIONS_PER_LINK.
+func prune(history []*Notification) []*Notification {
+	maxAge := time.Duration(maxAcknowledgedAge.Load())
+	if maxAge <= 0 {
+		maxAge = consts.MAX_NOTIFICATION_TIME
+	}
+	maxNotificationTime := time.Now().Add(-1 * maxAge)
+	var kept []*Notification
+	for _, n := range history {
+		if n.Acknowledged != "" {
+			acknowledged, err := utils.ParseRFC3339Time(n.Acknowledged)
+			if err == nil && acknowledged.Before(maxNotificationTime) {
+				continue
+			}
+		}
+		kept = append(kept, n)
+	}
+	if len(kept) > MAX_NOTIFICATIONS_PER_LINK {
+		kept = kept[len(kept)-MAX_NOTIFICATIONS_PER_LINK:]
+	}
+	return kept
+}
+
+func listLinks(homeDirPath string) ([]string, error) {
+	var links []string
+	entries, err := os.ReadDir(filepath.Join(homeDirPath, NOTIFICATIONS_DIR))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return links, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		links = append(links, entry.Name())
 	}
+	return links, nil
 }
 
 func createNotificationsDir(homeDirPath string) error {
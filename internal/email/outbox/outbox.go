@@ -0,0 +1,367 @@
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/email/storage"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* The outbox holds notifications the client still owes to readers of
+ * stored messages, one file per reader and message in
+ * ~/.email2/<address>/outbox/<id>:
+ *
+ *	Reader: reader@example.com
+ *	Message-ID: <message id>
+ *	Status: pending
+ *	Enqueued: <RFC3339 timestamp>
+ *	Attempts: 2
+ *	Next-Attempt: <RFC3339 timestamp>
+ *	Last-Host: https://mail.example.com
+ *	Last-Error: response code 503
+ *
+ * Hosts that failed are backed off for all entries, in outbox/.hosts:
+ *
+ *	host,failures,retry-after
+ ************************************************************************/
+
+const OUTBOX_DIR = "outbox"
+const OUTBOX_HOSTS_FILENAME = ".hosts"
+const OUTBOX_ID_LENGTH = 16
+const OUTBOX_COLUMN_SEPARATOR = ","
+
+const FIELD_READER = "Reader"
+const FIELD_MESSAGE_ID = "Message-ID"
+const FIELD_STATUS = "Status"
+const FIELD_ENQUEUED = "Enqueued"
+const FIELD_ATTEMPTS = "Attempts"
+const FIELD_NEXT_ATTEMPT = "Next-Attempt"
+const FIELD_LAST_HOST = "Last-Host"
+const FIELD_LAST_ERROR = "Last-Error"
+
+const STATUS_PENDING = "pending"
+const STATUS_DELIVERED = "delivered"
+const STATUS_FAILED = "failed"
+
+// Entries give up after this many attempts
+const MAX_ATTEMPTS = 12
+
+const MIN_BACKOFF = time.Minute
+const MAX_BACKOFF = 6 * time.Hour
+
+// Delivered and failed entries are kept this long for status reports
+const MAX_FINISHED_TIME = 7 * 24 * time.Hour
+
+var ErrorNoEntry = errors.New("no such outbox entry")
+
+type Entry struct {
+	ID          string
+	Reader      string
+	MessageID   string
+	Status      string
+	Enqueued    string
+	Attempts    int
+	NextAttempt string
+	LastHost    string
+	LastError   string
+}
+
+type HostState struct {
+	Host       string
+	Failures   int
+	RetryAfter time.Time
+}
+
+func outboxPath(userAddress string) (string, error) {
+	homePath, err := storage.LocalHomePath(userAddress)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homePath, OUTBOX_DIR), nil
+}
+
+// Backoff doubles from MIN_BACKOFF with every failure, up to MAX_BACKOFF.
+func Backoff(failures int) time.Duration {
+	backoff := MIN_BACKOFF
+	for i := 1; i < failures && backoff < MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	if backoff > MAX_BACKOFF {
+		backoff = MAX_BACKOFF
+	}
+	return backoff
+}
+
+// Enqueue adds a pending notification of the reader about the message,
+// unless one is pending already.
+func Enqueue(userAddress, readerAddress, messageID string) (*Entry, error) {
+	entries, err := List(userAddress)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Status == STATUS_PENDING && e.Reader == readerAddress && e.MessageID == messageID {
+			return e, nil
+		}
+	}
+
+	id, err := crypto.GenerateRandomString(OUTBOX_ID_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	now := utils.ToRFC3339String(utils.TimestampNow())
+	e := &Entry{
+		ID:          id,
+		Reader:      readerAddress,
+		MessageID:   messageID,
+		Status:      STATUS_PENDING,
+		Enqueued:    now,
+		NextAttempt: now,
+	}
+	return e, Save(userAddress, e)
+}
+
+func Save(userAddress string, e *Entry) error {
+	path, err := outboxPath(userAddress)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path, 0700)
+	if err != nil {
+		return err
+	}
+	lines := []string{
+		FIELD_READER + ": " + e.Reader,
+		FIELD_MESSAGE_ID + ": " + e.MessageID,
+		FIELD_STATUS + ": " + e.Status,
+		FIELD_ENQUEUED + ": " + e.Enqueued,
+		FIELD_ATTEMPTS + ": " + strconv.Itoa(e.Attempts),
+		FIELD_NEXT_ATTEMPT + ": " + e.NextAttempt,
+		FIELD_LAST_HOST + ": " + e.LastHost,
+		FIELD_LAST_ERROR + ": " + strings.ReplaceAll(e.LastError, "\n", " "),
+	}
+	entryPath := filepath.Join(path, e.ID)
+	err = os.WriteFile(entryPath+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(entryPath+".tmp", entryPath)
+}
+
+func Load(userAddress, id string) (*Entry, error) {
+	path, err := outboxPath(userAddress)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(path, id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrorNoEntry
+		}
+		return nil, err
+	}
+
+	e := Entry{ID: id}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case FIELD_READER:
+			e.Reader = value
+		case FIELD_MESSAGE_ID:
+			e.MessageID = value
+		case FIELD_STATUS:
+			e.Status = value
+		case FIELD_ENQUEUED:
+			e.Enqueued = value
+		case FIELD_ATTEMPTS:
+			e.Attempts, _ = strconv.Atoi(value)
+		case FIELD_NEXT_ATTEMPT:
+			e.NextAttempt = value
+		case FIELD_LAST_HOST:
+			e.LastHost = value
+		case FIELD_LAST_ERROR:
+			e.LastError = value
+		}
+	}
+	return &e, scanner.Err()
+}
+
+// List returns all entries, oldest first.
+func List(userAddress string) ([]*Entry, error) {
+	var result []*Entry
+	path, err := outboxPath(userAddress)
+	if err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		e, err := Load(userAddress, name)
+		if err != nil {
+			continue
+		}
+		result = append(result, e)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Enqueued < result[j].Enqueued
+	})
+	return result, nil
+}
+
+// Due tells whether a pending entry may be attempted now.
+func (e *Entry) Due(now time.Time) bool {
+	if e.Status != STATUS_PENDING {
+		return false
+	}
+	next, err := utils.ParseRFC3339Time(e.NextAttempt)
+	return err != nil || !next.After(now)
+}
+
+func (e *Entry) Delivered(host string) {
+	e.Attempts++
+	e.Status = STATUS_DELIVERED
+	e.LastHost = host
+	e.LastError = ""
+	e.NextAttempt = ""
+}
+
+// Failed schedules the next attempt, or gives up when permanent is set or
+// the attempts are used up.
+func (e *Entry) Failed(host string, err error, permanent bool) {
+	e.Attempts++
+	e.LastHost = host
+	e.LastError = err.Error()
+	if permanent || e.Attempts >= MAX_ATTEMPTS {
+		e.Status = STATUS_FAILED
+		e.NextAttempt = ""
+		return
+	}
+	e.NextAttempt = utils.ToRFC3339String(utils.TimestampNow().Add(Backoff(e.Attempts)))
+}
+
+// Retry puts a failed entry back in the queue.
+func (e *Entry) Retry() {
+	e.Status = STATUS_PENDING
+	e.Attempts = 0
+	e.NextAttempt = utils.ToRFC3339String(utils.TimestampNow())
+}
+
+// Prune removes delivered and failed entries older than MAX_FINISHED_TIME.
+func Prune(userAddress string) error {
+	entries, err := List(userAddress)
+	if err != nil {
+		return err
+	}
+	path, err := outboxPath(userAddress)
+	if err != nil {
+		return err
+	}
+	maxFinishedTime := time.Now().Add(-1 * MAX_FINISHED_TIME)
+	for _, e := range entries {
+		if e.Status == STATUS_PENDING {
+			continue
+		}
+		enqueued, err := utils.ParseRFC3339Time(e.Enqueued)
+		if err != nil || enqueued.Before(maxFinishedTime) {
+			if err := os.Remove(filepath.Join(path, e.ID)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func LoadHosts(userAddress string) (map[string]*HostState, error) {
+	hosts := make(map[string]*HostState)
+	path, err := outboxPath(userAddress)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(path, OUTBOX_HOSTS_FILENAME))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hosts, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), OUTBOX_COLUMN_SEPARATOR)
+		if len(parts) != 3 {
+			continue
+		}
+		failures, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		retryAfter, err := utils.ParseRFC3339Time(parts[2])
+		if err != nil {
+			continue
+		}
+		hosts[parts[0]] = &HostState{Host: parts[0], Failures: failures, RetryAfter: *retryAfter}
+	}
+	return hosts, nil
+}
+
+func SaveHosts(userAddress string, hosts map[string]*HostState) error {
+	path, err := outboxPath(userAddress)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path, 0700)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, h := range hosts {
+		lines = append(lines, strings.Join([]string{h.Host, strconv.Itoa(h.Failures), utils.ToRFC3339String(h.RetryAfter)}, OUTBOX_COLUMN_SEPARATOR)+"\n")
+	}
+	sort.Strings(lines)
+	hostsPath := filepath.Join(path, OUTBOX_HOSTS_FILENAME)
+	err = os.WriteFile(hostsPath+".tmp", []byte(strings.Join(lines, "")), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(hostsPath+".tmp", hostsPath)
+}
+
+// HostAvailable tells whether the host is not backed off at the moment.
+func HostAvailable(hosts map[string]*HostState, host string, now time.Time) bool {
+	h, found := hosts[host]
+	return !found || !h.RetryAfter.After(now)
+}
+
+func HostFailed(hosts map[string]*HostState, host string) {
+	h, found := hosts[host]
+	if !found {
+		h = &HostState{Host: host}
+		hosts[host] = h
+	}
+	h.Failures++
+	h.RetryAfter = utils.TimestampNow().Add(Backoff(h.Failures))
+}
+
+func HostSucceeded(hosts map[string]*HostState, host string) {
+	delete(hosts, host)
+}
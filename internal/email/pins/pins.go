@@ -0,0 +1,232 @@
+package pins
+
+import (
+	"bufio"
+	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/profile"
+	"email.mercata.com/internal/email/storage"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* Trust on first use pinning of contact keys
+ *
+ * The first profile seen for a contact pins its keys, in one file per
+ * address under ~/.email2/.pins:
+ *
+ *	Address: bob@example.com
+ *	Signing-Key: BASE64
+ *	Encryption-Key: BASE64
+ *	First-Seen: 2023-08-01T10:00:00Z
+ *	Updated: 2023-08-01T10:00:00Z
+ *	Verified: no
+ *
+ * Later profiles must present the pinned keys. A new signing key is
+ * accepted only when the profile names the pinned one as Last-Signing-Key,
+ * any other change needs the user to verify the contact again.
+ ****************************************************************************/
+
+const PINS_DIRECTORY = ".pins"
+
+const PIN_FIELD_ADDRESS = "Address"
+const PIN_FIELD_SIGNING_KEY = "Signing-Key"
+const PIN_FIELD_ENCRYPTION_KEY = "Encryption-Key"
+const PIN_FIELD_FIRST_SEEN = "First-Seen"
+const PIN_FIELD_UPDATED = "Updated"
+const PIN_FIELD_VERIFIED = "Verified"
+
+const PIN_STATUS_NEW = "new"
+const PIN_STATUS_MATCH = "match"
+const PIN_STATUS_ROTATED = "rotated"
+const PIN_STATUS_CHANGED = "changed"
+
+var ErrorKeysChanged = errors.New("contact keys changed without signing key continuity, verify the contact before use")
+var ErrorNoProfileKeys = errors.New("profile has no keys to pin")
+
+type Pin struct {
+	Address             string
+	SigningKeyBase64    string
+	EncryptionKeyBase64 string
+	FirstSeen           string
+	Updated             string
+	Verified            bool
+}
+
+func PinsPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, storage.LOCAL_EMAIL_DIRECTORY, PINS_DIRECTORY), nil
+}
+
+func PinPath(emailAddress string) (string, error) {
+	pinsPath, err := PinsPath()
+	if err != nil {
+		return "", err
+	}
+	safeAddress, _, _ := address.ParseEmailAddress(emailAddress)
+	return filepath.Join(pinsPath, safeAddress), nil
+}
+
+// Load returns the pin of the address, nil when none was made yet.
+func Load(emailAddress string) (*Pin, error) {
+	pinPath, err := PinPath(emailAddress)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(pinPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var pin Pin
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case PIN_FIELD_ADDRESS:
+			pin.Address = value
+		case PIN_FIELD_SIGNING_KEY:
+			pin.SigningKeyBase64 = value
+		case PIN_FIELD_ENCRYPTION_KEY:
+			pin.EncryptionKeyBase64 = value
+		case PIN_FIELD_FIRST_SEEN:
+			pin.FirstSeen = value
+		case PIN_FIELD_UPDATED:
+			pin.Updated = value
+		case PIN_FIELD_VERIFIED:
+			pin.Verified = strings.ToLower(value) == "yes"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &pin, nil
+}
+
+func Store(pin *Pin) error {
+	pinPath, err := PinPath(pin.Address)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(pinPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	verified := "no"
+	if pin.Verified {
+		verified = "yes"
+	}
+	lines := []string{
+		PIN_FIELD_ADDRESS + ": " + pin.Address,
+		PIN_FIELD_SIGNING_KEY + ": " + pin.SigningKeyBase64,
+		PIN_FIELD_ENCRYPTION_KEY + ": " + pin.EncryptionKeyBase64,
+		PIN_FIELD_FIRST_SEEN + ": " + pin.FirstSeen,
+		PIN_FIELD_UPDATED + ": " + pin.Updated,
+		PIN_FIELD_VERIFIED + ": " + verified,
+	}
+	return os.WriteFile(pinPath, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// Compare tells how the profile keys relate to the pin, without storing anything.
+func Compare(pin *Pin, p *profile.Profile) string {
+	if pin == nil {
+		return PIN_STATUS_NEW
+	}
+	if pin.SigningKeyBase64 == p.PublicSigningKeyBase64 && pin.EncryptionKeyBase64 == p.PublicEncryptionKeyBase64 {
+		return PIN_STATUS_MATCH
+	}
+	if pin.SigningKeyBase64 != p.PublicSigningKeyBase64 && pin.SigningKeyBase64 == p.LastSigningKeyBase64 {
+		return PIN_STATUS_ROTATED
+	}
+	return PIN_STATUS_CHANGED
+}
+
+// Observe checks the keys of a fetched profile against the pin and pins
+// them when first seen or rotated with continuity. Changed keys are never
+// pinned; the error is returned with the status when they had been
+// verified, otherwise the caller decides how loudly to warn.
+func Observe(p *profile.Profile) (string, error) {
+	if p.PublicSigningKeyBase64 == "" || p.PublicEncryptionKeyBase64 == "" {
+		return "", ErrorNoProfileKeys
+	}
+	pin, err := Load(p.Address)
+	if err != nil {
+		return "", err
+	}
+
+	now := utils.ToRFC3339String(utils.TimestampNow())
+	status := Compare(pin, p)
+	switch status {
+	case PIN_STATUS_NEW:
+		return status, Store(&Pin{
+			Address:             p.Address,
+			SigningKeyBase64:    p.PublicSigningKeyBase64,
+			EncryptionKeyBase64: p.PublicEncryptionKeyBase64,
+			FirstSeen:           now,
+			Updated:             now,
+		})
+
+	case PIN_STATUS_ROTATED:
+		pin.SigningKeyBase64 = p.PublicSigningKeyBase64
+		pin.EncryptionKeyBase64 = p.PublicEncryptionKeyBase64
+		pin.Updated = now
+		// Continuity carries the trust over, not the verification
+		pin.Verified = false
+		return status, Store(pin)
+
+	case PIN_STATUS_CHANGED:
+		if pin.Verified {
+			return status, ErrorKeysChanged
+		}
+	}
+	return status, nil
+}
+
+// Verify pins the profile keys as verified by the user, replacing any pin.
+func Verify(p *profile.Profile) error {
+	if p.PublicSigningKeyBase64 == "" || p.PublicEncryptionKeyBase64 == "" {
+		return ErrorNoProfileKeys
+	}
+	pin, err := Load(p.Address)
+	if err != nil {
+		return err
+	}
+	now := utils.ToRFC3339String(utils.TimestampNow())
+	if pin == nil {
+		pin = &Pin{Address: p.Address, FirstSeen: now}
+	}
+	pin.SigningKeyBase64 = p.PublicSigningKeyBase64
+	pin.EncryptionKeyBase64 = p.PublicEncryptionKeyBase64
+	pin.Updated = now
+	pin.Verified = true
+	return Store(pin)
+}
+
+// CheckKeys observes keys resolved other than from a fetched profile, such
+// as of readers about to be encrypted to. Without a profile there is no
+// Last-Signing-Key to continue from, so changed keys are refused whether
+// or not they had been verified.
+func CheckKeys(emailAddress, signingKeyBase64, encryptionKeyBase64 string) (string, error) {
+	var p profile.Profile
+	p.Address = emailAddress
+	p.PublicSigningKeyBase64 = signingKeyBase64
+	p.PublicEncryptionKeyBase64 = encryptionKeyBase64
+	status, err := Observe(&p)
+	if err == nil && status == PIN_STATUS_CHANGED {
+		err = ErrorKeysChanged
+	}
+	return status, err
+}
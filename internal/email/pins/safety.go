@@ -0,0 +1,51 @@
+package pins
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+/* Safety numbers let two users compare their keys in person or over a
+ * call. Each side contributes 30 digits derived from its address and
+ * signing key; the two halves are ordered so both users see the same
+ * number.
+ ************************************************************************/
+
+const SAFETY_NUMBER_VERSION = 0
+const SAFETY_NUMBER_ITERATIONS = 5200
+const SAFETY_NUMBER_CHUNKS = 6
+const SAFETY_NUMBER_CHUNK_MODULUS = 100000
+
+func SafetyNumber(localAddress string, localSigningKey []byte, remoteAddress string, remoteSigningKey []byte) string {
+	local := safetyNumberHalf(localAddress, localSigningKey)
+	remote := safetyNumberHalf(remoteAddress, remoteSigningKey)
+	if local > remote {
+		local, remote = remote, local
+	}
+	return local + " " + remote
+}
+
+func safetyNumberHalf(address string, signingKey []byte) string {
+	version := make([]byte, 2)
+	binary.BigEndian.PutUint16(version, SAFETY_NUMBER_VERSION)
+
+	hash := append(version, signingKey...)
+	hash = append(hash, []byte(address)...)
+	for i := 0; i < SAFETY_NUMBER_ITERATIONS; i++ {
+		sum := sha512.Sum512(append(hash, signingKey...))
+		hash = sum[:]
+	}
+
+	chunks := make([]string, SAFETY_NUMBER_CHUNKS)
+	for i := range chunks {
+		// Five bytes per chunk, reduced to five digits
+		var value uint64
+		for _, b := range hash[i*5 : i*5+5] {
+			value = value<<8 | uint64(b)
+		}
+		chunks[i] = fmt.Sprintf("%05d", value%SAFETY_NUMBER_CHUNK_MODULUS)
+	}
+	return strings.Join(chunks, " ")
+}
@@ -10,3 +10,4 @@ const PROFILE_FIELD_AWAY = "Away"
 const PROFILE_FIELD_AWAY_WARNING = "Away-Warning"
 const PROFILE_FIELD_UPDATED = "Updated"
 const PROFILE_LAST_SEEN_PUBLIC = "Last-Seen-Public"
+const PROFILE_FIELD_NOTIFICATION_STAMP_BITS = "Notification-Stamp-Bits"
@@ -8,6 +8,7 @@ import (
 	addressPkg "email.mercata.com/internal/email/address"
 	"email.mercata.com/internal/email/mca"
 	"email.mercata.com/internal/email/user"
+	"email.mercata.com/internal/transparency"
 	"email.mercata.com/internal/utils"
 	"encoding/base64"
 	"errors"
@@ -17,6 +18,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -41,6 +43,9 @@ type Profile struct {
 	PublicAccess           bool
 	LastSeenPublicTracking bool
 
+	// Proof of work demanded from notifiers without a link
+	NotificationStampBits int
+
 	LastEncryptionKeyFingerprint string
 	LastSigningKeyFingerprint    string
 	LastEncryptionKeyBase64      string
@@ -49,6 +54,11 @@ type Profile struct {
 	LastSigningKey               [32]byte
 
 	RemoteBody *[]byte
+
+	// Key transparency data served along remote profiles
+	RemoteHost      string
+	KeyLogTreeHead  string
+	KeyLogInclusion string
 }
 
 func CreateProfileDir(userHomeDirPath string) error {
@@ -76,26 +86,32 @@ func GetRemoteProfile(address, domain, localPart string) (*Profile, error) {
 	for _, host := range hosts {
 		profileURI := fmt.Sprintf("https://%s/%s/%s/%s/profile", host, consts.PUBLIC_API_PATH_PREFIX, domain, localPart)
 		resp, err := http.Get(profileURI)
+		if err != nil {
+			continue
+		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
 			return nil, errUnavailable
 		}
-		if err == nil {
-			body, readErr := io.ReadAll(resp.Body)
-			if readErr != nil {
-				continue
-			}
-			profile := Profile{RemoteBody: &body}
-			profile.User.Address = address
-			profile.User.Domain = domain
-			profile.User.LocalPart = localPart
-
-			err = ParseProfile(&profile, body)
-			if err != nil {
-				return nil, err
-			}
-			return &profile, nil
+		body, readErr := io.ReadAll(resp.Body)
+		if readErr != nil {
+			continue
+		}
+		profile := Profile{
+			RemoteBody:      &body,
+			RemoteHost:      host,
+			KeyLogTreeHead:  resp.Header.Get(transparency.KEY_LOG_TREE_HEAD_HEADER),
+			KeyLogInclusion: resp.Header.Get(transparency.KEY_LOG_INCLUSION_HEADER),
 		}
+		profile.User.Address = address
+		profile.User.Domain = domain
+		profile.User.LocalPart = localPart
+
+		err = ParseProfile(&profile, body)
+		if err != nil {
+			return nil, err
+		}
+		return &profile, nil
 	}
 	return nil, errUnavailable
 }
@@ -236,6 +252,12 @@ func assignValueToField(profile *Profile, key, value string) error {
 	case PROFILE_FIELD_NAME:
 		profile.Name = value
 
+	case PROFILE_FIELD_NOTIFICATION_STAMP_BITS:
+		profile.NotificationStampBits, err = strconv.Atoi(strval)
+		if err != nil || profile.NotificationStampBits < 0 {
+			return errors.New("bad notification stamp bits")
+		}
+
 	case PROFILE_FIELD_ENCRYPTION_KEY:
 		profile.User.PublicEncryptionKeyBase64, profile.User.PublicEncryptionKey, profile.User.PublicEncryptionKeyFingerprint, err = extractKeyData(crypto.ANONYMOUS_ENCRYPTION_CIPHER, value)
 		if err != nil {
@@ -282,6 +304,10 @@ func extractKeyData(algorithm string, strkey string) (string, [32]byte, string,
 	if err != nil {
 		return strkey, key, "", err
 	}
+	if len(data) != len(key) {
+		return strkey, key, "", errors.New("bad key length in Key data")
+	}
+	copy(key[:], data)
 	return value, key, crypto.Fingerprint(data[:]), nil
 }
 
@@ -0,0 +1,269 @@
+package requests
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/utils"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* Contact requests let strangers ask a closed profile to be added. Each
+ * unknown link may hold one request per signing key, in
+ * <home>/requests/<link>/<requester key fingerprint>:
+ *
+ *	Requester-Key: <signing key fingerprint>
+ *	Requested: <RFC3339 timestamp>
+ *	Introduction: <anonymously encrypted introduction>
+ *
+ * Anyone can make the link of any two addresses, so a request neither
+ * proves who made it nor keeps others from asking on the same link. A key
+ * asking again replaces its own request only. The introduction holds the
+ * address of the requester, a signature by their signing key and a short
+ * message, encrypted to the owner, so the server learns none of them. The
+ * owner checks the key against the profile of the claimed address.
+ * Requests older than MAX_CONTACT_REQUEST_TIME go with Expire.
+ ************************************************************************/
+
+const REQUESTS_DIR = "requests"
+const REQUESTS_COLUMN_SEPARATOR = ","
+
+const REQUEST_FIELD_REQUESTER_KEY = "Requester-Key"
+const REQUEST_FIELD_REQUESTED = "Requested"
+const REQUEST_FIELD_INTRODUCTION = "Introduction"
+
+// Decrypted introductions start with the address of the requester and
+// their signature of the introduction
+const INTRODUCTION_FIELD_FROM = "From"
+const INTRODUCTION_FIELD_SIGNATURE = "Signature"
+
+var ErrorNoRequest = errors.New("no such contact request")
+
+type Request struct {
+	Link         string
+	RequesterKey string
+	Requested    string
+	Introduction string
+}
+
+type Introduction struct {
+	From      string
+	Signature string
+	Message   string
+}
+
+func LinkRequestsPath(homeDirPath, link string) string {
+	return filepath.Join(homeDirPath, REQUESTS_DIR, link)
+}
+
+func RequestPath(homeDirPath, link, requesterKey string) string {
+	return filepath.Join(LinkRequestsPath(homeDirPath, link), requesterKey)
+}
+
+// ValidRequesterKey tells whether the key is shaped as a signing key
+// fingerprint, as it names a file.
+func ValidRequesterKey(requesterKey string) bool {
+	decoded, err := hex.DecodeString(requesterKey)
+	return err == nil && len(decoded) == sha256.Size && strings.ToLower(requesterKey) == requesterKey
+}
+
+// Exists tells whether any request is pending on the link
+func Exists(homeDirPath, link string) (bool, error) {
+	return utils.FilePathExists(LinkRequestsPath(homeDirPath, link))
+}
+
+func Store(homeDirPath, link, requesterKey, introduction string) error {
+	err := os.MkdirAll(LinkRequestsPath(homeDirPath, link), 0755)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{
+		REQUEST_FIELD_REQUESTER_KEY + ": " + requesterKey,
+		REQUEST_FIELD_REQUESTED + ": " + utils.ToRFC3339String(utils.TimestampNow()),
+		REQUEST_FIELD_INTRODUCTION + ": " + introduction,
+	}
+	return os.WriteFile(RequestPath(homeDirPath, link, requesterKey), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func Load(homeDirPath, link, requesterKey string) (*Request, error) {
+	data, err := os.ReadFile(RequestPath(homeDirPath, link, requesterKey))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrorNoRequest
+		}
+		return nil, err
+	}
+
+	request := Request{Link: link}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case REQUEST_FIELD_REQUESTER_KEY:
+			request.RequesterKey = value
+		case REQUEST_FIELD_REQUESTED:
+			request.Requested = value
+		case REQUEST_FIELD_INTRODUCTION:
+			request.Introduction = value
+		}
+	}
+	return &request, scanner.Err()
+}
+
+// ListLink returns the requests pending on the link, one per requester key
+func ListLink(homeDirPath, link string) ([]*Request, error) {
+	var result []*Request
+
+	entries, err := os.ReadDir(LinkRequestsPath(homeDirPath, link))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		request, err := Load(homeDirPath, link, entry.Name())
+		if err != nil {
+			continue
+		}
+		result = append(result, request)
+	}
+	return result, nil
+}
+
+func ListAll(homeDirPath string) ([]*Request, error) {
+	var result []*Request
+
+	entries, err := os.ReadDir(filepath.Join(homeDirPath, REQUESTS_DIR))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		linkRequests, err := ListLink(homeDirPath, entry.Name())
+		if err != nil {
+			continue
+		}
+		result = append(result, linkRequests...)
+	}
+	return result, nil
+}
+
+// Delete drops all requests pending on the link
+func Delete(homeDirPath, link string) error {
+	exists, err := Exists(homeDirPath, link)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrorNoRequest
+	}
+	return os.RemoveAll(LinkRequestsPath(homeDirPath, link))
+}
+
+// DeleteKey drops the request of one requester key, and the link with it
+// when no other is left
+func DeleteKey(homeDirPath, link, requesterKey string) error {
+	err := os.Remove(RequestPath(homeDirPath, link, requesterKey))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrorNoRequest
+		}
+		return err
+	}
+	// Fails as long as other requests are left
+	_ = os.Remove(LinkRequestsPath(homeDirPath, link))
+	return nil
+}
+
+// ToLine renders the request as listed to the owner:
+// link,requested,requester-key,introduction
+func (r *Request) ToLine() string {
+	return strings.Join([]string{r.Link, r.Requested, r.RequesterKey, r.Introduction}, REQUESTS_COLUMN_SEPARATOR)
+}
+
+func FromLine(line string) (*Request, error) {
+	parts := strings.SplitN(line, REQUESTS_COLUMN_SEPARATOR, 4)
+	if len(parts) != 4 {
+		return nil, errors.New("bad contact request line")
+	}
+	return &Request{Link: parts[0], Requested: parts[1], RequesterKey: parts[2], Introduction: parts[3]}, nil
+}
+
+// IntroductionSignedData is what the requester signs: both addresses and
+// the message, so the introduction cannot be passed on to someone else.
+func IntroductionSignedData(fromAddress, toAddress, message string) []byte {
+	return []byte(strings.Join([]string{fromAddress, toAddress, message}, "\n"))
+}
+
+// MakeIntroduction and ParseIntroduction shape the plaintext introduction:
+// the From and Signature lines, a blank line and the message.
+func MakeIntroduction(fromAddress, signature, message string) []byte {
+	return []byte(INTRODUCTION_FIELD_FROM + ": " + fromAddress + "\n" +
+		INTRODUCTION_FIELD_SIGNATURE + ": " + signature + "\n\n" + message)
+}
+
+func ParseIntroduction(data []byte) (*Introduction, error) {
+	header, message, _ := strings.Cut(string(data), "\n\n")
+	introduction := Introduction{Message: message}
+	for _, line := range strings.Split(header, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case INTRODUCTION_FIELD_FROM:
+			introduction.From = value
+		case INTRODUCTION_FIELD_SIGNATURE:
+			introduction.Signature = value
+		}
+	}
+	if introduction.From == "" {
+		return nil, errors.New("introduction lacks the requester address")
+	}
+	return &introduction, nil
+}
+
+// Expire removes the requests older than MAX_CONTACT_REQUEST_TIME and the
+// links left without any
+func Expire(homeDirPath string) error {
+	requestsPath := filepath.Join(homeDirPath, REQUESTS_DIR)
+	exists, err := utils.FilePathExists(requestsPath)
+	if err != nil || !exists {
+		return err
+	}
+	maxRequestTime := time.Now().Add(-1 * consts.MAX_CONTACT_REQUEST_TIME)
+	err = utils.DeleteFilesOlderThan(requestsPath, maxRequestTime)
+	if err != nil {
+		return err
+	}
+	links, err := utils.ListDirectories(requestsPath)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		// Fails for links still holding requests, which is fine
+		_ = os.Remove(LinkRequestsPath(homeDirPath, link))
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/* Blocklist of an account, one entry per line in <home>/blocklist:
+ *
+ *	link:<link>
+ *	key:<signing key fingerprint>
+ *
+ * Blocked callers are answered as if nothing was wrong, so they cannot
+ * tell that they were blocked.
+ ************************************************************************/
+
+const BLOCKLIST_FILENAME = "blocklist"
+const BLOCK_KIND_SEPARATOR = ":"
+const BLOCK_KIND_LINK = "link"
+const BLOCK_KIND_KEY = "key"
+
+var ErrorBadBlockEntry = errors.New("bad block entry, expected link:<link> or key:<fingerprint>")
+
+var blocklistMutex sync.Mutex
+
+func BlocklistPath(userHomeDirPath string) string {
+	return filepath.Join(userHomeDirPath, BLOCKLIST_FILENAME)
+}
+
+func BlockEntry(kind, value string) (string, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	value = strings.ToLower(strings.TrimSpace(value))
+	if kind != BLOCK_KIND_LINK && kind != BLOCK_KIND_KEY {
+		return "", ErrorBadBlockEntry
+	}
+	if value == "" || strings.ContainsAny(value, BLOCK_KIND_SEPARATOR+"/\n\r ") {
+		return "", ErrorBadBlockEntry
+	}
+	if kind == BLOCK_KIND_LINK && len(value) != LINK_FILENAME_LENGTH {
+		return "", ErrorBadBlockEntry
+	}
+	return kind + BLOCK_KIND_SEPARATOR + value, nil
+}
+
+func ListBlocks(userHomeDirPath string) ([]string, error) {
+	var entries []string
+	file, err := os.Open(BlocklistPath(userHomeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+func AddBlock(userHomeDirPath, kind, value string) error {
+	entry, err := BlockEntry(kind, value)
+	if err != nil {
+		return err
+	}
+
+	blocklistMutex.Lock()
+	defer blocklistMutex.Unlock()
+
+	entries, err := ListBlocks(userHomeDirPath)
+	if err != nil {
+		return err
+	}
+	for _, existing := range entries {
+		if existing == entry {
+			return nil
+		}
+	}
+	return writeBlocks(userHomeDirPath, append(entries, entry))
+}
+
+func RemoveBlock(userHomeDirPath, kind, value string) error {
+	entry, err := BlockEntry(kind, value)
+	if err != nil {
+		return err
+	}
+
+	blocklistMutex.Lock()
+	defer blocklistMutex.Unlock()
+
+	entries, err := ListBlocks(userHomeDirPath)
+	if err != nil {
+		return err
+	}
+	var remaining []string
+	for _, existing := range entries {
+		if existing != entry {
+			remaining = append(remaining, existing)
+		}
+	}
+	return writeBlocks(userHomeDirPath, remaining)
+}
+
+// IsBlocked tells whether the link or the signing key fingerprint of a
+// caller is on the blocklist of the account.
+func IsBlocked(userHomeDirPath, link, fingerprint string) (bool, error) {
+	entries, err := ListBlocks(userHomeDirPath)
+	if err != nil {
+		return false, err
+	}
+	linkEntry := BLOCK_KIND_LINK + BLOCK_KIND_SEPARATOR + strings.ToLower(link)
+	keyEntry := BLOCK_KIND_KEY + BLOCK_KIND_SEPARATOR + strings.ToLower(fingerprint)
+	for _, entry := range entries {
+		if (link != "" && entry == linkEntry) || (fingerprint != "" && entry == keyEntry) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func writeBlocks(userHomeDirPath string, entries []string) error {
+	content := strings.Join(entries, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	tmpPath := BlocklistPath(userHomeDirPath) + ".tmp"
+	err := os.WriteFile(tmpPath, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, BlocklistPath(userHomeDirPath))
+}
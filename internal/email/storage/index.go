@@ -80,12 +80,7 @@ func WriteMessageIndex(homeDirPath, link, fingerprint, stream, messageID string)
 	}()
 
 	messagesIndexPath := IndexPath(homeDirPath)
-	indexLine := strings.Join([]string{
-		link,
-		fingerprint,
-		stream,
-		messageID, // It is important that messageID is last
-	}, MESSAGES_INDEX_COLUMN_SEPARATOR)
+	indexLine := MessageIndexLine(link, fingerprint, stream, messageID)
 
 	exists, err := utils.PrefixExistsInFile(indexLine, messagesIndexPath)
 	if err != nil {
@@ -142,3 +137,35 @@ func RemoveMessageFromIndex(homeDirPath, messageID string) error {
 
 	return nil
 }
+
+func MessageIndexLine(link, fingerprint, stream, messageID string) string {
+	return strings.Join([]string{
+		link,
+		fingerprint,
+		stream,
+		messageID, // It is important that messageID is last
+	}, MESSAGES_INDEX_COLUMN_SEPARATOR)
+}
+
+// RewriteMessagesIndex replaces the index with the given lines, as made by
+// MessageIndexLine.
+func RewriteMessagesIndex(homeDirPath string, lines []string) error {
+	mutex := getIndexFileMutex(homeDirPath)
+	mutex.Lock()
+	defer func() {
+		mutex.Unlock()
+		removeStaleIndexFileMutex(homeDirPath)
+	}()
+
+	messagesIndexPath := IndexPath(homeDirPath)
+	var content strings.Builder
+	for _, line := range lines {
+		content.WriteString(line + "\n")
+	}
+	tempOutputPath := messagesIndexPath + "~"
+	err := os.WriteFile(tempOutputPath, []byte(content.String()), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempOutputPath, messagesIndexPath)
+}
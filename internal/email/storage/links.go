@@ -26,8 +26,12 @@ func UserHasLink(userHomeDirPath, link string) (bool, error) {
 }
 
 func StoreLink(userHomeDirPath, link string, contactData []byte) error {
+	err := os.MkdirAll(LinksPath(userHomeDirPath), 0755)
+	if err != nil {
+		return err
+	}
 	path := LinkPath(userHomeDirPath, link)
-	err := ioutil.WriteFile(path, contactData, 0644)
+	err = ioutil.WriteFile(path, contactData, 0644)
 	if err != nil {
 		return err
 	}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const MESSAGES_INDEX_FILENAME = "index"
@@ -168,6 +169,41 @@ func DeleteMessageDir(userHomeDirPath, messageID string) error {
 	return nil
 }
 
+// ExpireMessages deletes the messages stored before the time and returns
+// how many were deleted.
+func ExpireMessages(userHomeDirPath string, before time.Time) (int, error) {
+	entries, err := os.ReadDir(MessagesPath(userHomeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	deleted := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !utils.ValidMessageID(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return deleted, err
+		}
+		if !info.ModTime().Before(before) {
+			continue
+		}
+		err = DeleteMessageDir(userHomeDirPath, entry.Name())
+		if err != nil {
+			return deleted, err
+		}
+		err = RemoveMessageFromIndex(userHomeDirPath, entry.Name())
+		if err != nil && !os.IsNotExist(err) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
 func MessagesPath(userHomeDirPath string) string {
 	return filepath.Join(userHomeDirPath, MESSAGES_STORE_DIRECTORY)
 }
@@ -3,8 +3,11 @@ package user
 import (
 	"email.mercata.com/internal/crypto"
 	"email.mercata.com/internal/email/address"
+	"email.mercata.com/internal/email/agent"
 	"email.mercata.com/internal/email/keys"
 	linksPkg "email.mercata.com/internal/email/links"
+	"errors"
+	"sync"
 )
 
 type User struct {
@@ -24,6 +27,9 @@ type User struct {
 	PrivateEncryptionKey       [32]byte
 	PrivateSigningKeyBase64    string
 	PrivateSigningKey          [64]byte
+
+	// Set when the private keys are held by the key agent instead
+	agent *agent.Client
 }
 
 type Reader struct {
@@ -37,9 +43,25 @@ func SelfLink(user, domain string) string {
 	return linksPkg.Make(address, address)
 }
 
+var keyAgent *agent.Client
+var keyAgentOnce sync.Once
+
+// LocalUser loads the account keys. When a running key agent has the
+// account unlocked, only the public keys are read and the private key
+// operations are delegated to the agent.
 func LocalUser(emailAddress string) (*User, error) {
 	user := User{}
 	user.Address, user.Domain, user.LocalPart = address.ParseEmailAddress(emailAddress)
+
+	if a := attachedAgent(user.Address); a != nil {
+		user.agent = a
+		err := getLocalPublicKeys(&user)
+		if err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+
 	err := getLocalEncryptionKeys(&user)
 	if err != nil {
 		return nil, err
@@ -51,6 +73,48 @@ func LocalUser(emailAddress string) (*User, error) {
 	return &user, nil
 }
 
+// LocalPublicUser loads the public keys only, for the accounts acting as
+// readers or authors of someone else's operation.
+func LocalPublicUser(emailAddress string) (*User, error) {
+	user := User{}
+	user.Address, user.Domain, user.LocalPart = address.ParseEmailAddress(emailAddress)
+	err := getLocalPublicKeys(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (u *User) SignData(data []byte) (string, error) {
+	if u.agent != nil {
+		return u.agent.SignData(u.Address, data)
+	}
+	if u.PrivateSigningKeyBase64 == "" {
+		return "", errors.New("private signing key not loaded")
+	}
+	return crypto.SignData(u.PublicSigningKey, u.PrivateSigningKey, data), nil
+}
+
+func (u *User) DecryptAnonymous(ciphertext string) ([]byte, error) {
+	if u.agent != nil {
+		return u.agent.DecryptAnonymous(u.Address, ciphertext)
+	}
+	if u.PrivateEncryptionKeyBase64 == "" {
+		return nil, errors.New("private encryption key not loaded")
+	}
+	return crypto.DecryptAnonymous(u.PrivateEncryptionKey, u.PublicEncryptionKey, ciphertext)
+}
+
+func attachedAgent(emailAddress string) *agent.Client {
+	keyAgentOnce.Do(func() {
+		keyAgent, _ = agent.Dial()
+	})
+	if keyAgent == nil || !keyAgent.HasAccount(emailAddress) {
+		return nil
+	}
+	return keyAgent
+}
+
 func AsReader(user *User) *Reader {
 	var reader Reader
 	reader.Link = linksPkg.Make(user.Address, user.Address)
@@ -58,6 +122,20 @@ func AsReader(user *User) *Reader {
 	return &reader
 }
 
+func getLocalPublicKeys(user *User) (err error) {
+	user.PublicEncryptionKeyBase64, user.PublicEncryptionKey, err = keys.GetLocalEncryptionPublicKey(user.Address)
+	if err != nil {
+		return err
+	}
+	user.PublicEncryptionKeyFingerprint = crypto.Fingerprint(user.PublicEncryptionKey[:])
+	user.PublicSigningKeyBase64, user.PublicSigningKey, err = keys.GetLocalSigningPublicKey(user.Address)
+	if err != nil {
+		return err
+	}
+	user.PublicSigningKeyFingerprint = crypto.Fingerprint(user.PublicSigningKey[:])
+	return nil
+}
+
 func getLocalEncryptionKeys(user *User) (err error) {
 	user.PrivateEncryptionKeyBase64, user.PrivateEncryptionKey, err = keys.GetLocalEncryptionPrivateKey(user.Address)
 	if err != nil {
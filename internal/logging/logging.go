@@ -0,0 +1,199 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Log entries written as JSON, one object per line:
+ *
+ *	{"time":"...","level":"error","msg":"...","caller":"file.go:42","request_id":"...","account":"..."}
+ *
+ * Loggers carry the fields given to With, such as the ID and the account
+ * of the request they log for, after which come those of the entry. All
+ * loggers derived from one share its output and level, entries below the
+ * level are dropped. Std adapts a logger to packages taking a *log.Logger.
+ ***************************************************************************/
+
+type Level int32
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var LEVEL_NAMES = map[Level]string{
+	DEBUG: "debug",
+	INFO:  "info",
+	WARN:  "warn",
+	ERROR: "error",
+}
+
+var ErrorBadLevel = errors.New("level must be debug, info, warn or error")
+
+func ParseLevel(s string) (Level, error) {
+	for level, name := range LEVEL_NAMES {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return INFO, ErrorBadLevel
+}
+
+func (level Level) String() string {
+	return LEVEL_NAMES[level]
+}
+
+type output struct {
+	mutex sync.Mutex
+	w     io.Writer
+	level atomic.Int32
+}
+
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+func New(w io.Writer, level Level) *Logger {
+	l := &Logger{out: &output{w: w}}
+	l.SetLevel(level)
+	return l
+}
+
+// SetLevel sets the level of the logger and of all derived from it
+func (l *Logger) SetLevel(level Level) {
+	l.out.level.Store(int32(level))
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= Level(l.out.level.Load())
+}
+
+// With returns a logger adding the key and value pairs to every entry
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(2, DEBUG, msg, keyvals...)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(2, INFO, msg, keyvals...)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(2, WARN, msg, keyvals...)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(2, ERROR, msg, keyvals...)
+}
+
+// Log writes the entry with the caller calldepth frames up, 1 being the
+// caller of Log, like log.Output.
+func (l *Logger) Log(calldepth int, level Level, msg string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	caller := ""
+	if _, file, line, ok := runtime.Caller(calldepth); ok {
+		caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	l.write(level, caller, msg, keyvals)
+}
+
+func (l *Logger) write(level Level, caller, msg string, keyvals []interface{}) {
+	var b strings.Builder
+	b.WriteString(`{"time":`)
+	writeValue(&b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeValue(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeValue(&b, msg)
+	if caller != "" {
+		b.WriteString(`,"caller":`)
+		writeValue(&b, caller)
+	}
+	writeFields(&b, l.fields)
+	writeFields(&b, keyvals)
+	b.WriteString("}\n")
+
+	l.out.mutex.Lock()
+	defer l.out.mutex.Unlock()
+	_, _ = io.WriteString(l.out.w, b.String())
+}
+
+func writeFields(b *strings.Builder, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		var value interface{} = "!MISSING"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		b.WriteString(",")
+		writeValue(b, key)
+		b.WriteString(":")
+		writeValue(b, value)
+	}
+}
+
+func writeValue(b *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.Seconds()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	// Written as given, URIs keep their ampersands
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		buf.Reset()
+		_ = encoder.Encode(fmt.Sprint(value))
+	}
+	b.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// Std returns a standard library logger writing entries of the level,
+// with the caller as given by log.Lshortfile.
+func (l *Logger) Std(level Level) *log.Logger {
+	return log.New(&stdWriter{logger: l, level: level}, "", log.Lshortfile)
+}
+
+type stdWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (s *stdWriter) Write(p []byte) (int, error) {
+	if !s.logger.Enabled(s.level) {
+		return len(p), nil
+	}
+	msg := strings.TrimSuffix(string(p), "\n")
+	caller := ""
+	if i := strings.Index(msg, ": "); i > 0 && !strings.Contains(msg[:i], " ") {
+		caller, msg = msg[:i], msg[i+2:]
+	}
+	s.logger.write(s.level, caller, msg, nil)
+	return len(p), nil
+}
@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Metrics kept in memory and written in the Prometheus text format:
+ *
+ *	# HELP openemail_messages_stored_total Messages stored by accounts
+ *	# TYPE openemail_messages_stored_total counter
+ *	openemail_messages_stored_total{domain="example.com"} 42
+ *
+ * Metrics with labels hold one series per combination of label values,
+ * given in the order the labels were named in. Gauges may also be read
+ * when scraped, from a function.
+ ***************************************************************************/
+
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Seconds, from a quick read to a large upload
+var DEFAULT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Sample is a value of a gauge read when scraped
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WritePrometheus writes all metrics in the order they were created.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mutex.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		_ = r.WritePrometheus(w)
+	})
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+// series joins the label values into a key, one value per label
+func (d *desc) series(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, not %d", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// labelPairs formats the labels of the series, with extra pairs after them
+func (d *desc) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+`="`+escape(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter only goes up
+type Counter struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name: name, help: help, kind: "counter", labels: labels}, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	key := c.series(labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[key] += v
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatValue(c.values[key]))
+	}
+}
+
+// Gauge goes up and down, or is read from a function when scraped
+type Gauge struct {
+	desc
+	mutex  sync.Mutex
+	values map[string]float64
+	read   func() []Sample
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, values: make(map[string]float64)}
+	r.register(g)
+	return g
+}
+
+// NewGaugeFunc creates a gauge whose samples are read when scraped
+func (r *Registry) NewGaugeFunc(name, help string, read func() []Sample, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, read: read}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	key := g.series(labelValues)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[key] = v
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	key := g.series(labelValues)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[key] += v
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	values := make(map[string]float64)
+	if g.read != nil {
+		for _, s := range g.read() {
+			values[g.series(s.LabelValues)] = s.Value
+		}
+	} else {
+		g.mutex.Lock()
+		for key, v := range g.values {
+			values[key] = v
+		}
+		g.mutex.Unlock()
+	}
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(key), formatValue(values[key]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.series(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s, found := h.values[key]
+	if !found {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		s := h.values[key]
+		for i, upperBound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatValue(upperBound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), s.count)
+	}
+}
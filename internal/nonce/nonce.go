@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,6 +28,9 @@ var ErrorBadNonceHeader = errors.New("bad nonce header")
 var ErrorBadNonceSignature = errors.New("bad nonce signature")
 var ErrorBadNonceKey = errors.New("bad nonce signing key")
 
+// Cleanups started by Record, waited for on shutdown
+var cleanups sync.WaitGroup
+
 type Nonce struct {
 	Value      string
 	Signature  string
@@ -40,7 +44,17 @@ type Nonce struct {
 }
 
 func ForUser(user *user.User) (*Nonce, error) {
-	return New(user.PublicSigningKey, user.PrivateSigningKey)
+	val, err := crypto.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// The user signs through the key agent when one holds its keys
+	signedNonce, err := user.SignData([]byte(val))
+	if err != nil {
+		return nil, err
+	}
+	return newSigned(val, signedNonce, user.PublicSigningKey), nil
 }
 
 func New(publicKey [32]byte, privateKey [64]byte) (*Nonce, error) {
@@ -50,14 +64,17 @@ func New(publicKey [32]byte, privateKey [64]byte) (*Nonce, error) {
 	}
 
 	signedNonce := crypto.SignData(publicKey, privateKey, []byte(val))
+	return newSigned(val, signedNonce, publicKey), nil
+}
 
+func newSigned(val, signedNonce string, publicKey [32]byte) *Nonce {
 	return &Nonce{
 		SigningAlgorithm: crypto.SIGNING_ALGORITHM,
 		Value:            val,
 		Signature:        signedNonce,
 		SigningKeyBase64: base64.StdEncoding.EncodeToString(publicKey[:]),
 		Date:             utils.ToRFC3339String(utils.TimestampNow()),
-	}, nil
+	}
 }
 
 func ToHeader(nonce *Nonce) string {
@@ -71,6 +88,9 @@ func ToHeader(nonce *Nonce) string {
 
 func FromHeader(nonceHeader string) (*Nonce, error) {
 	values := strings.SplitN(nonceHeader, NONCE_SCHEME, 2)
+	if len(values) != 2 {
+		return nil, ErrorBadNonceHeader
+	}
 	trimmedValues := strings.TrimSpace(values[1])
 	trimmedValues = strings.ReplaceAll(trimmedValues, "\t", "")
 	trimmedValues = strings.ReplaceAll(trimmedValues, "\n", "")
@@ -78,6 +98,9 @@ func FromHeader(nonceHeader string) (*Nonce, error) {
 	nonce := Nonce{Date: utils.ToRFC3339String(utils.TimestampNow())}
 	for _, kv := range kvs {
 		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) != 2 {
+			return nil, ErrorBadNonceHeader
+		}
 		value := parts[1]
 		switch strings.ToLower(parts[0]) {
 		case NONCE_HEADER_ATTRIBUTE_ALGORITHM:
@@ -160,11 +183,21 @@ func Record(homeDirPath string, nonce *Nonce) error {
 		return err
 	}
 
-	go Cleanup(homeDirPath)
+	cleanups.Add(1)
+	go func() {
+		defer cleanups.Done()
+		Cleanup(homeDirPath)
+	}()
 
 	return nil
 }
 
+// WaitCleanups returns once the cleanups started by Record are done. No
+// nonces may be recorded meanwhile.
+func WaitCleanups() {
+	cleanups.Wait()
+}
+
 func Cleanup(homeDirPath string) {
 	currentTime := time.Now()
 	todaysNoncesFilename := NONCES_FILENAME + currentTime.Format(NONCES_FILENAME_DATE)
@@ -0,0 +1,220 @@
+package provisioning
+
+import (
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Invites of a domain are kept in <data-dir>/<domain>/.invites, one per
+ * line:
+ *
+ *	code,local-part,uses,used,expires,created
+ *
+ * An invite without a local part is good for any name the policy allows,
+ * one with zero uses for any number of accounts and one without expiry
+ * until revoked. The server and the control command both change the file,
+ * holding .invites.lock while they read and rewrite it.
+ *************************************************************************/
+
+const INVITES_FILENAME = ".invites"
+const INVITES_LOCK_FILENAME = ".invites.lock"
+const INVITES_COLUMN_SEPARATOR = ","
+const INVITE_CODE_LENGTH = 24
+
+var ErrorBadInvite = errors.New("invite is unknown, used up or expired")
+var ErrorNoInvite = errors.New("no such invite")
+
+type Invite struct {
+	Code      string
+	LocalPart string
+	Uses      int
+	Used      int
+	Expires   string
+	Created   string
+}
+
+func invitesPath(domainPath string) string {
+	return filepath.Join(domainPath, INVITES_FILENAME)
+}
+
+// withInvitesLocked runs the change with the invites of the domain locked
+// against other goroutines and processes. The lock is a file of its own,
+// as .invites is replaced on every save.
+func withInvitesLocked(domainPath string, change func() error) error {
+	invitesMutex.Lock()
+	defer invitesMutex.Unlock()
+
+	lock, err := utils.LockFile(filepath.Join(domainPath, INVITES_LOCK_FILENAME))
+	if err != nil {
+		return err
+	}
+	err = change()
+	unlockErr := utils.UnlockFile(lock)
+	if err != nil {
+		return err
+	}
+	return unlockErr
+}
+
+func (i *Invite) ToLine() string {
+	return strings.Join([]string{i.Code, i.LocalPart, strconv.Itoa(i.Uses), strconv.Itoa(i.Used), i.Expires, i.Created}, INVITES_COLUMN_SEPARATOR)
+}
+
+// Valid tells whether the invite admits the local part now.
+func (i *Invite) Valid(localPart string, now time.Time) bool {
+	if i.LocalPart != "" && i.LocalPart != localPart {
+		return false
+	}
+	if i.Uses > 0 && i.Used >= i.Uses {
+		return false
+	}
+	if i.Expires != "" {
+		expires, err := utils.ParseRFC3339Time(i.Expires)
+		if err != nil || !expires.After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+func ListInvites(domainPath string) ([]*Invite, error) {
+	var invites []*Invite
+	data, err := os.ReadFile(invitesPath(domainPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return invites, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), INVITES_COLUMN_SEPARATOR)
+		if len(parts) != 6 {
+			continue
+		}
+		uses, err := strconv.Atoi(parts[2])
+		if err != nil {
+			continue
+		}
+		used, err := strconv.Atoi(parts[3])
+		if err != nil {
+			continue
+		}
+		invites = append(invites, &Invite{
+			Code:      parts[0],
+			LocalPart: parts[1],
+			Uses:      uses,
+			Used:      used,
+			Expires:   parts[4],
+			Created:   parts[5],
+		})
+	}
+	return invites, nil
+}
+
+func saveInvites(domainPath string, invites []*Invite) error {
+	var lines []string
+	for _, invite := range invites {
+		lines = append(lines, invite.ToLine()+"\n")
+	}
+	path := invitesPath(domainPath)
+	err := os.WriteFile(path+".tmp", []byte(strings.Join(lines, "")), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// IssueInvite adds an invite for the local part, or any if empty, good for
+// the given uses, or any number if zero, until expires, or forever if zero.
+func IssueInvite(domainPath, localPart string, uses int, expires time.Time) (*Invite, error) {
+	code, err := crypto.GenerateRandomString(INVITE_CODE_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	invite := &Invite{
+		Code:      code,
+		LocalPart: strings.ToLower(localPart),
+		Uses:      uses,
+		Created:   utils.ToRFC3339String(utils.TimestampNow()),
+	}
+	if !expires.IsZero() {
+		invite.Expires = utils.ToRFC3339String(expires.UTC())
+	}
+	err = withInvitesLocked(domainPath, func() error {
+		invites, err := ListInvites(domainPath)
+		if err != nil {
+			return err
+		}
+		return saveInvites(domainPath, append(invites, invite))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+func RevokeInvite(domainPath, code string) error {
+	return withInvitesLocked(domainPath, func() error {
+		invites, err := ListInvites(domainPath)
+		if err != nil {
+			return err
+		}
+		var kept []*Invite
+		for _, invite := range invites {
+			if invite.Code != code {
+				kept = append(kept, invite)
+			}
+		}
+		if len(kept) == len(invites) {
+			return ErrorNoInvite
+		}
+		return saveInvites(domainPath, kept)
+	})
+}
+
+// redeemInvite counts a use of the invite, which must admit the local
+// part. Reserved names need an invite issued for them.
+func redeemInvite(domainPath, code, localPart string, reserved bool) error {
+	return withInvitesLocked(domainPath, func() error {
+		invites, err := ListInvites(domainPath)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, invite := range invites {
+			if invite.Code != code {
+				continue
+			}
+			if !invite.Valid(localPart, now) || (reserved && invite.LocalPart != localPart) {
+				return ErrorBadInvite
+			}
+			invite.Used++
+			return saveInvites(domainPath, invites)
+		}
+		return ErrorBadInvite
+	})
+}
+
+// ReleaseInvite gives back a use of the invite, redeemed for an account
+// that could not be provisioned after all. Revoked invites stay revoked.
+func ReleaseInvite(domainPath, code string) error {
+	return withInvitesLocked(domainPath, func() error {
+		invites, err := ListInvites(domainPath)
+		if err != nil {
+			return err
+		}
+		for _, invite := range invites {
+			if invite.Code == code && invite.Used > 0 {
+				invite.Used--
+				return saveInvites(domainPath, invites)
+			}
+		}
+		return ErrorNoInvite
+	})
+}
@@ -0,0 +1,223 @@
+package provisioning
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Provisioning of accounts on a domain follows the policy in
+ * <data-dir>/<domain>/.provisioning, all fields optional:
+ *
+ *	Mode: invite
+ *	Local-Part-Pattern: ^[a-z0-9][a-z0-9._-]{2,31}$
+ *	Reserved: admin support billing
+ *	Forbidden: root postmaster abuse
+ *	Max-Accounts: 1000
+ *
+ * In open mode anyone may claim a free local part, in invite mode only
+ * with an invite code, in closed mode nobody. Reserved names are claimed
+ * only with an invite issued for that very name, forbidden ones never.
+ * Domains without a policy file are in the mode the server defaults to.
+ *************************************************************************/
+
+const POLICY_FILENAME = ".provisioning"
+
+const FIELD_MODE = "Mode"
+const FIELD_LOCAL_PART_PATTERN = "Local-Part-Pattern"
+const FIELD_RESERVED = "Reserved"
+const FIELD_FORBIDDEN = "Forbidden"
+const FIELD_MAX_ACCOUNTS = "Max-Accounts"
+
+const MODE_OPEN = "open"
+const MODE_INVITE = "invite"
+const MODE_CLOSED = "closed"
+
+// Names starting with a dot would clash with the files of the domain
+const DEFAULT_LOCAL_PART_PATTERN = `^[a-z0-9][a-z0-9._-]{0,63}$`
+
+var ErrorBadPolicy = errors.New("bad provisioning policy")
+var ErrorProvisioningClosed = errors.New("provisioning is closed")
+var ErrorNameForbidden = errors.New("local part may not be provisioned")
+var ErrorBadLocalPart = errors.New("local part does not match the pattern of the domain")
+var ErrorDomainFull = errors.New("domain has reached its maximum of accounts")
+var ErrorInviteRequired = errors.New("an invite is required")
+
+// Changes of invites are serialized, so that uses are not counted twice
+var invitesMutex sync.Mutex
+
+type Policy struct {
+	Mode             string
+	LocalPartPattern *regexp.Regexp
+	Reserved         []string
+	Forbidden        []string
+	MaxAccounts      int
+}
+
+func ValidMode(mode string) bool {
+	return mode == MODE_OPEN || mode == MODE_INVITE || mode == MODE_CLOSED
+}
+
+func DefaultPolicy(mode string) *Policy {
+	return &Policy{
+		Mode:             mode,
+		LocalPartPattern: regexp.MustCompile(DEFAULT_LOCAL_PART_PATTERN),
+	}
+}
+
+// LoadPolicy reads the policy of the domain, falling back to the default
+// policy in the given mode.
+func LoadPolicy(domainPath, defaultMode string) (*Policy, error) {
+	return LoadPolicyFile(filepath.Join(domainPath, POLICY_FILENAME), defaultMode)
+}
+
+// LoadPolicyFile reads a policy kept elsewhere than in the domain directory.
+func LoadPolicyFile(path, defaultMode string) (*Policy, error) {
+	policy := DefaultPolicy(defaultMode)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy, nil
+		}
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, ErrorBadPolicy
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case FIELD_MODE:
+			if !ValidMode(value) {
+				return nil, ErrorBadPolicy
+			}
+			policy.Mode = value
+		case FIELD_LOCAL_PART_PATTERN:
+			policy.LocalPartPattern, err = regexp.Compile(value)
+			if err != nil {
+				return nil, ErrorBadPolicy
+			}
+		case FIELD_RESERVED:
+			policy.Reserved = strings.Fields(strings.ToLower(value))
+		case FIELD_FORBIDDEN:
+			policy.Forbidden = strings.Fields(strings.ToLower(value))
+		case FIELD_MAX_ACCOUNTS:
+			policy.MaxAccounts, err = strconv.Atoi(value)
+			if err != nil || policy.MaxAccounts < 0 {
+				return nil, ErrorBadPolicy
+			}
+		}
+	}
+	return policy, scanner.Err()
+}
+
+func HasPolicy(domainPath string) bool {
+	exists, err := utils.FilePathExists(filepath.Join(domainPath, POLICY_FILENAME))
+	return err == nil && exists
+}
+
+// SetMode changes the mode in the policy of the domain, keeping the rest of
+// the policy as it is.
+func SetMode(domainPath, mode string) error {
+	if !ValidMode(mode) {
+		return ErrorBadPolicy
+	}
+	path := filepath.Join(domainPath, POLICY_FILENAME)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := []string{FIELD_MODE + ": " + mode}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if line == "" || strings.TrimSpace(parts[0]) == FIELD_MODE {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	err = os.WriteFile(path+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// CountAccounts counts the home directories of the domain.
+func CountAccounts(domainPath string) (int, error) {
+	dirs, err := utils.ListDirectories(domainPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	count := 0
+	for _, dir := range dirs {
+		if !strings.HasPrefix(dir, ".") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Check tells whether the policy allows the local part on the domain at
+// all, whoever provisions it.
+func (p *Policy) Check(domainPath, localPart string) error {
+	if utils.ListContains(p.Forbidden, localPart) {
+		return ErrorNameForbidden
+	}
+	if !p.LocalPartPattern.MatchString(localPart) {
+		return ErrorBadLocalPart
+	}
+	if p.MaxAccounts > 0 {
+		count, err := CountAccounts(domainPath)
+		if err != nil {
+			return err
+		}
+		if count >= p.MaxAccounts {
+			return ErrorDomainFull
+		}
+	}
+	return nil
+}
+
+// Admit checks the local part may be provisioned on the domain under the
+// policy, redeeming the invite where one is needed, whose code it returns
+// so that the use can be released if provisioning fails. Invites are left
+// alone when the policy lets the name in without one.
+func (p *Policy) Admit(domainPath, localPart, inviteCode string) (string, error) {
+	if p.Mode == MODE_CLOSED {
+		return "", ErrorProvisioningClosed
+	}
+	err := p.Check(domainPath, localPart)
+	if err != nil {
+		return "", err
+	}
+
+	reserved := utils.ListContains(p.Reserved, localPart)
+	if p.Mode == MODE_OPEN && !reserved {
+		return "", nil
+	}
+	if inviteCode == "" {
+		return "", ErrorInviteRequired
+	}
+	err = redeemInvite(domainPath, inviteCode, localPart, reserved)
+	if err != nil {
+		return "", err
+	}
+	return inviteCode, nil
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"errors"
+	"expvar"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Token bucket rate limiting, one bucket per key such as a client IP, a
+ * link or a signing key fingerprint. Rates are given as "count/period",
+ * e.g. "120/m" or "1/h"; the count is also the burst size.
+ ************************************************************************/
+
+const RATE_SEPARATOR = "/"
+
+// Buckets idle for this long are full again and can be forgotten
+const BUCKET_CLEANUP_INTERVAL = 10 * time.Minute
+
+var ErrorBadRate = errors.New("bad rate, expected count/period such as 60/m")
+
+// Counters of allowed and rejected requests per limiter, served by expvar
+var Counters = expvar.NewMap("ratelimit")
+
+type Rate struct {
+	Count  int
+	Period time.Duration
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type Limiter struct {
+	name    string
+	rate    Rate
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	cleaned time.Time
+}
+
+func ParseRate(spec string) (Rate, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "0" {
+		return Rate{}, nil
+	}
+	parts := strings.SplitN(spec, RATE_SEPARATOR, 2)
+	if len(parts) != 2 {
+		return Rate{}, ErrorBadRate
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || count < 0 {
+		return Rate{}, ErrorBadRate
+	}
+	var period time.Duration
+	switch strings.TrimSpace(parts[1]) {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	case "d":
+		period = 24 * time.Hour
+	default:
+		return Rate{}, ErrorBadRate
+	}
+	return Rate{Count: count, Period: period}, nil
+}
+
+func (r Rate) Enabled() bool {
+	return r.Count > 0 && r.Period > 0
+}
+
+func (r Rate) String() string {
+	if !r.Enabled() {
+		return "unlimited"
+	}
+	return strconv.Itoa(r.Count) + RATE_SEPARATOR + r.Period.String()
+}
+
+func New(name string, rate Rate) *Limiter {
+	return &Limiter{
+		name:    name,
+		rate:    rate,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *Limiter) Name() string {
+	return l.name
+}
+
+func (l *Limiter) Enabled() bool {
+	if l == nil {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rate.Enabled()
+}
+
+// SetRate changes the rate of the limiter. Buckets keep their tokens, up to
+// the new count.
+func (l *Limiter) SetRate(rate Rate) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rate = rate
+}
+
+// Allow takes a token from the bucket of the key. When none is left, it
+// tells how long until the next one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.rate.Enabled() {
+		return true, 0
+	}
+
+	now := time.Now()
+	l.cleanup(now)
+
+	perToken := l.rate.Period / time.Duration(l.rate.Count)
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(l.rate.Count)}
+		l.buckets[key] = b
+	} else {
+		refill := float64(now.Sub(b.lastSeen)) / float64(perToken)
+		b.tokens = math.Min(float64(l.rate.Count), b.tokens+refill)
+	}
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		Counters.Add(l.name+".allowed", 1)
+		return true, 0
+	}
+	Counters.Add(l.name+".rejected", 1)
+	return false, time.Duration((1 - b.tokens) * float64(perToken))
+}
+
+func (l *Limiter) cleanup(now time.Time) {
+	if now.Sub(l.cleaned) < BUCKET_CLEANUP_INTERVAL {
+		return
+	}
+	l.cleaned = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > l.rate.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
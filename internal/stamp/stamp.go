@@ -0,0 +1,198 @@
+package stamp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"email.mercata.com/internal/utils"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Hashcash style proof of work for notifications from unknown links.
+ *
+ *	Notification-Stamp: bits=20; date=2006-01-02; counter=1234567
+ *
+ * The SHA-256 hash of the stamp resource must start with at least the
+ * demanded number of zero bits. The resource binds the stamp to the day,
+ * the target link and the fingerprint of the notifier signing key, so a
+ * stamp cannot be reused for another account, by another key or later on:
+ *
+ *	openemail-stamp-v1:<bits>:<date>:<link>:<fingerprint>:<counter>
+ *
+ * Each stamp pays for one notification. Spent stamps are recorded with
+ * their link in <home>/.stamps_<date>, kept while stamps of the date are
+ * accepted, so the same key cannot send a stamp twice.
+ ************************************************************************/
+
+const STAMP_RESOURCE_PREFIX = "openemail-stamp-v1"
+const STAMP_RESOURCE_SEPARATOR = ":"
+const STAMP_DATE_FORMAT = "2006-01-02"
+const SPENT_STAMPS_FILENAME = ".stamps_"
+const SPENT_STAMPS_COLUMN_SEPARATOR = ","
+
+const ATTRIBUTE_BITS = "bits"
+const ATTRIBUTE_DATE = "date"
+const ATTRIBUTE_COUNTER = "counter"
+
+// Demanding more would lock out clients on ordinary hardware
+const MAX_STAMP_BITS = 28
+
+var ErrorBadStamp = errors.New("bad notification stamp")
+var ErrorStampExpired = errors.New("notification stamp is not from today")
+var ErrorStampInsufficient = errors.New("notification stamp does not have enough work")
+var ErrorStampTooHard = errors.New("demanded stamp difficulty is too high")
+var ErrorStampSpent = errors.New("notification stamp was spent already")
+
+var spentMutex sync.Mutex
+
+type Stamp struct {
+	Bits    int
+	Date    string
+	Counter uint64
+}
+
+func resource(bitCount int, date, link, fingerprint string, counter uint64) []byte {
+	return []byte(strings.Join([]string{
+		STAMP_RESOURCE_PREFIX,
+		strconv.Itoa(bitCount),
+		date,
+		strings.ToLower(link),
+		strings.ToLower(fingerprint),
+		strconv.FormatUint(counter, 10),
+	}, STAMP_RESOURCE_SEPARATOR))
+}
+
+func leadingZeroBits(hash [32]byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// Mint searches for a counter giving the demanded work for today. The
+// search starts anywhere, so that stamps minted the same day differ.
+func Mint(link, fingerprint string, bitCount int) (*Stamp, error) {
+	if bitCount > MAX_STAMP_BITS {
+		return nil, ErrorStampTooHard
+	}
+	var start [8]byte
+	_, err := rand.Read(start[:])
+	if err != nil {
+		return nil, err
+	}
+	date := time.Now().UTC().Format(STAMP_DATE_FORMAT)
+	for counter := binary.BigEndian.Uint64(start[:]); ; counter++ {
+		if leadingZeroBits(sha256.Sum256(resource(bitCount, date, link, fingerprint, counter))) >= bitCount {
+			return &Stamp{Bits: bitCount, Date: date, Counter: counter}, nil
+		}
+	}
+}
+
+// Stamps are accepted from the day before their date until the end of the
+// day after, allowing for clock skew
+const MIN_STAMP_AGE = -24 * time.Hour
+const MAX_STAMP_AGE = 48 * time.Hour
+
+// Verify checks the stamp was made for the link and key, has at least the
+// demanded work and is from today, give or take a day of clock skew.
+func (s *Stamp) Verify(link, fingerprint string, requiredBits int) error {
+	date, err := time.Parse(STAMP_DATE_FORMAT, s.Date)
+	if err != nil {
+		return ErrorBadStamp
+	}
+	age := time.Now().UTC().Sub(date)
+	if age < MIN_STAMP_AGE || age > MAX_STAMP_AGE {
+		return ErrorStampExpired
+	}
+	if s.Bits < requiredBits {
+		return ErrorStampInsufficient
+	}
+	if leadingZeroBits(sha256.Sum256(resource(s.Bits, s.Date, link, fingerprint, s.Counter))) < s.Bits {
+		return ErrorStampInsufficient
+	}
+	return nil
+}
+
+// Spend records the verified stamp as used on the link of the account,
+// failing with ErrorStampSpent if it was before.
+func (s *Stamp) Spend(homeDirPath, link, fingerprint string) error {
+	hash := sha256.Sum256(resource(s.Bits, s.Date, link, fingerprint, s.Counter))
+	spentLine := strings.ToLower(link) + SPENT_STAMPS_COLUMN_SEPARATOR + hex.EncodeToString(hash[:])
+	spentPath := filepath.Join(homeDirPath, SPENT_STAMPS_FILENAME+s.Date)
+
+	spentMutex.Lock()
+	defer spentMutex.Unlock()
+
+	spent, err := utils.PrefixExistsInFile(spentLine, spentPath)
+	if err != nil {
+		return err
+	}
+	if spent {
+		return ErrorStampSpent
+	}
+	err = utils.AppendStringToFile(spentLine, spentPath)
+	if err != nil {
+		return err
+	}
+	// Records left over are removed with the next stamp spent
+	_ = cleanupSpent(homeDirPath)
+	return nil
+}
+
+// cleanupSpent removes the records of dates whose stamps are not accepted
+// anymore
+func cleanupSpent(homeDirPath string) error {
+	paths, err := filepath.Glob(filepath.Join(homeDirPath, SPENT_STAMPS_FILENAME+"*"))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, path := range paths {
+		date, err := time.Parse(STAMP_DATE_FORMAT, strings.TrimPrefix(filepath.Base(path), SPENT_STAMPS_FILENAME))
+		if err == nil && now.Sub(date) <= MAX_STAMP_AGE {
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stamp) ToHeader() string {
+	return strings.Join([]string{
+		ATTRIBUTE_BITS + "=" + strconv.Itoa(s.Bits),
+		ATTRIBUTE_DATE + "=" + s.Date,
+		ATTRIBUTE_COUNTER + "=" + strconv.FormatUint(s.Counter, 10),
+	}, "; ")
+}
+
+func FromHeader(value string) (*Stamp, error) {
+	attrs := utils.ParseHeadersAttributes(value)
+
+	var s Stamp
+	var err error
+	s.Bits, err = strconv.Atoi(attrs[ATTRIBUTE_BITS])
+	if err != nil || s.Bits < 0 || s.Bits > 256 {
+		return nil, ErrorBadStamp
+	}
+	s.Date = attrs[ATTRIBUTE_DATE]
+	s.Counter, err = strconv.ParseUint(attrs[ATTRIBUTE_COUNTER], 10, 64)
+	if err != nil {
+		return nil, ErrorBadStamp
+	}
+	return &s, nil
+}
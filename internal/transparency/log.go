@@ -0,0 +1,225 @@
+package transparency
+
+import (
+	"bufio"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/utils"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/* Key transparency log
+ *
+ * Every change of the profile keys of a hosted account is appended as a
+ * leaf to a single log of the server, kept in <data-dir>/.transparency:
+ *
+ *	leaves       one leaf per line: timestamp,address,signing-key,encryption-key
+ *	log.private  the signing key of the tree heads
+ *
+ * The leaf data is the line itself. Leaves are never rewritten, so a server
+ * showing different keys to different people has to fork the tree, which
+ * clients detect by checking consistency between the tree heads they see.
+ ****************************************************************************/
+
+const KEY_LOG_DIRECTORY = ".transparency"
+const KEY_LOG_LEAVES_FILENAME = "leaves"
+const KEY_LOG_SIGNING_KEY_FILENAME = "log.private"
+const KEY_LOG_LEAF_SEPARATOR = ","
+
+var ErrorBadLeaf = errors.New("bad key log leaf")
+var ErrorTreeSize = errors.New("tree size out of range")
+
+type Entry struct {
+	Index               int
+	Timestamp           string
+	Address             string
+	SigningKeyBase64    string
+	EncryptionKeyBase64 string
+}
+
+type Log struct {
+	mutex      sync.RWMutex
+	dirPath    string
+	publicKey  [32]byte
+	privateKey [64]byte
+	tree       Tree
+	latest     map[string]*Entry
+	// Signed when the tree grows, served until it grows again
+	head *TreeHead
+}
+
+func (e *Entry) LeafData() []byte {
+	return []byte(strings.Join([]string{e.Timestamp, e.Address, e.SigningKeyBase64, e.EncryptionKeyBase64}, KEY_LOG_LEAF_SEPARATOR))
+}
+
+func EntryFromLeafData(data []byte) (*Entry, error) {
+	parts := strings.Split(string(data), KEY_LOG_LEAF_SEPARATOR)
+	if len(parts) != 4 {
+		return nil, ErrorBadLeaf
+	}
+	return &Entry{
+		Timestamp:           parts[0],
+		Address:             parts[1],
+		SigningKeyBase64:    parts[2],
+		EncryptionKeyBase64: parts[3],
+	}, nil
+}
+
+// Open loads the log kept in the data directory, creating it when missing.
+func Open(dataDirPath string) (*Log, error) {
+	l := &Log{
+		dirPath: filepath.Join(dataDirPath, KEY_LOG_DIRECTORY),
+		latest:  make(map[string]*Entry),
+	}
+	err := os.MkdirAll(l.dirPath, 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = l.loadSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filepath.Join(l.dirPath, KEY_LOG_LEAVES_FILENAME))
+	if err != nil {
+		if os.IsNotExist(err) {
+			l.head = l.treeHead()
+			return l, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		entry, err := EntryFromLeafData(line)
+		if err != nil {
+			return nil, err
+		}
+		entry.Index = l.tree.Size()
+		l.tree.Append(LeafHash(line))
+		l.latest[entry.Address] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	l.head = l.treeHead()
+	return l, nil
+}
+
+func (l *Log) loadSigningKey() error {
+	keyPath := filepath.Join(l.dirPath, KEY_LOG_SIGNING_KEY_FILENAME)
+	data, err := os.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		privateKeyBase64, _ := crypto.GenerateSigningKeys()
+		data = []byte(privateKeyBase64)
+		err = os.WriteFile(keyPath, data, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	l.privateKey, err = crypto.DecodeBase64Key64(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+	copy(l.publicKey[:], l.privateKey[32:])
+	return nil
+}
+
+func (l *Log) PublicKeyBase64() string {
+	return base64.StdEncoding.EncodeToString(l.publicKey[:])
+}
+
+// Observe appends a leaf for the account, unless its latest leaf already
+// holds the same keys.
+func (l *Log) Observe(address, signingKeyBase64, encryptionKeyBase64 string) (*Entry, error) {
+	if address == "" || signingKeyBase64 == "" {
+		return nil, ErrorBadLeaf
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if entry, found := l.latest[address]; found && entry.SigningKeyBase64 == signingKeyBase64 && entry.EncryptionKeyBase64 == encryptionKeyBase64 {
+		return entry, nil
+	}
+
+	entry := &Entry{
+		Index:               l.tree.Size(),
+		Timestamp:           utils.ToRFC3339String(utils.TimestampNow()),
+		Address:             address,
+		SigningKeyBase64:    signingKeyBase64,
+		EncryptionKeyBase64: encryptionKeyBase64,
+	}
+	leafData := entry.LeafData()
+	err := utils.AppendStringToFile(string(leafData), filepath.Join(l.dirPath, KEY_LOG_LEAVES_FILENAME))
+	if err != nil {
+		return nil, err
+	}
+	l.tree.Append(LeafHash(leafData))
+	l.latest[address] = entry
+	l.head = l.treeHead()
+	return entry, nil
+}
+
+func (l *Log) Latest(address string) *Entry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.latest[address]
+}
+
+// Snapshot returns a signed tree head with the inclusion proof of the
+// latest leaf of the account in it, nil when the account has no leaf.
+func (l *Log) Snapshot(address string) (*TreeHead, *Inclusion) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	entry, found := l.latest[address]
+	if !found {
+		return l.head, nil
+	}
+	return l.head, &Inclusion{
+		Index:    entry.Index,
+		TreeSize: l.head.TreeSize,
+		LeafData: entry.LeafData(),
+		Path:     l.tree.InclusionProof(entry.Index, l.head.TreeSize),
+	}
+}
+
+func (l *Log) TreeHead() *TreeHead {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.head
+}
+
+// treeHead signs the head of the tree as it is, the timestamp telling
+// when it last grew
+func (l *Log) treeHead() *TreeHead {
+	size := l.tree.Size()
+	treeHead := &TreeHead{
+		TreeSize:  size,
+		Timestamp: utils.ToRFC3339String(utils.TimestampNow()),
+		RootHash:  l.tree.RootHash(size),
+		Key:       l.publicKey,
+	}
+	treeHead.Signature = crypto.SignData(l.publicKey, l.privateKey, treeHead.signedData())
+	return treeHead
+}
+
+func (l *Log) ConsistencyProof(firstSize, secondSize int) ([][]byte, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if firstSize < 0 || firstSize > secondSize || secondSize > l.tree.Size() {
+		return nil, ErrorTreeSize
+	}
+	return l.tree.ConsistencyProof(firstSize, secondSize), nil
+}
@@ -0,0 +1,264 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+/* Merkle tree hashing, inclusion and consistency proofs as described in
+ * RFC 6962, section 2.1. Leaves and interior nodes are domain separated
+ * by a one byte prefix.
+ ************************************************************************/
+
+const LEAF_HASH_PREFIX = 0x00
+const NODE_HASH_PREFIX = 0x01
+
+var ErrorBadProof = errors.New("bad merkle proof")
+
+func LeafHash(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{LEAF_HASH_PREFIX}, data...))
+	return sum[:]
+}
+
+func nodeHash(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, NODE_HASH_PREFIX)
+	data = append(data, left...)
+	data = append(data, right...)
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// splitPoint is the largest power of two smaller than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// RootHash of the tree made of the given leaf hashes
+func RootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return nodeHash(RootHash(leaves[:k]), RootHash(leaves[k:]))
+}
+
+// InclusionProof is the audit path of leaf m in the tree of the given leaves
+func InclusionProof(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 || m < 0 || m >= len(leaves) {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if m < k {
+		return append(InclusionProof(m, leaves[:k]), RootHash(leaves[k:]))
+	}
+	return append(InclusionProof(m-k, leaves[k:]), RootHash(leaves[:k]))
+}
+
+// ConsistencyProof between the tree of the first m leaves and the full tree
+func ConsistencyProof(m int, leaves [][]byte) [][]byte {
+	if m <= 0 || m >= len(leaves) {
+		return nil
+	}
+	return subProof(m, leaves, true)
+}
+
+func subProof(m int, leaves [][]byte, complete bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{RootHash(leaves)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(subProof(m, leaves[:k], complete), RootHash(leaves[k:]))
+	}
+	return append(subProof(m-k, leaves[k:], false), RootHash(leaves[:k]))
+}
+
+// VerifyInclusion checks the audit path of a leaf against a root hash,
+// following RFC 9162, section 2.1.3.2.
+func VerifyInclusion(leafHash []byte, index, treeSize int, proof [][]byte, root []byte) error {
+	if index < 0 || index >= treeSize {
+		return ErrorBadProof
+	}
+	fn, sn := index, treeSize-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrorBadProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrorBadProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the second tree extends the first one,
+// following RFC 9162, section 2.1.4.2.
+func VerifyConsistency(firstSize, secondSize int, firstRoot, secondRoot []byte, proof [][]byte) error {
+	if firstSize > secondSize || firstSize < 0 {
+		return ErrorBadProof
+	}
+	if firstSize == secondSize {
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrorBadProof
+		}
+		return nil
+	}
+	if firstSize == 0 {
+		// Every tree extends the empty one
+		return nil
+	}
+	if len(proof) == 0 {
+		return ErrorBadProof
+	}
+
+	if firstSize&(firstSize-1) == 0 {
+		// A power of two is a complete subtree, its root leads the path
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	fn, sn := firstSize-1, secondSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrorBadProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(fr, firstRoot) || !bytes.Equal(sr, secondRoot) {
+		return ErrorBadProof
+	}
+	return nil
+}
+
+// Tree keeps the hashes of all complete subtrees, level by level, so that
+// appending a leaf updates one node per level and roots and proofs of any
+// size are made of those nodes rather than rehashing the leaves.
+type Tree struct {
+	// levels[i][j] is the root of the 2^i leaves from j*2^i on
+	levels [][][]byte
+}
+
+func (t *Tree) Size() int {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return len(t.levels[0])
+}
+
+func (t *Tree) Append(leafHash []byte) {
+	if len(t.levels) == 0 {
+		t.levels = append(t.levels, nil)
+	}
+	t.levels[0] = append(t.levels[0], leafHash)
+	for i := 0; len(t.levels[i])%2 == 0; i++ {
+		if i+1 == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		n := len(t.levels[i])
+		t.levels[i+1] = append(t.levels[i+1], nodeHash(t.levels[i][n-2], t.levels[i][n-1]))
+	}
+}
+
+// RootHash of the tree of the first size leaves
+func (t *Tree) RootHash(size int) []byte {
+	if size == 0 {
+		return RootHash(nil)
+	}
+	return t.subtreeHash(0, size)
+}
+
+// subtreeHash of the n leaves from start on, taken as is when complete
+func (t *Tree) subtreeHash(start, n int) []byte {
+	if n&(n-1) == 0 && start%n == 0 {
+		level := 0
+		for 1<<level < n {
+			level++
+		}
+		return t.levels[level][start>>level]
+	}
+	k := splitPoint(n)
+	return nodeHash(t.subtreeHash(start, k), t.subtreeHash(start+k, n-k))
+}
+
+// InclusionProof of leaf m in the tree of the first size leaves, the same
+// as InclusionProof of those leaves.
+func (t *Tree) InclusionProof(m, size int) [][]byte {
+	if size <= 1 || size > t.Size() || m < 0 || m >= size {
+		return nil
+	}
+	return t.inclusionProof(m, 0, size)
+}
+
+func (t *Tree) inclusionProof(m, start, n int) [][]byte {
+	if n <= 1 {
+		return nil
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(t.inclusionProof(m, start, k), t.subtreeHash(start+k, n-k))
+	}
+	return append(t.inclusionProof(m-k, start+k, n-k), t.subtreeHash(start, k))
+}
+
+// ConsistencyProof between the trees of the first m and the first size
+// leaves, the same as ConsistencyProof of those leaves.
+func (t *Tree) ConsistencyProof(m, size int) [][]byte {
+	if m <= 0 || m >= size || size > t.Size() {
+		return nil
+	}
+	return t.subProof(m, 0, size, true)
+}
+
+func (t *Tree) subProof(m, start, n int, complete bool) [][]byte {
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{t.subtreeHash(start, n)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(t.subProof(m, start, k, complete), t.subtreeHash(start+k, n-k))
+	}
+	return append(t.subProof(m-k, start+k, n-k, false), t.subtreeHash(start, k))
+}
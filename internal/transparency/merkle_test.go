@@ -0,0 +1,208 @@
+package transparency
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors of RFC 6962, as used by the certificate transparency
+// reference implementations
+
+var testLeaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var testRoots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func testLeafHashes(t *testing.T, n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		data, err := hex.DecodeString(testLeaves[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaves[i] = LeafHash(data)
+	}
+	return leaves
+}
+
+func decodeHashes(t *testing.T, hashes []string) [][]byte {
+	var result [][]byte
+	for _, h := range hashes {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
+func TestRootHash(t *testing.T) {
+	empty := RootHash(nil)
+	if hex.EncodeToString(empty) != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("empty tree: got %x", empty)
+	}
+	for n := 1; n <= len(testRoots); n++ {
+		root := RootHash(testLeafHashes(t, n))
+		if hex.EncodeToString(root) != testRoots[n-1] {
+			t.Errorf("tree of %d: got %x, want %s", n, root, testRoots[n-1])
+		}
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	for _, tt := range []struct {
+		index    int
+		treeSize int
+		proof    []string
+	}{
+		{0, 1, nil},
+		{0, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{5, 8, []string{
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 3, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+		{1, 5, []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	} {
+		leaves := testLeafHashes(t, tt.treeSize)
+		want := decodeHashes(t, tt.proof)
+		proof := InclusionProof(tt.index, leaves)
+		if !equalHashes(proof, want) {
+			t.Errorf("leaf %d of %d: got %x, want %x", tt.index, tt.treeSize, proof, want)
+		}
+		root := RootHash(leaves)
+		if err := VerifyInclusion(leaves[tt.index], tt.index, tt.treeSize, want, root); err != nil {
+			t.Errorf("leaf %d of %d: %v", tt.index, tt.treeSize, err)
+		}
+		if tt.treeSize > 1 {
+			if err := VerifyInclusion(leaves[(tt.index+1)%tt.treeSize], tt.index, tt.treeSize, want, root); err == nil {
+				t.Errorf("leaf %d of %d: verified another leaf", tt.index, tt.treeSize)
+			}
+		}
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	for _, tt := range []struct {
+		firstSize  int
+		secondSize int
+		proof      []string
+	}{
+		{1, 1, nil},
+		{1, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, 8, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 5, []string{
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	} {
+		leaves := testLeafHashes(t, tt.secondSize)
+		want := decodeHashes(t, tt.proof)
+		proof := ConsistencyProof(tt.firstSize, leaves)
+		if !equalHashes(proof, want) {
+			t.Errorf("%d to %d: got %x, want %x", tt.firstSize, tt.secondSize, proof, want)
+		}
+		firstRoot := RootHash(leaves[:tt.firstSize])
+		secondRoot := RootHash(leaves)
+		if err := VerifyConsistency(tt.firstSize, tt.secondSize, firstRoot, secondRoot, want); err != nil {
+			t.Errorf("%d to %d: %v", tt.firstSize, tt.secondSize, err)
+		}
+		if tt.firstSize < tt.secondSize {
+			if err := VerifyConsistency(tt.firstSize, tt.secondSize, secondRoot, secondRoot, want); err == nil {
+				t.Errorf("%d to %d: verified a wrong first root", tt.firstSize, tt.secondSize)
+			}
+		}
+	}
+}
+
+// Every proof the tree gives verifies, for all sizes of the test tree
+func TestProofsVerify(t *testing.T) {
+	for n := 1; n <= len(testLeaves); n++ {
+		leaves := testLeafHashes(t, n)
+		root := RootHash(leaves)
+		for m := 0; m < n; m++ {
+			if err := VerifyInclusion(leaves[m], m, n, InclusionProof(m, leaves), root); err != nil {
+				t.Errorf("leaf %d of %d: %v", m, n, err)
+			}
+		}
+		for m := 1; m <= n; m++ {
+			if err := VerifyConsistency(m, n, RootHash(leaves[:m]), root, ConsistencyProof(m, leaves)); err != nil {
+				t.Errorf("%d to %d: %v", m, n, err)
+			}
+		}
+	}
+}
+
+func equalHashes(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// The incremental tree gives the same roots and proofs as the leaves
+func TestTree(t *testing.T) {
+	var leaves [][]byte
+	var tree Tree
+	for i := 0; i < 70; i++ {
+		leaf := LeafHash([]byte{byte(i)})
+		leaves = append(leaves, leaf)
+		tree.Append(leaf)
+	}
+	for n := 0; n <= len(leaves); n++ {
+		if !bytes.Equal(tree.RootHash(n), RootHash(leaves[:n])) {
+			t.Errorf("tree of %d: root differs", n)
+		}
+		for m := 0; m < n; m++ {
+			if !equalHashes(tree.InclusionProof(m, n), InclusionProof(m, leaves[:n])) {
+				t.Errorf("leaf %d of %d: inclusion proof differs", m, n)
+			}
+			if !equalHashes(tree.ConsistencyProof(m, n), ConsistencyProof(m, leaves[:n])) {
+				t.Errorf("%d to %d: consistency proof differs", m, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package transparency
+
+import (
+	"bufio"
+	"bytes"
+	"email.mercata.com/internal/consts"
+	"email.mercata.com/internal/email/storage"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* Clients remember the last tree head seen from every host, along with
+ * the key of its log, in ~/.email2/.keylog/<host>:
+ *
+ *	Log-Key: BASE64
+ *	Tree-Head: size=42; timestamp=...; root=...; key=...; signature=...
+ *
+ * Each new tree head must be consistent with the remembered one. A tree
+ * that does not extend the previous one means the host served a forked
+ * log, to us or to someone else.
+ ************************************************************************/
+
+const KEY_LOG_CLIENT_DIRECTORY = ".keylog"
+const KEY_LOG_FIELD_LOG_KEY = "Log-Key"
+const KEY_LOG_FIELD_TREE_HEAD = "Tree-Head"
+
+const KEY_LOG_HEAD_PATH = "/%s/head"
+const KEY_LOG_CONSISTENCY_PATH = "/%s/consistency?from=%d&to=%d"
+
+var ErrorSplitView = errors.New("key log split view: host served a tree inconsistent with the one seen before")
+var ErrorLogKeyChanged = errors.New("key log signing key of host changed")
+var ErrorLeafMismatch = errors.New("key log leaf does not match the served profile")
+
+type ConsistencyFetcher func(firstSize, secondSize int) ([][]byte, error)
+
+func MonitorPath(host string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	if host == "" || strings.ContainsAny(host, "/\\") {
+		return "", errors.New("bad host name")
+	}
+	return filepath.Join(homeDir, storage.LOCAL_EMAIL_DIRECTORY, KEY_LOG_CLIENT_DIRECTORY, host), nil
+}
+
+func LoadTreeHead(host string) (*TreeHead, error) {
+	monitorPath, err := MonitorPath(host)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(monitorPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == KEY_LOG_FIELD_TREE_HEAD {
+			return TreeHeadFromHeader(strings.TrimSpace(parts[1]))
+		}
+	}
+	return nil, scanner.Err()
+}
+
+func StoreTreeHead(host string, th *TreeHead) error {
+	monitorPath, err := MonitorPath(host)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(monitorPath), 0700)
+	if err != nil {
+		return err
+	}
+	lines := []string{
+		KEY_LOG_FIELD_LOG_KEY + ": " + th.KeyBase64(),
+		KEY_LOG_FIELD_TREE_HEAD + ": " + th.ToHeader(),
+	}
+	return os.WriteFile(monitorPath, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// CheckTreeHead verifies a tree head of the host against the remembered
+// one and remembers it when it extends the log.
+func CheckTreeHead(host string, th *TreeHead, fetch ConsistencyFetcher) error {
+	err := th.VerifySignature()
+	if err != nil {
+		return err
+	}
+	previous, err := LoadTreeHead(host)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return StoreTreeHead(host, th)
+	}
+	if previous.Key != th.Key {
+		return ErrorLogKeyChanged
+	}
+
+	switch {
+	case th.TreeSize == previous.TreeSize:
+		if !bytes.Equal(th.RootHash, previous.RootHash) {
+			return ErrorSplitView
+		}
+		return nil
+
+	case th.TreeSize < previous.TreeSize:
+		// An older head, as from a lagging replica, must be a prefix of the known tree
+		proof, err := fetch(th.TreeSize, previous.TreeSize)
+		if err != nil {
+			return err
+		}
+		if VerifyConsistency(th.TreeSize, previous.TreeSize, th.RootHash, previous.RootHash, proof) != nil {
+			return ErrorSplitView
+		}
+		return nil
+	}
+
+	proof, err := fetch(previous.TreeSize, th.TreeSize)
+	if err != nil {
+		return err
+	}
+	if VerifyConsistency(previous.TreeSize, th.TreeSize, previous.RootHash, th.RootHash, proof) != nil {
+		return ErrorSplitView
+	}
+	return StoreTreeHead(host, th)
+}
+
+// VerifyProfileKeys checks the tree head and inclusion headers served with
+// a profile against the keys of that profile.
+func VerifyProfileKeys(host, treeHeadHeader, inclusionHeader, address, signingKeyBase64, encryptionKeyBase64 string) error {
+	th, err := TreeHeadFromHeader(treeHeadHeader)
+	if err != nil {
+		return err
+	}
+	inc, err := InclusionFromHeader(inclusionHeader)
+	if err != nil {
+		return err
+	}
+
+	entry, err := EntryFromLeafData(inc.LeafData)
+	if err != nil {
+		return err
+	}
+	if entry.Address != address || entry.SigningKeyBase64 != signingKeyBase64 || entry.EncryptionKeyBase64 != encryptionKeyBase64 {
+		return ErrorLeafMismatch
+	}
+	err = th.VerifySignature()
+	if err != nil {
+		return err
+	}
+	err = inc.Verify(th)
+	if err != nil {
+		return err
+	}
+	return CheckTreeHead(host, th, HTTPConsistencyFetcher(host))
+}
+
+func HTTPConsistencyFetcher(host string) ConsistencyFetcher {
+	return func(firstSize, secondSize int) ([][]byte, error) {
+		if !strings.HasPrefix(host, "http") {
+			host = "https://" + host
+		}
+		uri, err := url.ParseRequestURI(host + fmt.Sprintf(KEY_LOG_CONSISTENCY_PATH, consts.KEY_LOG_PATH_PREFIX, firstSize, secondSize))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.Get(uri.String())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("consistency proof unavailable: %s", resp.Status)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, consts.MAX_PROFILE_SIZE))
+		if err != nil {
+			return nil, err
+		}
+		return DecodeHashes(strings.ReplaceAll(strings.TrimSpace(string(body)), "\n", PROOF_PATH_SEPARATOR))
+	}
+}
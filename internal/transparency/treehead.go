@@ -0,0 +1,168 @@
+package transparency
+
+import (
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/utils"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/* Tree heads and inclusion proofs travel as header values:
+ *
+ *	Key-Log-Tree-Head: size=42; timestamp=...; root=BASE64; key=BASE64; signature=BASE64
+ *	Key-Log-Inclusion: index=7; size=42; leaf=BASE64; path=BASE64,BASE64,...
+ ************************************************************************/
+
+const KEY_LOG_TREE_HEAD_HEADER = "Key-Log-Tree-Head"
+const KEY_LOG_INCLUSION_HEADER = "Key-Log-Inclusion"
+
+const TREE_HEAD_SIGNATURE_CONTEXT = "openemail-keylog-v1"
+
+const ATTRIBUTE_SIZE = "size"
+const ATTRIBUTE_TIMESTAMP = "timestamp"
+const ATTRIBUTE_ROOT = "root"
+const ATTRIBUTE_KEY = "key"
+const ATTRIBUTE_SIGNATURE = "signature"
+const ATTRIBUTE_INDEX = "index"
+const ATTRIBUTE_LEAF = "leaf"
+const ATTRIBUTE_PATH = "path"
+
+const PROOF_PATH_SEPARATOR = ","
+
+var ErrorBadTreeHead = errors.New("bad key log tree head")
+var ErrorBadTreeHeadSignature = errors.New("bad key log tree head signature")
+
+type TreeHead struct {
+	TreeSize  int
+	Timestamp string
+	RootHash  []byte
+	Key       [32]byte
+	Signature string
+}
+
+type Inclusion struct {
+	Index    int
+	TreeSize int
+	LeafData []byte
+	Path     [][]byte
+}
+
+func (th *TreeHead) signedData() []byte {
+	return []byte(strings.Join([]string{
+		TREE_HEAD_SIGNATURE_CONTEXT,
+		strconv.Itoa(th.TreeSize),
+		th.Timestamp,
+		base64.StdEncoding.EncodeToString(th.RootHash),
+	}, "\n"))
+}
+
+func (th *TreeHead) KeyBase64() string {
+	return base64.StdEncoding.EncodeToString(th.Key[:])
+}
+
+func (th *TreeHead) VerifySignature() error {
+	if !crypto.VerifySignature(th.Key, th.Signature, th.signedData()) {
+		return ErrorBadTreeHeadSignature
+	}
+	return nil
+}
+
+func (th *TreeHead) ToHeader() string {
+	return strings.Join([]string{
+		ATTRIBUTE_SIZE + "=" + strconv.Itoa(th.TreeSize),
+		ATTRIBUTE_TIMESTAMP + "=" + th.Timestamp,
+		ATTRIBUTE_ROOT + "=" + base64.StdEncoding.EncodeToString(th.RootHash),
+		ATTRIBUTE_KEY + "=" + th.KeyBase64(),
+		ATTRIBUTE_SIGNATURE + "=" + th.Signature,
+	}, "; ")
+}
+
+func TreeHeadFromHeader(value string) (*TreeHead, error) {
+	attrs := utils.ParseHeadersAttributes(value)
+	var th TreeHead
+	var err error
+	th.TreeSize, err = strconv.Atoi(attrs[ATTRIBUTE_SIZE])
+	if err != nil || th.TreeSize < 0 {
+		return nil, ErrorBadTreeHead
+	}
+	th.Timestamp = attrs[ATTRIBUTE_TIMESTAMP]
+	th.RootHash, err = base64.StdEncoding.DecodeString(attrs[ATTRIBUTE_ROOT])
+	if err != nil || len(th.RootHash) == 0 {
+		return nil, ErrorBadTreeHead
+	}
+	th.Key, err = crypto.DecodeBase64Key32(attrs[ATTRIBUTE_KEY])
+	if err != nil {
+		return nil, ErrorBadTreeHead
+	}
+	th.Signature = attrs[ATTRIBUTE_SIGNATURE]
+	if th.Signature == "" || th.Timestamp == "" {
+		return nil, ErrorBadTreeHead
+	}
+	return &th, nil
+}
+
+func (inc *Inclusion) ToHeader() string {
+	return strings.Join([]string{
+		ATTRIBUTE_INDEX + "=" + strconv.Itoa(inc.Index),
+		ATTRIBUTE_SIZE + "=" + strconv.Itoa(inc.TreeSize),
+		ATTRIBUTE_LEAF + "=" + base64.StdEncoding.EncodeToString(inc.LeafData),
+		ATTRIBUTE_PATH + "=" + EncodeHashes(inc.Path),
+	}, "; ")
+}
+
+func InclusionFromHeader(value string) (*Inclusion, error) {
+	attrs := utils.ParseHeadersAttributes(value)
+	var inc Inclusion
+	var err error
+	inc.Index, err = strconv.Atoi(attrs[ATTRIBUTE_INDEX])
+	if err != nil {
+		return nil, ErrorBadProof
+	}
+	inc.TreeSize, err = strconv.Atoi(attrs[ATTRIBUTE_SIZE])
+	if err != nil {
+		return nil, ErrorBadProof
+	}
+	inc.LeafData, err = base64.StdEncoding.DecodeString(attrs[ATTRIBUTE_LEAF])
+	if err != nil {
+		return nil, ErrorBadProof
+	}
+	inc.Path, err = DecodeHashes(attrs[ATTRIBUTE_PATH])
+	if err != nil {
+		return nil, ErrorBadProof
+	}
+	return &inc, nil
+}
+
+// Verify checks that the leaf is included in the tree of the tree head.
+func (inc *Inclusion) Verify(th *TreeHead) error {
+	if inc.TreeSize != th.TreeSize {
+		return ErrorBadProof
+	}
+	return VerifyInclusion(LeafHash(inc.LeafData), inc.Index, inc.TreeSize, inc.Path, th.RootHash)
+}
+
+func EncodeHashes(hashes [][]byte) string {
+	encoded := make([]string, len(hashes))
+	for i, hash := range hashes {
+		encoded[i] = base64.StdEncoding.EncodeToString(hash)
+	}
+	return strings.Join(encoded, PROOF_PATH_SEPARATOR)
+}
+
+func DecodeHashes(value string) ([][]byte, error) {
+	var hashes [][]byte
+	for _, encoded := range strings.Split(value, PROOF_PATH_SEPARATOR) {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
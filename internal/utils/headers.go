@@ -9,6 +9,10 @@ func ParseHeadersAttributes(value string) map[string]string {
 	pairs := strings.Split(value, ";")
 	for _, kv := range pairs {
 		parts := strings.SplitN(kv, "=", 2)
+		// Pairs without a value are left out
+		if len(parts) != 2 {
+			continue
+		}
 		key := strings.ToLower(strings.TrimSpace(parts[0]))
 		headerAttrsMap[key] = strings.TrimSpace(parts[1])
 	}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// LockFile only opens the lock file where flock is missing, leaving
+// processes unserialized.
+func LockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
+
+func UnlockFile(file *os.File) error {
+	return file.Close()
+}
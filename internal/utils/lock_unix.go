@@ -0,0 +1,33 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockFile opens the lock file at the path, creating it if missing, and
+// waits until it holds it exclusively. The lock is shared with other
+// processes, such as the control command next to the server.
+func LockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func UnlockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
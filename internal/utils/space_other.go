@@ -0,0 +1,11 @@
+//go:build !unix
+
+package utils
+
+import "errors"
+
+var ErrorFreeSpaceUnknown = errors.New("free space unknown on this platform")
+
+func FreeSpace(path string) (int64, error) {
+	return 0, ErrorFreeSpaceUnknown
+}
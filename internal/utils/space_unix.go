@@ -0,0 +1,16 @@
+//go:build unix
+
+package utils
+
+import "syscall"
+
+// FreeSpace returns the bytes available to the server on the filesystem
+// holding the path.
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
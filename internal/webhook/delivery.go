@@ -0,0 +1,234 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"email.mercata.com/internal/utils"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/* Deliveries are POSTed as JSON with the headers:
+ *
+ *	Webhook-ID: <hook id>
+ *	Webhook-Timestamp: <unix seconds>
+ *	Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+ *
+ * keyed with the secret of the hook. Receivers should recompute the
+ * signature and reject stale timestamps to defeat replays.
+ *************************************************************************/
+
+const HEADER_WEBHOOK_ID = "Webhook-ID"
+const HEADER_WEBHOOK_TIMESTAMP = "Webhook-Timestamp"
+const HEADER_WEBHOOK_SIGNATURE = "Webhook-Signature"
+const SIGNATURE_PREFIX = "sha256="
+
+const DEFAULT_ATTEMPTS = 6
+const DEFAULT_BACKOFF = 5 * time.Second
+const DEFAULT_TIMEOUT = 10 * time.Second
+
+// Deliveries in flight at once, further ones wait for a free slot
+const MAX_CONCURRENT_DELIVERIES = 16
+
+// Most recent failed deliveries kept per account
+const MAX_DEAD_LETTERS = 100
+
+var ErrorPrivateAddress = errors.New("address is not public")
+
+var deadLettersMutex sync.Mutex
+
+type Payload struct {
+	ID          string `json:"id"`
+	Link        string `json:"link"`
+	Sequence    int    `json:"sequence"`
+	Received    string `json:"received"`
+	Notifier    string `json:"notifier"`
+	NotifierKey string `json:"notifier_key"`
+	ReaderKey   string `json:"reader_key"`
+}
+
+type Dispatcher struct {
+	Client   *http.Client
+	Attempts int
+	// Wait before the first retry, doubled on every further one
+	Backoff  time.Duration
+	ErrorLog *log.Logger
+
+	slots chan struct{}
+}
+
+// NewDispatcher returns a dispatcher refusing to connect to loopback,
+// private and link local addresses, unless allowPrivate is set.
+func NewDispatcher(allowPrivate bool, errorLog *log.Logger) *Dispatcher {
+	return &Dispatcher{
+		Client:   NewPublicClient(allowPrivate),
+		Attempts: DEFAULT_ATTEMPTS,
+		Backoff:  DEFAULT_BACKOFF,
+		ErrorLog: errorLog,
+		slots:    make(chan struct{}, MAX_CONCURRENT_DELIVERIES),
+	}
+}
+
+// NewPublicClient returns a client for addresses given by third parties,
+// which connects only to public addresses unless allowPrivate is set,
+// ignores proxy settings and does not follow redirects.
+func NewPublicClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: DEFAULT_TIMEOUT}
+	if !allowPrivate {
+		// Checked on the resolved address, so DNS cannot point requests inside
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicIP(ip) {
+				return ErrorPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: DEFAULT_TIMEOUT,
+		Transport: &http.Transport{
+			// Through a proxy the dialer would check the proxy, not the target
+			Proxy:       nil,
+			DialContext: dialer.DialContext,
+		},
+		// A redirect could lead past the address check of the dialer
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast())
+}
+
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature is meant for receivers checking a delivery.
+func VerifySignature(secret, timestamp, signature string, body []byte) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Deliver posts the payload to every hook of the account in the background.
+func (d *Dispatcher) Deliver(userHomeDirPath string, payload *Payload) {
+	hooks, err := List(userHomeDirPath)
+	if err != nil {
+		d.logError("webhooks of %s: %s", userHomeDirPath, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		d.logError("webhook payload: %s", err)
+		return
+	}
+	for _, hook := range hooks {
+		go d.deliver(userHomeDirPath, hook, body)
+	}
+}
+
+func (d *Dispatcher) deliver(userHomeDirPath string, hook *Webhook, body []byte) {
+	backoff := d.Backoff
+	var err error
+	for attempt := 1; attempt <= d.Attempts; attempt++ {
+		err = d.post(hook, body)
+		if err == nil {
+			return
+		}
+		if attempt < d.Attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	d.logError("webhook %s failed after %d attempts: %s", hook.ID, d.Attempts, err)
+	if err = appendDeadLetter(userHomeDirPath, hook, d.Attempts, err, body); err != nil {
+		d.logError("webhook dead letter: %s", err)
+	}
+}
+
+func (d *Dispatcher) post(hook *Webhook, body []byte) error {
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_WEBHOOK_ID, hook.ID)
+	req.Header.Set(HEADER_WEBHOOK_TIMESTAMP, timestamp)
+	req.Header.Set(HEADER_WEBHOOK_SIGNATURE, Sign(hook.Secret, timestamp, body))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("response code %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (d *Dispatcher) logError(format string, v ...any) {
+	if d.ErrorLog != nil {
+		d.ErrorLog.Printf(format, v...)
+	}
+}
+
+func appendDeadLetter(userHomeDirPath string, hook *Webhook, attempts int, deliveryErr error, body []byte) error {
+	// Commas and newlines would break the columns
+	reason := strings.NewReplacer(WEBHOOKS_COLUMN_SEPARATOR, " ", "\n", " ", "\r", " ").Replace(deliveryErr.Error())
+	line := strings.Join([]string{
+		utils.ToRFC3339String(utils.TimestampNow()),
+		hook.ID,
+		strconv.Itoa(attempts),
+		reason,
+		base64.StdEncoding.EncodeToString(body),
+	}, WEBHOOKS_COLUMN_SEPARATOR)
+
+	deadLettersMutex.Lock()
+	defer deadLettersMutex.Unlock()
+
+	lines, err := DeadLetters(userHomeDirPath)
+	if err != nil {
+		return err
+	}
+	lines = append(lines, line)
+	if len(lines) > MAX_DEAD_LETTERS {
+		lines = lines[len(lines)-MAX_DEAD_LETTERS:]
+	}
+	path := DeadLettersPath(userHomeDirPath)
+	err = os.WriteFile(path+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDispatcher delivers to the receiver, which listens on loopback
+func testDispatcher(t *testing.T, attempts int, receiver http.HandlerFunc) (*Dispatcher, string, *Webhook) {
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	d := NewDispatcher(true, nil)
+	d.Attempts = attempts
+	d.Backoff = time.Millisecond
+
+	userHomeDirPath := t.TempDir()
+	hook, err := Add(userHomeDirPath, server.URL+"/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, userHomeDirPath, hook
+}
+
+func testBody(t *testing.T) []byte {
+	body, err := json.Marshal(&Payload{ID: "n1", Link: "link", Sequence: 1, Received: "2023-08-01T10:00:00Z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestDeliverySigned(t *testing.T) {
+	var secret string
+	var received atomic.Int32
+	d, userHomeDirPath, hook := testDispatcher(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if r.Header.Get(HEADER_WEBHOOK_ID) == "" {
+			t.Error("no hook ID")
+		}
+		timestamp := r.Header.Get(HEADER_WEBHOOK_TIMESTAMP)
+		signature := r.Header.Get(HEADER_WEBHOOK_SIGNATURE)
+		if !strings.HasPrefix(signature, SIGNATURE_PREFIX) || !VerifySignature(secret, timestamp, signature, body) {
+			t.Errorf("bad signature %q of timestamp %q", signature, timestamp)
+		}
+		if VerifySignature(secret, timestamp+"0", signature, body) {
+			t.Error("signature verifies with another timestamp")
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil || payload.ID != "n1" {
+			t.Errorf("bad payload %s: %v", body, err)
+		}
+	})
+	secret = hook.Secret
+
+	d.deliver(userHomeDirPath, hook, testBody(t))
+	if received.Load() != 1 {
+		t.Fatalf("received %d deliveries, want 1", received.Load())
+	}
+	letters, err := DeadLetters(userHomeDirPath)
+	if err != nil || len(letters) != 0 {
+		t.Errorf("dead letters %v: %v", letters, err)
+	}
+}
+
+func TestDeliveryRetried(t *testing.T) {
+	var received atomic.Int32
+	d, userHomeDirPath, hook := testDispatcher(t, 5, func(w http.ResponseWriter, r *http.Request) {
+		// Fails twice before taking it
+		if received.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	d.deliver(userHomeDirPath, hook, testBody(t))
+	if received.Load() != 3 {
+		t.Errorf("received %d deliveries, want 3", received.Load())
+	}
+	letters, err := DeadLetters(userHomeDirPath)
+	if err != nil || len(letters) != 0 {
+		t.Errorf("dead letters %v: %v", letters, err)
+	}
+}
+
+func TestDeliveryDeadLetter(t *testing.T) {
+	var received atomic.Int32
+	d, userHomeDirPath, hook := testDispatcher(t, 3, func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	d.deliver(userHomeDirPath, hook, testBody(t))
+	if received.Load() != 3 {
+		t.Errorf("received %d deliveries, want 3", received.Load())
+	}
+	letters, err := DeadLetters(userHomeDirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(letters))
+	}
+	columns := strings.Split(letters[0], WEBHOOKS_COLUMN_SEPARATOR)
+	if len(columns) != 5 || columns[1] != hook.ID || columns[2] != "3" || !strings.Contains(columns[3], "500") {
+		t.Errorf("bad dead letter %q", letters[0])
+	}
+}
+
+func TestPublicClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewPublicClient(false).Get(server.URL)
+	if !errors.Is(err, ErrorPrivateAddress) {
+		t.Errorf("got %v, want %v", err, ErrorPrivateAddress)
+	}
+	res, err := NewPublicClient(true).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
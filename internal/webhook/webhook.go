@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bufio"
+	"email.mercata.com/internal/crypto"
+	"email.mercata.com/internal/utils"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/* Webhooks of an account, one per line in <home>/webhooks:
+ *
+ *	id=...; url=https://...; secret=...; created=RFC3339
+ *
+ * Every new notification is posted to each hook, signed with its secret.
+ * Deliveries failing all attempts are appended to <home>/webhooks.dead:
+ *
+ *	failed,hook id,attempts,error,payload (base64)
+ ************************************************************************/
+
+const WEBHOOKS_FILENAME = "webhooks"
+const WEBHOOKS_DEAD_LETTERS_FILENAME = "webhooks.dead"
+const WEBHOOKS_COLUMN_SEPARATOR = ","
+
+const ATTRIBUTE_ID = "id"
+const ATTRIBUTE_URL = "url"
+const ATTRIBUTE_SECRET = "secret"
+const ATTRIBUTE_CREATED = "created"
+
+const WEBHOOK_ID_LENGTH = 16
+const WEBHOOK_SECRET_LENGTH = 32
+const MAX_WEBHOOKS = 10
+
+var ErrorBadWebhookURL = errors.New("webhook URL must be an absolute http or https URL")
+var ErrorTooManyWebhooks = errors.New("too many webhooks")
+var ErrorNoWebhook = errors.New("no such webhook")
+
+var webhooksMutex sync.Mutex
+
+type Webhook struct {
+	ID      string
+	URL     string
+	Secret  string
+	Created string
+}
+
+func WebhooksPath(userHomeDirPath string) string {
+	return filepath.Join(userHomeDirPath, WEBHOOKS_FILENAME)
+}
+
+func DeadLettersPath(userHomeDirPath string) string {
+	return filepath.Join(userHomeDirPath, WEBHOOKS_DEAD_LETTERS_FILENAME)
+}
+
+func (h *Webhook) toLine() string {
+	return strings.Join([]string{
+		ATTRIBUTE_ID + "=" + h.ID,
+		ATTRIBUTE_URL + "=" + h.URL,
+		ATTRIBUTE_SECRET + "=" + h.Secret,
+		ATTRIBUTE_CREATED + "=" + h.Created,
+	}, "; ")
+}
+
+// ToPublicLine lists the hook to the owner, without the secret
+func (h *Webhook) ToPublicLine() string {
+	return strings.Join([]string{h.ID, h.Created, h.URL}, WEBHOOKS_COLUMN_SEPARATOR)
+}
+
+func ValidURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return false
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	// Values are stored as header attributes
+	return !strings.ContainsAny(rawURL, "; \r\n")
+}
+
+func List(userHomeDirPath string) ([]*Webhook, error) {
+	var hooks []*Webhook
+	file, err := os.Open(WebhooksPath(userHomeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return hooks, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || !strings.Contains(line, "=") {
+			continue
+		}
+		attrs := utils.ParseHeadersAttributes(line)
+		hook := &Webhook{
+			ID:      attrs[ATTRIBUTE_ID],
+			URL:     attrs[ATTRIBUTE_URL],
+			Secret:  attrs[ATTRIBUTE_SECRET],
+			Created: attrs[ATTRIBUTE_CREATED],
+		}
+		if hook.ID == "" || hook.URL == "" || hook.Secret == "" {
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, scanner.Err()
+}
+
+// Add registers a hook for the URL, with a fresh secret returned only now.
+func Add(userHomeDirPath, rawURL string) (*Webhook, error) {
+	if !ValidURL(rawURL) {
+		return nil, ErrorBadWebhookURL
+	}
+
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+
+	hooks, err := List(userHomeDirPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) >= MAX_WEBHOOKS {
+		return nil, ErrorTooManyWebhooks
+	}
+
+	id, err := crypto.GenerateRandomString(WEBHOOK_ID_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := crypto.GenerateRandomString(WEBHOOK_SECRET_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	hook := &Webhook{
+		ID:      id,
+		URL:     rawURL,
+		Secret:  secret,
+		Created: utils.ToRFC3339String(utils.TimestampNow()),
+	}
+	return hook, write(userHomeDirPath, append(hooks, hook))
+}
+
+func Remove(userHomeDirPath, id string) error {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+
+	hooks, err := List(userHomeDirPath)
+	if err != nil {
+		return err
+	}
+	var remaining []*Webhook
+	for _, hook := range hooks {
+		if hook.ID != id {
+			remaining = append(remaining, hook)
+		}
+	}
+	if len(remaining) == len(hooks) {
+		return ErrorNoWebhook
+	}
+	return write(userHomeDirPath, remaining)
+}
+
+func write(userHomeDirPath string, hooks []*Webhook) error {
+	var lines []string
+	for _, hook := range hooks {
+		lines = append(lines, hook.toLine()+"\n")
+	}
+	path := WebhooksPath(userHomeDirPath)
+	// Secrets are readable by the server only
+	err := os.WriteFile(path+".tmp", []byte(strings.Join(lines, "")), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func DeadLetters(userHomeDirPath string) ([]string, error) {
+	var lines []string
+	data, err := os.ReadFile(DeadLettersPath(userHomeDirPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lines, nil
+		}
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}